@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"fullstack-backend/internal/config"
 	"fullstack-backend/internal/database"
 	"fullstack-backend/internal/handlers"
+	"fullstack-backend/internal/jobs"
 	"fullstack-backend/internal/middleware"
+	"fullstack-backend/internal/payment"
+	"fullstack-backend/internal/queue"
+	"fullstack-backend/internal/secretbox"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,6 +34,49 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Encrypt reverify API keys stored before DATA_ENCRYPTION_KEY was introduced
+	if sealed, err := database.SealReverifyAPIKeys(db, secretbox.New(cfg.DataEncryptionKey)); err != nil {
+		log.Fatal("Failed to encrypt stored API keys:", err)
+	} else if sealed > 0 {
+		log.Printf("Encrypted %d stored reverify API key(s)", sealed)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	emailHandler := handlers.NewEmailHandler(db, cfg)
+	accountHandler := handlers.NewAccountHandler(db, cfg)
+	providers, err := payment.NewRegistry(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure payment providers:", err)
+	}
+	log.Printf("Payment providers enabled: %v", providers.Names())
+
+	paymentHandler := handlers.NewPaymentHandler(db, providers, cfg)
+	subscriptionHandler := handlers.NewSubscriptionHandler(db, cfg)
+
+	// Background jobs
+	scheduler := jobs.NewScheduler(db)
+	mustRegister(scheduler, "email_reverify", "* * * * *", emailHandler.RunDueReverifySchedules)
+	mustRegister(scheduler, "temporary_usage_sweeper", "* * * * *", accountHandler.ReleaseExpiredTemporary)
+	mustRegister(scheduler, "payment_order_expiry", "* * * * *", paymentHandler.ExpirePendingOrders)
+	mustRegister(scheduler, "subscription_expiry", "*/5 * * * *", subscriptionHandler.ExpireSubscriptions)
+	mustRegister(scheduler, "subscription_renewal", "*/10 * * * *", subscriptionHandler.RenewSubscriptions)
+	mustRegister(scheduler, "license_key_exhaustion", "*/10 * * * *", paymentHandler.MarkExhaustedKeys)
+	if err := scheduler.Start(ctx); err != nil {
+		log.Fatal("Failed to start scheduler:", err)
+	}
+
+	// In-process queue workers; set QUEUE_WORKERS=0 and run cmd/worker to scale them separately
+	if cfg.QueueWorkers > 0 {
+		worker := queue.NewWorker(db, queue.WorkerOptions{
+			Concurrency:       cfg.QueueWorkers,
+			VisibilityTimeout: cfg.QueueVisibilityTimeout,
+		})
+		handlers.RegisterQueueHandlers(worker, emailHandler)
+		go worker.Run(ctx)
+	}
+
 	// Initialize Gin router
 	router := gin.Default()
 
@@ -71,69 +121,154 @@ func main() {
 		emails := v1.Group("/emails")
 		emails.Use(middleware.AuthMiddleware(cfg.JWTSecret))
 		{
-			emailHandler := handlers.NewEmailHandler(db)
 			emails.GET("", emailHandler.GetEmails)
 			emails.GET("/imports", emailHandler.GetEmailImports)
+			emails.GET("/schedules", emailHandler.GetReverifySchedules)
+			emails.POST("/schedules", emailHandler.CreateReverifySchedule)
+			emails.PUT("/schedules/:id", emailHandler.UpdateReverifySchedule)
+			emails.DELETE("/schedules/:id", emailHandler.DeleteReverifySchedule)
+			emails.GET("/schedules/:id/runs", emailHandler.GetReverifyRuns)
+			emails.GET("/jobs/:id", emailHandler.GetEmailJob)
 			emails.GET("/:id", emailHandler.GetEmail)
 			emails.POST("", emailHandler.CreateEmail)
 			emails.POST("/import",
 				middleware.LicenseKeyMiddleware(db, "email_import"),
 				emailHandler.ImportEmails,
 			)
-			// 邮箱验证需要 License Key 和消耗额度
+			// 邮箱验证需要 License Key，额度在队列任务完成时扣减
 			emails.POST("/verify",
 				middleware.LicenseKeyMiddleware(db, "email_verify"),
-				middleware.ConsumeQuota(db, 1),
 				emailHandler.VerifyEmails,
 			)
 			emails.PUT("/:id", emailHandler.UpdateEmail)
 			emails.DELETE("/:id", emailHandler.DeleteEmail)
 		}
 
+		// 个人资产不要求有效订阅：订阅过期后仍可查看已购买的账号和历史记录
+		v1.GET("/accounts/mine", middleware.AuthMiddleware(cfg.JWTSecret), accountHandler.GetMyAccounts)
+
 		// Account platform routes (subscription required)
 		accounts := v1.Group("/accounts")
 		accounts.Use(middleware.AuthMiddleware(cfg.JWTSecret))
 		accounts.Use(middleware.SubscriptionMiddleware(db))
 		{
-			accountHandler := handlers.NewAccountHandler(db)
 			accounts.GET("", accountHandler.ListAccounts)
+			accounts.POST("/allocate", accountHandler.AllocateAccount)
 			accounts.POST("/temporary/claim", accountHandler.ClaimTemporary)
 			accounts.POST("/temporary/release", accountHandler.ReleaseTemporary)
-			accounts.POST("/exclusive/purchase", accountHandler.PurchaseExclusive)
+			accounts.POST("/temporary/extend", accountHandler.ExtendTemporary)
+			accounts.GET("/temporary/waitlist", accountHandler.GetMyWaitlist)
+			accounts.POST("/temporary/waitlist", accountHandler.JoinWaitlist)
+			accounts.DELETE("/temporary/waitlist/:id", accountHandler.LeaveWaitlist)
+			accounts.GET("/cart", accountHandler.GetCart)
+			accounts.POST("/cart", accountHandler.AddToCart)
+			accounts.DELETE("/cart/:account_id", accountHandler.RemoveFromCart)
+			accounts.POST("/cart/checkout", paymentHandler.CheckoutCart)
 			accounts.GET("/exclusive/:id/credentials", accountHandler.GetExclusiveCredentials)
 			accounts.POST("/family/bind", accountHandler.BindFamily)
 			accounts.POST("/family/unbind", accountHandler.UnbindFamily)
 		}
 
+		// Notification routes
+		notifications := v1.Group("/notifications")
+		notifications.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+		{
+			notificationHandler := handlers.NewNotificationHandler(db)
+			notifications.GET("", notificationHandler.GetNotifications)
+			notifications.POST("/:id/read", notificationHandler.MarkNotificationRead)
+		}
+
 		// Subscription routes
 		subscriptions := v1.Group("/subscriptions")
 		subscriptions.Use(middleware.AuthMiddleware(cfg.JWTSecret))
 		{
-			subscriptionHandler := handlers.NewSubscriptionHandler(db)
 			subscriptions.GET("/me", subscriptionHandler.GetMySubscription)
-			subscriptions.POST("/activate", subscriptionHandler.ActivateSubscription)
+			subscriptions.GET("/plans", paymentHandler.ListSubscriptionPlans)
+			subscriptions.POST("/orders", paymentHandler.CreateSubscriptionOrder)
+			subscriptions.POST("/trial", subscriptionHandler.StartTrial)
+			subscriptions.POST("/cancel", subscriptionHandler.CancelSubscription)
+			subscriptions.POST("/resume", subscriptionHandler.ResumeSubscription)
 		}
 
 		// Payment routes
 		payments := v1.Group("/payments")
 		payments.Use(middleware.AuthMiddleware(cfg.JWTSecret))
 		{
-			paymentHandler := handlers.NewPaymentHandler(db)
 			payments.GET("/products", paymentHandler.GetProducts)
 			payments.POST("/orders", paymentHandler.CreateOrder)
+			payments.POST("/coupons/validate", paymentHandler.ValidateCoupon)
+			payments.GET("/orders", paymentHandler.ListOrders)
 			payments.GET("/orders/:order_no", paymentHandler.GetOrder)
-			payments.POST("/notify", paymentHandler.PaymentNotify) // 支付回调
+			payments.POST("/orders/:order_no/cancel", paymentHandler.CancelOrder)
+			payments.POST("/orders/:order_no/checkout", paymentHandler.CheckoutOrder)
+			payments.GET("/orders/:order_no/status", paymentHandler.GetOrderStatus)
+			payments.GET("/orders/:order_no/invoice", paymentHandler.GetInvoice)
+		}
+
+		// 支付平台回调不经过用户认证，由各平台签名校验
+		v1.POST("/payments/notify/:provider", paymentHandler.PaymentNotify)
+
+		// 模拟支付网关的支付页面，仅在配置 LOCAL_PAYMENT_SECRET 时启用
+		if providers.Mock() != nil {
+			v1.GET("/payments/mock/:order_no", paymentHandler.MockPayPage)
+			v1.POST("/payments/mock/:order_no", paymentHandler.MockPay)
 		}
 
 		// License Key routes
 		keys := v1.Group("/keys")
 		keys.Use(middleware.AuthMiddleware(cfg.JWTSecret))
 		{
-			paymentHandler := handlers.NewPaymentHandler(db)
 			keys.GET("", paymentHandler.GetMyKeys)
 			keys.POST("/activate", paymentHandler.ActivateKey)
 			keys.POST("/check", paymentHandler.CheckKey)
 		}
+
+		// Admin routes
+		admin := v1.Group("/admin")
+		admin.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+		admin.Use(middleware.AdminMiddleware(db))
+		{
+			jobHandler := handlers.NewJobHandler(db)
+			admin.GET("/jobs", jobHandler.ListJobs)
+			admin.GET("/jobs/runs", jobHandler.ListJobRuns)
+
+			accountAdminHandler := handlers.NewAccountAdminHandler(db)
+			admin.GET("/accounts", accountAdminHandler.ListAccounts)
+			admin.GET("/accounts/stats", accountAdminHandler.GetAccountStats)
+			admin.POST("/accounts", accountAdminHandler.CreateAccount)
+			admin.POST("/accounts/import", accountAdminHandler.ImportAccounts)
+			admin.GET("/accounts/:id", accountAdminHandler.GetAccount)
+			admin.PUT("/accounts/:id", accountAdminHandler.UpdateAccount)
+			admin.POST("/accounts/:id/retire", accountAdminHandler.RetireAccount)
+			admin.PUT("/accounts/:id/family", accountAdminHandler.UpdateFamilyCapacity)
+			admin.DELETE("/accounts/:id", accountAdminHandler.DeleteAccount)
+
+			productAdminHandler := handlers.NewProductAdminHandler(db)
+			admin.GET("/products", productAdminHandler.ListProducts)
+			admin.POST("/products", productAdminHandler.CreateProduct)
+			admin.GET("/products/:id", productAdminHandler.GetProduct)
+			admin.PUT("/products/:id", productAdminHandler.UpdateProduct)
+
+			admin.POST("/users/:id/subscriptions", subscriptionHandler.GrantSubscription)
+
+			couponAdminHandler := handlers.NewCouponAdminHandler(db)
+			admin.GET("/coupons", couponAdminHandler.ListCoupons)
+			admin.POST("/coupons", couponAdminHandler.CreateCoupon)
+			admin.GET("/coupons/:id", couponAdminHandler.GetCoupon)
+			admin.PUT("/coupons/:id", couponAdminHandler.UpdateCoupon)
+
+			paymentAdminHandler := handlers.NewPaymentAdminHandler(db, providers)
+			admin.GET("/payments/orders/:order_no/refunds", paymentAdminHandler.ListRefunds)
+			admin.POST("/payments/orders/:order_no/refunds", paymentAdminHandler.RefundOrder)
+			admin.POST("/payments/refunds/:refund_no/retry", paymentAdminHandler.RetryRefund)
+			admin.GET("/payments/orphans", paymentAdminHandler.ListOrphanPayments)
+			admin.POST("/payments/orphans/:id/refund", paymentAdminHandler.RefundOrphanPayment)
+
+			queueHandler := handlers.NewQueueHandler(db)
+			admin.GET("/queue", queueHandler.GetQueueStats)
+			admin.GET("/queue/jobs", queueHandler.ListQueueJobs)
+			admin.POST("/queue/jobs/:id/retry", queueHandler.RetryQueueJob)
+		}
 	}
 
 	// Start server
@@ -147,3 +282,9 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+func mustRegister(scheduler *jobs.Scheduler, name, spec string, run jobs.JobFunc) {
+	if err := scheduler.Register(name, spec, run); err != nil {
+		log.Fatal("Failed to register job:", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/database"
+	"fullstack-backend/internal/handlers"
+	"fullstack-backend/internal/queue"
+)
+
+func main() {
+	// Load configuration
+	cfg := config.Load()
+
+	// Initialize database
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+
+	// Run migrations
+	if err := database.Migrate(db); err != nil {
+		log.Fatal("Failed to run migrations:", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	concurrency := cfg.QueueWorkers
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var queues []string
+	if raw := os.Getenv("QUEUE_NAMES"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				queues = append(queues, name)
+			}
+		}
+	}
+
+	worker := queue.NewWorker(db, queue.WorkerOptions{
+		Queues:            queues,
+		Concurrency:       concurrency,
+		VisibilityTimeout: cfg.QueueVisibilityTimeout,
+	})
+	handlers.RegisterQueueHandlers(worker, handlers.NewEmailHandler(db, cfg))
+
+	log.Printf("Worker starting with concurrency %d", concurrency)
+	worker.Run(ctx)
+	log.Println("Worker stopped")
+}
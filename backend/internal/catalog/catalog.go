@@ -0,0 +1,197 @@
+// Package catalog reads the product catalog (products and their immutable
+// price/entitlement versions) shared by order creation and license checks.
+package catalog
+
+import (
+	"errors"
+	"sync"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrProductNotFound 产品不存在或已下架
+var ErrProductNotFound = errors.New("product not found")
+
+// 产品种类，决定订单支付后发放的权益
+const (
+	KindLicense      = "license"      // 发放 License Key
+	KindSubscription = "subscription" // 开通或续期订阅
+)
+
+// Features 可以授予 License Key 的功能权限，与路由上 LicenseKeyMiddleware 的参数对应
+var Features = []string{
+	"email_verify",
+	"email_import",
+	"task_management",
+	"api_access",
+	"priority_support",
+}
+
+// IsFeature 判断是否为已知的功能权限
+func IsFeature(feature string) bool {
+	for _, f := range Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Offer 产品及其一个版本。ActiveOffers / FindOffer 返回当前版本，即用户当前可以购买的内容
+type Offer struct {
+	ProductID    uint     `json:"product_id"`
+	VersionID    uint     `json:"version_id"`
+	Version      int      `json:"version"`
+	Type         string   `json:"type"`
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Price        int      `json:"price"`                   // 价格（分）
+	QuotaAmount  int      `json:"quota_amount"`            // 次数额度
+	DurationDays int      `json:"duration_days,omitempty"` // 订阅时长（天）
+	Features     []string `json:"features"`
+	Entitlements []string `json:"entitlements"`
+}
+
+func newOffer(product models.Product, version models.ProductVersion) Offer {
+	return Offer{
+		ProductID:    product.ID,
+		VersionID:    version.ID,
+		Version:      version.Version,
+		Type:         product.Type,
+		Kind:         product.Kind,
+		Name:         product.Name,
+		Description:  product.Description,
+		Price:        version.Price,
+		QuotaAmount:  version.QuotaAmount,
+		DurationDays: version.DurationDays,
+		Features:     version.Features,
+		Entitlements: version.Entitlements,
+	}
+}
+
+// ActiveOffers 指定种类的上架产品的当前版本，按 sort_order 排序
+func ActiveOffers(db *gorm.DB, kind string) ([]Offer, error) {
+	var products []models.Product
+	if err := db.Where("kind = ? AND active = ? AND current_version_id IS NOT NULL", kind, true).
+		Order("sort_order asc, id asc").
+		Find(&products).Error; err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return []Offer{}, nil
+	}
+
+	versionIDs := make([]uint, 0, len(products))
+	for _, product := range products {
+		versionIDs = append(versionIDs, *product.CurrentVersionID)
+	}
+	var versions []models.ProductVersion
+	if err := db.Where("id IN ?", versionIDs).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.ProductVersion, len(versions))
+	for _, version := range versions {
+		byID[version.ID] = version
+	}
+
+	offers := make([]Offer, 0, len(products))
+	for _, product := range products {
+		if version, ok := byID[*product.CurrentVersionID]; ok {
+			offers = append(offers, newOffer(product, version))
+		}
+	}
+	return offers, nil
+}
+
+// FindOffer 按产品类型查找可购买的当前版本，产品不存在或已下架时返回 ErrProductNotFound
+func FindOffer(db *gorm.DB, productType string) (*Offer, error) {
+	var product models.Product
+	if err := db.Where("type = ? AND active = ? AND current_version_id IS NOT NULL", productType, true).
+		First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+
+	var version models.ProductVersion
+	if err := db.First(&version, *product.CurrentVersionID).Error; err != nil {
+		return nil, err
+	}
+	offer := newOffer(product, version)
+	return &offer, nil
+}
+
+// VersionOffer 查找订单下单时的产品版本，不要求产品仍在售
+func VersionOffer(db *gorm.DB, versionID uint) (*Offer, error) {
+	var version models.ProductVersion
+	if err := db.First(&version, versionID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	var product models.Product
+	if err := db.First(&product, version.ProductID).Error; err != nil {
+		return nil, err
+	}
+	offer := newOffer(product, version)
+	return &offer, nil
+}
+
+// ProductName 产品的展示名称，产品不存在时返回类型本身
+func ProductName(db *gorm.DB, productType string) string {
+	var product models.Product
+	if err := db.Select("name").Where("type = ?", productType).First(&product).Error; err != nil {
+		return productType
+	}
+	return product.Name
+}
+
+// 产品版本创建后不再修改，权限可以安全地缓存在进程内
+var entitlementCache sync.Map // version id -> []string
+
+// KeyEntitlements 返回 License Key 购买时版本的功能权限。
+// 早于商品目录创建、没有记录版本的 Key 使用其产品类型的第 1 个版本。
+func KeyEntitlements(db *gorm.DB, key *models.LicenseKey) ([]string, error) {
+	if key.ProductVersionID != nil {
+		if cached, ok := entitlementCache.Load(*key.ProductVersionID); ok {
+			return cached.([]string), nil
+		}
+	}
+
+	var version models.ProductVersion
+	query := db.Model(&models.ProductVersion{})
+	if key.ProductVersionID != nil {
+		query = query.Where("id = ?", *key.ProductVersionID)
+	} else {
+		query = query.Joins("JOIN products ON products.id = product_versions.product_id").
+			Where("products.type = ? AND product_versions.version = ?", key.ProductType, 1)
+	}
+	if err := query.First(&version).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	entitlementCache.Store(version.ID, version.Entitlements)
+	return version.Entitlements, nil
+}
+
+// HasFeature 判断 License Key 是否拥有指定功能权限
+func HasFeature(db *gorm.DB, key *models.LicenseKey, feature string) (bool, error) {
+	entitlements, err := KeyEntitlements(db, key)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range entitlements {
+		if f == feature {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"log"
+
 	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/secretbox"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -10,7 +13,8 @@ import (
 
 func Connect(dsn string) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger:         logger.Default.LogMode(logger.Info),
+		TranslateError: true, // 唯一约束冲突等错误转换为 gorm.ErrDuplicatedKey
 	})
 	if err != nil {
 		return nil, err
@@ -20,7 +24,7 @@ func Connect(dsn string) (*gorm.DB, error) {
 }
 
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Task{},
 		&models.Email{},
@@ -29,11 +33,244 @@ func Migrate(db *gorm.DB) error {
 		&models.Account{},
 		&models.TemporaryUsage{},
 		&models.ExclusivePurchase{},
+		&models.CartItem{},
 		&models.FamilyGroup{},
 		&models.FamilyBinding{},
 		&models.Subscription{},
 		&models.AuditLog{},
 		&models.Payment{},
+		&models.PaymentItem{},
+		&models.PaymentNotification{},
+		&models.Refund{},
+		&models.OrphanPayment{},
+		&models.Invoice{},
+		&models.InvoiceSequence{},
+		&models.Product{},
+		&models.ProductVersion{},
+		&models.Coupon{},
 		&models.LicenseKey{},
-	)
+		&models.VerificationResult{},
+		&models.QuotaLedger{},
+		&models.ReverifySchedule{},
+		&models.ReverifyRun{},
+		&models.EmailJob{},
+		&models.ScheduledJob{},
+		&models.JobRun{},
+		&models.QueueJob{},
+		&models.AccountWaitlist{},
+		&models.Notification{},
+	); err != nil {
+		return err
+	}
+
+	if err := migrateConstraints(db); err != nil {
+		return err
+	}
+	if err := migrateCatalog(db); err != nil {
+		return err
+	}
+	return migrateSubscriptions(db)
+}
+
+// migrateConstraints 创建 AutoMigrate 无法表达的约束（部分唯一索引等）
+func migrateConstraints(db *gorm.DB) error {
+	// 同一用户在同一家庭组只能有一条未解绑的记录。
+	// 建索引前先软删除历史遗留的重复绑定，保留最早的一条。
+	if err := db.Exec(`
+		UPDATE family_bindings SET deleted_at = NOW()
+		WHERE deleted_at IS NULL AND id NOT IN (
+			SELECT MIN(id) FROM family_bindings WHERE deleted_at IS NULL GROUP BY family_group_id, user_id
+		)`).Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_family_bindings_active_user
+		ON family_bindings (family_group_id, user_id) WHERE deleted_at IS NULL`).Error; err != nil {
+		return err
+	}
+
+	// 同一用户对同一账号（account_id 为空表示任意账号）只能有一条排队中的记录。
+	// 建索引前先取消历史遗留的重复排队，保留最早的一条。
+	if err := db.Exec(`
+		UPDATE account_waitlists SET status = 'canceled', updated_at = NOW()
+		WHERE status = 'waiting' AND id NOT IN (
+			SELECT MIN(id) FROM account_waitlists WHERE status = 'waiting' GROUP BY user_id, COALESCE(account_id, 0)
+		)`).Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_account_waitlists_waiting_user
+		ON account_waitlists (user_id, COALESCE(account_id, 0)) WHERE status = 'waiting'`).Error; err != nil {
+		return err
+	}
+
+	// 独享账号退款后保留购买记录，同一账号只能有一条未退款的记录，退款后可以再次售出
+	if err := db.Exec(`DROP INDEX IF EXISTS idx_exclusive_purchases_account_id`).Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_exclusive_purchases_active_account
+		ON exclusive_purchases (account_id) WHERE refunded_at IS NULL`).Error; err != nil {
+		return err
+	}
+
+	// 验证缓存改为按地址和验证方式区分，删除旧的仅按地址的唯一索引
+	if err := db.Exec(`DROP INDEX IF EXISTS idx_verification_results_address`).Error; err != nil {
+		return err
+	}
+
+	// 同一类型的账号 main 不能重复，并发创建或修改时由唯一索引拦截。
+	// 历史数据已有重复时不建索引，需要管理员先处理重复账号
+	var duplicated int64
+	if err := db.Raw(`
+		SELECT COUNT(*) FROM (
+			SELECT 1 FROM accounts WHERE deleted_at IS NULL GROUP BY type, main HAVING COUNT(*) > 1
+		) d`).Scan(&duplicated).Error; err != nil {
+		return err
+	}
+	if duplicated > 0 {
+		log.Printf("database: %d duplicated (type, main) accounts, skipping idx_accounts_type_main", duplicated)
+	} else if err := db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_type_main
+		ON accounts (type, main) WHERE deleted_at IS NULL`).Error; err != nil {
+		return err
+	}
+
+	// 同一支付平台流水号只能对应一笔订单，防止一次付款重复发放权益
+	return db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_transaction
+		ON payments (payment_method, transaction_id) WHERE transaction_id <> ''`).Error
+}
+
+// defaultProducts 商品目录中缺少时写入的初始产品。License 套餐与商品目录上线前硬编码的套餐一致
+var defaultProducts = []struct {
+	product models.Product
+	version models.ProductVersion
+}{
+	{
+		models.Product{Type: "basic", Kind: "license", Name: "基础版", SortOrder: 1, Active: true},
+		models.ProductVersion{
+			Price: 1000, QuotaAmount: 100,
+			Features:     []string{"邮箱验证 100 次", "基础功能"},
+			Entitlements: []string{"email_verify"},
+		},
+	},
+	{
+		models.Product{Type: "pro", Kind: "license", Name: "专业版", SortOrder: 2, Active: true},
+		models.ProductVersion{
+			Price: 3000, QuotaAmount: 500,
+			Features:     []string{"邮箱验证 500 次", "所有功能", "优先支持"},
+			Entitlements: []string{"email_verify", "email_import", "task_management"},
+		},
+	},
+	{
+		models.Product{Type: "enterprise", Kind: "license", Name: "企业版", SortOrder: 3, Active: true},
+		models.ProductVersion{
+			Price: 5000, QuotaAmount: 1000,
+			Features:     []string{"邮箱验证 1000 次", "所有功能", "专属客服", "API 访问"},
+			Entitlements: []string{"email_verify", "email_import", "task_management", "api_access", "priority_support"},
+		},
+	},
+	{
+		models.Product{Type: "monthly", Kind: "subscription", Name: "月度订阅", SortOrder: 11, Active: true},
+		models.ProductVersion{
+			Price: 2900, DurationDays: 30,
+			Features:     []string{"账号池访问 30 天"},
+			Entitlements: []string{},
+		},
+	},
+	{
+		models.Product{Type: "yearly", Kind: "subscription", Name: "年度订阅", SortOrder: 12, Active: true},
+		models.ProductVersion{
+			Price: 29900, DurationDays: 365,
+			Features:     []string{"账号池访问 365 天"},
+			Entitlements: []string{},
+		},
+	},
+}
+
+// migrateCatalog 写入商品目录中缺少的初始产品（已存在的产品不会被覆盖），
+// 并为商品目录上线前售出的 Key 和订单补上产品版本
+func migrateCatalog(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, seed := range defaultProducts {
+			var count int64
+			if err := tx.Model(&models.Product{}).Where("type = ?", seed.product.Type).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				product := seed.product
+				if err := tx.Create(&product).Error; err != nil {
+					return err
+				}
+				version := seed.version
+				version.ProductID = product.ID
+				version.Version = 1
+				if err := tx.Create(&version).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&product).Update("current_version_id", version.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, table := range []string{"license_keys", "payments"} {
+			if err := tx.Exec(`
+				UPDATE ` + table + ` t SET product_version_id = v.id
+				FROM products p JOIN product_versions v ON v.product_id = p.id AND v.version = 1
+				WHERE t.product_version_id IS NULL AND t.product_type = p.type`).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateSubscriptions 订阅改为按期记录（starts_at ~ expires_at）前的订阅从创建时开始
+func migrateSubscriptions(db *gorm.DB) error {
+	return db.Exec(`UPDATE subscriptions SET starts_at = created_at WHERE starts_at IS NULL`).Error
+}
+
+// SealReverifyAPIKeys 加密上线前以明文保存的定时复检 API key，返回处理的计划数。
+// 已加密的值跳过，可重复执行
+func SealReverifyAPIKeys(db *gorm.DB, box *secretbox.Box) (int, error) {
+	var schedules []models.ReverifySchedule
+	if err := db.Unscoped().Where("api_key <> '' AND api_key NOT LIKE 'v1:%'").Find(&schedules).Error; err != nil {
+		return 0, err
+	}
+	for _, schedule := range schedules {
+		sealed, err := box.Seal(schedule.APIKey)
+		if err != nil {
+			return 0, err
+		}
+		// 以原值为条件，避免覆盖并发更新写入的新 key
+		if err := db.Unscoped().Model(&models.ReverifySchedule{}).
+			Where("id = ? AND api_key = ?", schedule.ID, schedule.APIKey).
+			Update("api_key", sealed).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(schedules), nil
+}
+
+// Advisory lock keys for background work that must run on a single replica.
+const (
+	LockTemporaryUsageSweeper int64 = 100001
+)
+
+// WithAdvisoryLock runs fn inside a transaction that holds the given
+// transaction-level Postgres advisory lock. When another replica already
+// holds the lock, fn is skipped and acquired is false.
+func WithAdvisoryLock(db *gorm.DB, key int64, fn func(tx *gorm.DB) error) (acquired bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", key).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		return fn(tx)
+	})
+	return acquired, err
 }
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"fullstack-backend/internal/config"
 	"fullstack-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -13,11 +15,37 @@ import (
 )
 
 type AccountHandler struct {
-	db *gorm.DB
+	db                 *gorm.DB
+	claimDurations     map[string]time.Duration
+	claimMaxTotal      map[string]time.Duration
+	claimMaxConcurrent int
 }
 
-func NewAccountHandler(db *gorm.DB) *AccountHandler {
-	return &AccountHandler{db: db}
+func NewAccountHandler(db *gorm.DB, cfg *config.Config) *AccountHandler {
+	return &AccountHandler{
+		db:                 db,
+		claimDurations:     cfg.TemporaryClaimDurations,
+		claimMaxTotal:      cfg.TemporaryClaimMaxTotal,
+		claimMaxConcurrent: cfg.TemporaryClaimMaxConcurrent,
+	}
+}
+
+// claimDuration 某类账号单次占用（或续期）的时长
+func (h *AccountHandler) claimDuration(accountType string) time.Duration {
+	if d, ok := h.claimDurations[accountType]; ok {
+		return d
+	}
+	return h.claimDurations["default"]
+}
+
+// claimMaxTotalFor 当前订阅套餐允许的单次占用最长总时长（含续期）
+func (h *AccountHandler) claimMaxTotalFor(c *gin.Context) time.Duration {
+	if value, ok := c.Get("subscription"); ok {
+		if d, ok := h.claimMaxTotal[value.(models.Subscription).Plan]; ok {
+			return d
+		}
+	}
+	return h.claimMaxTotal["default"]
 }
 
 type AccountResponse struct {
@@ -26,6 +54,23 @@ type AccountResponse struct {
 	Main   string `json:"main"`
 	Status string `json:"status"`
 	Source string `json:"source"`
+	Price  int    `json:"price,omitempty"` // 独享账号售价（分）
+
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func newAccountResponse(account models.Account) AccountResponse {
+	return AccountResponse{
+		ID:          account.ID,
+		Type:        account.Type,
+		Main:        account.Main,
+		Status:      account.Status,
+		Source:      account.Source,
+		Price:       account.Price,
+		Description: account.Description,
+		Tags:        splitTags(account.Tags),
+	}
 }
 
 type FamilyInfo struct {
@@ -36,6 +81,18 @@ type FamilyInfo struct {
 type AccountListItem struct {
 	AccountResponse
 	Family *FamilyInfo `json:"family,omitempty"`
+
+	// 当前用户与该账号的关系
+	ClaimedByMe    bool       `json:"claimed_by_me"`
+	ClaimExpiresAt *time.Time `json:"claim_expires_at,omitempty"`
+	BoundByMe      bool       `json:"bound_by_me"`
+	OwnedByMe      bool       `json:"owned_by_me"`
+}
+
+type AccountListResponse struct {
+	Items      []AccountListItem `json:"items"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	HasMore    bool              `json:"has_more"`
 }
 
 type AccountCredentialsResponse struct {
@@ -49,55 +106,101 @@ type AccountClaimRequest struct {
 	AccountID uint `json:"account_id" binding:"required"`
 }
 
+type AccountExtendRequest struct {
+	AccountID uint `json:"account_id" binding:"required"`
+	Hours     int  `json:"hours" binding:"omitempty,min=1"` // 可选，默认按账号类型的占用时长续期
+}
+
 type AccountBindRequest struct {
 	AccountID      uint   `json:"account_id" binding:"required"`
 	MemberEmail    string `json:"member_email" binding:"required,email"`
 	MemberPassword string `json:"member_password" binding:"required"`
 }
 
-type AccountPurchaseRequest struct {
-	AccountID uint  `json:"account_id" binding:"required"`
-	PaymentID *uint `json:"payment_id"`
-}
-
+// ListAccounts 分页返回账号池，cursor 为上一页返回的 next_cursor
 func (h *AccountHandler) ListAccounts(c *gin.Context) {
-	var accounts []models.Account
-	query := h.db.Model(&models.Account{}).Where("status <> ?", "retired").Order("id asc")
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > maxAccountPageSize {
+		limit = 50
+	}
+
+	query := h.db.Model(&models.Account{}).Where("status <> ?", "retired")
+	if cursor := c.Query("cursor"); cursor != "" {
+		afterID, err := decodeAccountCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query = query.Where("id > ?", afterID)
+	}
 	if accountType := c.Query("type"); accountType != "" {
 		query = query.Where("type = ?", accountType)
 	}
 	if status := c.Query("status"); status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+	for _, filter := range []struct{ param, condition string }{
+		{"min_price", "price >= ?"},
+		{"max_price", "price <= ?"},
+	} {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		price, err := strconv.Atoi(value)
+		if err != nil || price < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + filter.param})
+			return
+		}
+		query = query.Where(filter.condition, price)
+	}
 
-	if err := query.Find(&accounts).Error; err != nil {
+	// 多取一条用于判断是否还有下一页
+	var accounts []models.Account
+	if err := query.Order("id asc").Limit(limit + 1).Find(&accounts).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts"})
 		return
 	}
+	hasMore := len(accounts) > limit
+	if hasMore {
+		accounts = accounts[:limit]
+	}
+
+	holdings, err := h.loadAccountHoldings(userID.(uint), accounts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch account details"})
+		return
+	}
 
 	results := make([]AccountListItem, 0, len(accounts))
 	for _, account := range accounts {
-		item := AccountListItem{
-			AccountResponse: AccountResponse{
-				ID:     account.ID,
-				Type:   account.Type,
-				Main:   account.Main,
-				Status: account.Status,
-				Source: account.Source,
-			},
+		item := AccountListItem{AccountResponse: newAccountResponse(account)}
+		if family, ok := holdings.family[account.ID]; ok {
+			item.Family = &family
 		}
-		if account.Type == "family" {
-			var group models.FamilyGroup
-			if err := h.db.Where("account_id = ?", account.ID).First(&group).Error; err == nil {
-				var count int64
-				h.db.Model(&models.FamilyBinding{}).Where("family_group_id = ?", group.ID).Count(&count)
-				item.Family = &FamilyInfo{Capacity: group.Capacity, Used: int(count)}
-			}
+		if expiresAt, ok := holdings.claims[account.ID]; ok {
+			item.ClaimedByMe = true
+			item.ClaimExpiresAt = &expiresAt
 		}
+		item.BoundByMe = holdings.bindings[account.ID]
+		item.OwnedByMe = holdings.owned[account.ID]
 		results = append(results, item)
 	}
 
-	c.JSON(http.StatusOK, results)
+	response := AccountListResponse{Items: results, HasMore: hasMore}
+	if hasMore {
+		response.NextCursor = encodeAccountCursor(accounts[len(accounts)-1].ID)
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *AccountHandler) ClaimTemporary(c *gin.Context) {
@@ -120,6 +223,11 @@ func (h *AccountHandler) ClaimTemporary(c *gin.Context) {
 		}
 	}()
 
+	if !h.checkClaimLimit(c, tx, userID.(uint)) {
+		tx.Rollback()
+		return
+	}
+
 	var account models.Account
 	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
 		Where("id = ? AND type = ?", req.AccountID, "temporary").
@@ -139,25 +247,29 @@ func (h *AccountHandler) ClaimTemporary(c *gin.Context) {
 		return
 	}
 
-	now := time.Now()
-	account.Status = "locked"
-	if err := tx.Save(&account).Error; err != nil {
+	// 有人排队时账号先交给队首；申请人自己排在队首时直接获得
+	usage, err := h.serveWaitlistFirst(tx, &account)
+	if err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check waitlist"})
 		return
 	}
-
-	usage := models.TemporaryUsage{
-		AccountID: account.ID,
-		UserID:    userID.(uint),
-		StartedAt: now,
-		ExpiresAt: now.Add(24 * time.Hour),
-	}
-	if err := tx.Create(&usage).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create usage"})
+	if usage != nil && usage.UserID != userID.(uint) {
+		if err := tx.Commit().Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Account was handed to a user queued ahead"})
 		return
 	}
+	if usage == nil {
+		usage, err = h.startTemporaryUsage(tx, &account, userID.(uint), false)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim account"})
+			return
+		}
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit"})
@@ -167,6 +279,68 @@ func (h *AccountHandler) ClaimTemporary(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Account claimed", "expires_at": usage.ExpiresAt})
 }
 
+// checkClaimLimit 锁定用户行以串行化同一用户的并发申请，并检查占用数上限；
+// 返回 false 时已写入响应
+func (h *AccountHandler) checkClaimLimit(c *gin.Context, tx *gorm.DB, userID uint) bool {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Where("id = ?", userID).
+		First(&models.User{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock user"})
+		return false
+	}
+
+	if h.claimMaxConcurrent <= 0 {
+		return true
+	}
+	var active int64
+	if err := tx.Model(&models.TemporaryUsage{}).
+		Where("user_id = ? AND returned_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Count(&active).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count active claims"})
+		return false
+	}
+	if int(active) >= h.claimMaxConcurrent {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          "Too many active temporary claims",
+			"max_concurrent": h.claimMaxConcurrent,
+		})
+		return false
+	}
+	return true
+}
+
+// startTemporaryUsage 将已锁定的空闲临时账号标记为占用并创建占用记录
+func (h *AccountHandler) startTemporaryUsage(tx *gorm.DB, account *models.Account, userID uint, allocated bool) (*models.TemporaryUsage, error) {
+	now := time.Now()
+	account.Status = "locked"
+	if err := tx.Save(account).Error; err != nil {
+		return nil, err
+	}
+
+	usage := models.TemporaryUsage{
+		AccountID: account.ID,
+		UserID:    userID,
+		StartedAt: now,
+		ExpiresAt: now.Add(h.claimDuration(account.Type)),
+	}
+	if err := tx.Create(&usage).Error; err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{
+		"usage_id":   usage.ID,
+		"expires_at": usage.ExpiresAt,
+	}
+	if allocated {
+		metadata["allocated"] = true
+	}
+	if err := recordAudit(tx, userID, "temporary.claim", "account", account.ID, metadata); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
 func (h *AccountHandler) ReleaseTemporary(c *gin.Context) {
 	var req AccountClaimRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -188,7 +362,8 @@ func (h *AccountHandler) ReleaseTemporary(c *gin.Context) {
 	}()
 
 	var usage models.TemporaryUsage
-	if err := tx.Where("account_id = ? AND user_id = ? AND returned_at IS NULL", req.AccountID, userID).
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("account_id = ? AND user_id = ? AND returned_at IS NULL", req.AccountID, userID).
 		Order("started_at desc").
 		First(&usage).Error; err != nil {
 		tx.Rollback()
@@ -208,9 +383,16 @@ func (h *AccountHandler) ReleaseTemporary(c *gin.Context) {
 		return
 	}
 
-	if err := tx.Model(&models.Account{}).
-		Where("id = ? AND type = ?", req.AccountID, "temporary").
-		Update("status", "available").Error; err != nil {
+	if err := recordAudit(tx, usage.UserID, "temporary.release", "account", usage.AccountID, map[string]interface{}{
+		"usage_id": usage.ID,
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log"})
+		return
+	}
+
+	// 有人排队时直接移交给下一位，否则重新开放领取
+	if _, err := h.releaseTemporaryAccount(tx, usage.AccountID); err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release account"})
 		return
@@ -224,8 +406,8 @@ func (h *AccountHandler) ReleaseTemporary(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Account released"})
 }
 
-func (h *AccountHandler) PurchaseExclusive(c *gin.Context) {
-	var req AccountPurchaseRequest
+func (h *AccountHandler) ExtendTemporary(c *gin.Context) {
+	var req AccountExtendRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -244,41 +426,73 @@ func (h *AccountHandler) PurchaseExclusive(c *gin.Context) {
 		}
 	}()
 
-	var account models.Account
+	now := time.Now()
+	var usage models.TemporaryUsage
 	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-		Where("id = ? AND type = ?", req.AccountID, "exclusive").
-		First(&account).Error; err != nil {
+		Where("account_id = ? AND user_id = ? AND returned_at IS NULL AND expires_at > ?", req.AccountID, userID, now).
+		Order("started_at desc").
+		First(&usage).Error; err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "No active usage found"})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock account"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
 		}
 		return
 	}
 
-	if account.Status != "available" {
+	var account models.Account
+	if err := tx.Where("id = ?", usage.AccountID).First(&account).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusConflict, gin.H{"error": "Account is not available"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch account"})
 		return
 	}
 
-	account.Status = "sold"
-	if err := tx.Save(&account).Error; err != nil {
+	extension := h.claimDuration(account.Type)
+	if req.Hours > 0 {
+		requested := time.Duration(req.Hours) * time.Hour
+		if requested > extension {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":     "Extension exceeds the per-claim duration",
+				"max_hours": int(extension.Hours()),
+			})
+			return
+		}
+		extension = requested
+	}
+
+	// 续期后的总时长不能超过订阅套餐允许的上限
+	maxTotal := h.claimMaxTotalFor(c)
+	newExpiresAt := usage.ExpiresAt.Add(extension)
+	if limit := usage.StartedAt.Add(maxTotal); newExpiresAt.After(limit) {
+		newExpiresAt = limit
+	}
+	if !newExpiresAt.After(usage.ExpiresAt) {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account"})
+		c.JSON(http.StatusConflict, gin.H{
+			"error":           "Maximum claim duration reached",
+			"max_total_hours": int(maxTotal.Hours()),
+		})
 		return
 	}
 
-	purchase := models.ExclusivePurchase{
-		AccountID:   account.ID,
-		UserID:      userID.(uint),
-		PaymentID:   req.PaymentID,
-		PurchasedAt: time.Now(),
+	previous := usage.ExpiresAt
+	usage.ExpiresAt = newExpiresAt
+	usage.Extensions++
+	if err := tx.Save(&usage).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update usage"})
+		return
 	}
-	if err := tx.Create(&purchase).Error; err != nil {
+
+	if err := recordAudit(tx, usage.UserID, "temporary.extend", "account", usage.AccountID, map[string]interface{}{
+		"usage_id":        usage.ID,
+		"previous_expiry": previous,
+		"expires_at":      usage.ExpiresAt,
+	}); err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log"})
 		return
 	}
 
@@ -287,9 +501,35 @@ func (h *AccountHandler) PurchaseExclusive(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Purchase successful"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Claim extended",
+		"expires_at": usage.ExpiresAt,
+		"extensions": usage.Extensions,
+	})
+}
+
+// sellExclusive 将已锁定的独享账号标记为售出并记录归属
+func sellExclusive(tx *gorm.DB, account *models.Account, userID uint, paymentID *uint) (*models.ExclusivePurchase, error) {
+	account.Status = "sold"
+	if err := tx.Save(account).Error; err != nil {
+		return nil, err
+	}
+
+	purchase := models.ExclusivePurchase{
+		AccountID:   account.ID,
+		UserID:      userID,
+		PaymentID:   paymentID,
+		PurchasedAt: time.Now(),
+	}
+	if err := tx.Create(&purchase).Error; err != nil {
+		return nil, err
+	}
+	return &purchase, nil
 }
 
+// defaultFamilyCapacity 新建家庭组的默认成员上限
+const defaultFamilyCapacity = 5
+
 func (h *AccountHandler) BindFamily(c *gin.Context) {
 	var req AccountBindRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -303,8 +543,16 @@ func (h *AccountHandler) BindFamily(c *gin.Context) {
 		return
 	}
 
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
 	var account models.Account
-	if err := h.db.Where("id = ? AND type = ?", req.AccountID, "family").First(&account).Error; err != nil {
+	if err := tx.Where("id = ? AND type = ?", req.AccountID, "family").First(&account).Error; err != nil {
+		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 		} else {
@@ -312,31 +560,29 @@ func (h *AccountHandler) BindFamily(c *gin.Context) {
 		}
 		return
 	}
+	if account.Status == "retired" {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is not available"})
+		return
+	}
 
-	var group models.FamilyGroup
-	if err := h.db.Where("account_id = ?", account.ID).First(&group).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			group = models.FamilyGroup{AccountID: account.ID, Capacity: 5}
-			if err := h.db.Create(&group).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
-				return
-			}
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
-			return
-		}
+	// 锁定家庭组行，串行化同一家庭组的并发绑定，保证不超过容量
+	group, err := lockFamilyGroup(tx, account.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
 	}
 
 	var count int64
-	h.db.Model(&models.FamilyBinding{}).Where("family_group_id = ?", group.ID).Count(&count)
-	if int(count) >= group.Capacity {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Family group is full"})
+	if err := tx.Model(&models.FamilyBinding{}).Where("family_group_id = ?", group.ID).Count(&count).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count bindings"})
 		return
 	}
-
-	var existing models.FamilyBinding
-	if err := h.db.Where("family_group_id = ? AND user_id = ?", group.ID, userID).First(&existing).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Already bound to this family group"})
+	if int(count) >= group.Capacity {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Family group is full"})
 		return
 	}
 
@@ -346,14 +592,52 @@ func (h *AccountHandler) BindFamily(c *gin.Context) {
 		MemberEmail:       req.MemberEmail,
 		MemberPasswordEnc: req.MemberPassword,
 	}
-	if err := h.db.Create(&binding).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to bind family account"})
+	if err := tx.Create(&binding).Error; err != nil {
+		tx.Rollback()
+		// 部分唯一索引保证同一用户在同一家庭组只有一条有效绑定
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Already bound to this family group"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to bind family account"})
+		}
+		return
+	}
+
+	if err := recordAudit(tx, binding.UserID, "family.bind", "account", account.ID, map[string]interface{}{
+		"binding_id": binding.ID,
+	}); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Family binding created"})
 }
 
+// lockFamilyGroup 获取并锁定账号对应的家庭组，不存在时创建。
+// 创建依赖 account_id 唯一索引：并发创建时只有一个插入生效，其余直接读取已有记录。
+func lockFamilyGroup(tx *gorm.DB, accountID uint) (*models.FamilyGroup, error) {
+	var group models.FamilyGroup
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("account_id = ?", accountID).First(&group).Error
+	if err != gorm.ErrRecordNotFound {
+		return &group, err
+	}
+
+	if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "account_id"}}, DoNothing: true}).
+		Create(&models.FamilyGroup{AccountID: accountID, Capacity: defaultFamilyCapacity}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("account_id = ?", accountID).First(&group).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
 func (h *AccountHandler) UnbindFamily(c *gin.Context) {
 	var req AccountClaimRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -377,7 +661,17 @@ func (h *AccountHandler) UnbindFamily(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Where("family_group_id = ? AND user_id = ?", group.ID, userID).Delete(&models.FamilyBinding{}).Error; err != nil {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("family_group_id = ? AND user_id = ?", group.ID, userID).Delete(&models.FamilyBinding{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return recordAudit(tx, userID.(uint), "family.unbind", "account", group.AccountID, nil)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unbind family account"})
 		return
 	}
@@ -399,7 +693,7 @@ func (h *AccountHandler) GetExclusiveCredentials(c *gin.Context) {
 	}
 
 	var purchase models.ExclusivePurchase
-	if err := h.db.Where("account_id = ? AND user_id = ?", accountID, userID).First(&purchase).Error; err != nil {
+	if err := h.db.Where("account_id = ? AND user_id = ? AND refunded_at IS NULL", accountID, userID).First(&purchase).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusForbidden, gin.H{"error": "No access to credentials"})
 		} else {
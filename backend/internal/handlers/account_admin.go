@@ -0,0 +1,737 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxAccountImportRows 单次批量导入的最大行数
+const maxAccountImportRows = 5000
+
+var errInvalidInput = errors.New("invalid input")
+
+var accountTypes = map[string]bool{
+	"temporary": true,
+	"exclusive": true,
+	"family":    true,
+}
+
+type AccountAdminHandler struct {
+	db *gorm.DB
+}
+
+func NewAccountAdminHandler(db *gorm.DB) *AccountAdminHandler {
+	return &AccountAdminHandler{db: db}
+}
+
+// AccountInput 管理员创建或导入账号的字段
+type AccountInput struct {
+	Type        string   `json:"type"`
+	Main        string   `json:"main"`
+	Password    string   `json:"password"`
+	Key2FA      string   `json:"key_2FA"`
+	Source      string   `json:"source"`
+	Price       int      `json:"price"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Notes       string   `json:"notes"`
+}
+
+// AccountUpdateInput 管理员修改账号，未提供的字段保持不变
+type AccountUpdateInput struct {
+	Main        *string   `json:"main"`
+	Password    *string   `json:"password"`
+	Key2FA      *string   `json:"key_2FA"`
+	Source      *string   `json:"source"`
+	Price       *int      `json:"price"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	Notes       *string   `json:"notes"`
+	Status      *string   `json:"status"` // 仅支持 available 与 retired 之间切换，needs_rotation 只能下架
+}
+
+// AccountImportError 批量导入中某一行的校验错误
+type AccountImportError struct {
+	Row   int    `json:"row"`
+	Main  string `json:"main,omitempty"`
+	Error string `json:"error"`
+}
+
+type accountStat struct {
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Source     string `json:"source"`
+	Count      int64  `json:"count"`
+	TotalPrice int64  `json:"total_price"`
+}
+
+// normalize 去除首尾空白并校验字段
+func (in *AccountInput) normalize() error {
+	in.Type = strings.ToLower(strings.TrimSpace(in.Type))
+	in.Main = strings.TrimSpace(in.Main)
+	in.Source = strings.TrimSpace(in.Source)
+
+	if !accountTypes[in.Type] {
+		return fmt.Errorf("无效的账号类型: %q", in.Type)
+	}
+	if in.Main == "" {
+		return fmt.Errorf("账号不能为空")
+	}
+	if in.Price < 0 {
+		return fmt.Errorf("价格不能为负数")
+	}
+	return nil
+}
+
+func (in *AccountInput) toModel() models.Account {
+	return models.Account{
+		Type:        in.Type,
+		Main:        in.Main,
+		Password:    in.Password,
+		Key2FA:      in.Key2FA,
+		Status:      "available",
+		Source:      in.Source,
+		Price:       in.Price,
+		Description: in.Description,
+		Tags:        joinTags(in.Tags),
+		Notes:       in.Notes,
+	}
+}
+
+// ListAccounts 管理员查询账号，支持按类型、状态、来源、标签和关键字过滤
+func (h *AccountAdminHandler) ListAccounts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	query := h.db.Model(&models.Account{})
+	if accountType := c.Query("type"); accountType != "" {
+		query = query.Where("type = ?", accountType)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+	if tag := strings.TrimSpace(c.Query("tag")); tag != "" {
+		query = query.Where(`(',' || tags || ',') LIKE ? ESCAPE '\'`, "%,"+escapeLike(tag)+",%")
+	}
+	if keyword := strings.TrimSpace(c.Query("q")); keyword != "" {
+		query = query.Where(`main ILIKE ? ESCAPE '\'`, "%"+escapeLike(keyword)+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询账号失败"})
+		return
+	}
+
+	var accounts []models.Account
+	if err := query.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询账号失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"accounts":  accounts,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike 转义 LIKE 模式中的通配符，使用户输入按字面匹配
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// GetAccount 查询单个账号详情
+func (h *AccountAdminHandler) GetAccount(c *gin.Context) {
+	var account models.Account
+	if err := h.db.Where("id = ?", c.Param("id")).First(&account).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "账号不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询账号失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"account": account})
+}
+
+// CreateAccount 新增单个账号
+func (h *AccountAdminHandler) CreateAccount(c *gin.Context) {
+	var input AccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if err := input.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.Account{}).Where("type = ? AND main = ?", input.Type, input.Main).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询账号失败"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "账号已存在"})
+		return
+	}
+
+	account := input.toModel()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c.GetUint("user_id"), "account.create", "account", account.ID, map[string]interface{}{
+			"type":  account.Type,
+			"price": account.Price,
+		})
+	})
+	if err != nil {
+		// 并发创建越过了上面的检查，由唯一索引拦截
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "账号已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建账号失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"account": account})
+}
+
+// UpdateAccount 修改账号信息、价格、标签和备注
+func (h *AccountAdminHandler) UpdateAccount(c *gin.Context) {
+	var input AccountUpdateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	var account models.Account
+	var status int
+	var message string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", c.Param("id")).
+			First(&account).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				status, message = http.StatusNotFound, "账号不存在"
+			}
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if input.Main != nil {
+			main := strings.TrimSpace(*input.Main)
+			if main == "" {
+				status, message = http.StatusBadRequest, "账号不能为空"
+				return errInvalidInput
+			}
+			var existing int64
+			if err := tx.Model(&models.Account{}).
+				Where("type = ? AND main = ? AND id <> ?", account.Type, main, account.ID).
+				Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing > 0 {
+				status, message = http.StatusConflict, "账号已存在"
+				return errInvalidInput
+			}
+			updates["main"] = main
+		}
+		if input.Password != nil {
+			updates["password"] = *input.Password
+		}
+		if input.Key2FA != nil {
+			updates["key_2fa"] = *input.Key2FA
+		}
+		if input.Source != nil {
+			updates["source"] = strings.TrimSpace(*input.Source)
+		}
+		if input.Price != nil {
+			if *input.Price < 0 {
+				status, message = http.StatusBadRequest, "价格不能为负数"
+				return errInvalidInput
+			}
+			updates["price"] = *input.Price
+		}
+		if input.Description != nil {
+			updates["description"] = *input.Description
+		}
+		if input.Tags != nil {
+			updates["tags"] = joinTags(*input.Tags)
+		}
+		if input.Notes != nil {
+			updates["notes"] = *input.Notes
+		}
+		if input.Status != nil && *input.Status != account.Status {
+			allowed := retirable(account.Status) && retirable(*input.Status) ||
+				account.Status == "needs_rotation" && *input.Status == "retired"
+			if !allowed {
+				status, message = http.StatusConflict, "当前状态不允许修改"
+				return errInvalidInput
+			}
+			updates["status"] = *input.Status
+		}
+		if account.Status == "needs_rotation" && updates["status"] == nil && credentialsRotated(account, input) {
+			updates["status"] = "available"
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&account).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&account, account.ID).Error; err != nil {
+			return err
+		}
+		if updates["status"] == "retired" {
+			if err := detachRetiredAccount(tx, account.ID); err != nil {
+				return err
+			}
+		}
+
+		changed := make([]string, 0, len(updates))
+		for field := range updates {
+			changed = append(changed, field)
+		}
+		return recordAudit(tx, c.GetUint("user_id"), "account.update", "account", account.ID, map[string]interface{}{
+			"fields": changed,
+		})
+	})
+	if err != nil {
+		if status == 0 && errors.Is(err, gorm.ErrDuplicatedKey) {
+			status, message = http.StatusConflict, "账号已存在"
+		}
+		if status == 0 {
+			status, message = http.StatusInternalServerError, "更新账号失败"
+		}
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"account": account})
+}
+
+// RetireAccount 下架账号，已被占用、预留或售出的账号不能下架
+func (h *AccountAdminHandler) RetireAccount(c *gin.Context) {
+	var account models.Account
+	var status int
+	var message string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", c.Param("id")).
+			First(&account).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				status, message = http.StatusNotFound, "账号不存在"
+			}
+			return err
+		}
+		if account.Status == "retired" {
+			return nil
+		}
+		if account.Status != "available" && account.Status != "needs_rotation" {
+			status, message = http.StatusConflict, "账号正在使用或已售出，不能下架"
+			return errInvalidInput
+		}
+
+		account.Status = "retired"
+		if err := tx.Model(&account).Update("status", "retired").Error; err != nil {
+			return err
+		}
+		if err := detachRetiredAccount(tx, account.ID); err != nil {
+			return err
+		}
+		return recordAudit(tx, c.GetUint("user_id"), "account.retire", "account", account.ID, nil)
+	})
+	if err != nil {
+		if status == 0 {
+			status, message = http.StatusInternalServerError, "下架账号失败"
+		}
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"account": account})
+}
+
+type FamilyCapacityRequest struct {
+	Capacity int `json:"capacity" binding:"required,min=1"`
+}
+
+// UpdateFamilyCapacity 调整家庭组成员上限，不能低于当前已绑定人数
+func (h *AccountAdminHandler) UpdateFamilyCapacity(c *gin.Context) {
+	var req FamilyCapacityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	var group *models.FamilyGroup
+	var used int64
+	var status int
+	var message string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var account models.Account
+		if err := tx.Where("id = ? AND type = ?", c.Param("id"), "family").First(&account).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				status, message = http.StatusNotFound, "家庭组账号不存在"
+			}
+			return err
+		}
+
+		var err error
+		if group, err = lockFamilyGroup(tx, account.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.FamilyBinding{}).Where("family_group_id = ?", group.ID).Count(&used).Error; err != nil {
+			return err
+		}
+		if int(used) > req.Capacity {
+			status, message = http.StatusConflict, "容量不能低于当前已绑定人数"
+			return errInvalidInput
+		}
+
+		previous := group.Capacity
+		group.Capacity = req.Capacity
+		if err := tx.Model(group).Update("capacity", req.Capacity).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c.GetUint("user_id"), "family.capacity", "account", account.ID, map[string]interface{}{
+			"previous": previous,
+			"capacity": req.Capacity,
+		})
+	})
+	if err != nil {
+		if status == 0 {
+			status, message = http.StatusInternalServerError, "更新家庭组容量失败"
+		}
+		response := gin.H{"error": message}
+		if status == http.StatusConflict {
+			response["used"] = used
+		}
+		c.JSON(status, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"family": FamilyInfo{Capacity: group.Capacity, Used: int(used)}})
+}
+
+// DeleteAccount 删除账号（软删除），只允许删除已下架的账号
+func (h *AccountAdminHandler) DeleteAccount(c *gin.Context) {
+	result := h.db.Where("id = ? AND status = ?", c.Param("id"), "retired").Delete(&models.Account{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除账号失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "账号不存在或未下架"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "账号已删除"})
+}
+
+// ImportAccounts 批量导入账号，支持上传 CSV/JSON 文件或直接提交 JSON。
+// 所有行校验通过才会写入；dry_run=1 时只校验不写入。
+func (h *AccountAdminHandler) ImportAccounts(c *gin.Context) {
+	inputs, err := parseAccountImport(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(inputs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有可导入的账号"})
+		return
+	}
+	if len(inputs) > maxAccountImportRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多导入 %d 个账号", maxAccountImportRows)})
+		return
+	}
+
+	errs, err := h.validateAccountImport(inputs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "校验账号失败"})
+		return
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "导入数据校验失败",
+			"errors": errs,
+			"valid":  len(inputs) - len(errs),
+		})
+		return
+	}
+
+	if c.Query("dry_run") == "1" || c.Query("dry_run") == "true" {
+		c.JSON(http.StatusOK, gin.H{"message": "校验通过", "valid": len(inputs)})
+		return
+	}
+
+	accounts := make([]models.Account, 0, len(inputs))
+	for i := range inputs {
+		accounts = append(accounts, inputs[i].toModel())
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&accounts, 200).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c.GetUint("user_id"), "account.import", "account", 0, map[string]interface{}{
+			"count": len(accounts),
+		})
+	})
+	if err != nil {
+		// 校验之后有同名账号被并发创建
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "账号已存在，请重新校验后导入"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入账号失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "导入成功", "imported": len(accounts)})
+}
+
+// GetAccountStats 按类型、状态和来源统计库存
+func (h *AccountAdminHandler) GetAccountStats(c *gin.Context) {
+	var stats []accountStat
+	if err := h.db.Model(&models.Account{}).
+		Select("type, status, source, COUNT(*) as count, COALESCE(SUM(price), 0) as total_price").
+		Group("type, status, source").
+		Order("type, status, source").
+		Scan(&stats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询库存统计失败"})
+		return
+	}
+
+	byType := map[string]int64{}
+	byStatus := map[string]int64{}
+	bySource := map[string]int64{}
+	var total int64
+	for _, stat := range stats {
+		byType[stat.Type] += stat.Count
+		byStatus[stat.Status] += stat.Count
+		bySource[stat.Source] += stat.Count
+		total += stat.Count
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"by_type":   byType,
+		"by_status": byStatus,
+		"by_source": bySource,
+		"stats":     stats,
+	})
+}
+
+// validateAccountImport 逐行校验，并检查文件内及与已有账号的重复
+func (h *AccountAdminHandler) validateAccountImport(inputs []AccountInput) ([]AccountImportError, error) {
+	var errs []AccountImportError
+	seen := make(map[string]int, len(inputs))
+	mains := make([]string, 0, len(inputs))
+
+	for i := range inputs {
+		row := i + 1
+		if err := inputs[i].normalize(); err != nil {
+			errs = append(errs, AccountImportError{Row: row, Main: inputs[i].Main, Error: err.Error()})
+			continue
+		}
+		key := inputs[i].Type + "/" + inputs[i].Main
+		if first, ok := seen[key]; ok {
+			errs = append(errs, AccountImportError{Row: row, Main: inputs[i].Main, Error: fmt.Sprintf("与第 %d 行重复", first)})
+			continue
+		}
+		seen[key] = row
+		mains = append(mains, inputs[i].Main)
+	}
+
+	for start := 0; start < len(mains); start += 500 {
+		end := start + 500
+		if end > len(mains) {
+			end = len(mains)
+		}
+		var existing []models.Account
+		if err := h.db.Select("type", "main").Where("main IN ?", mains[start:end]).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+		for _, account := range existing {
+			if row, ok := seen[account.Type+"/"+account.Main]; ok {
+				errs = append(errs, AccountImportError{Row: row, Main: account.Main, Error: "账号已存在"})
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// parseAccountImport 根据上传文件扩展名或请求体解析导入数据
+func parseAccountImport(c *gin.Context) ([]AccountInput, error) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		// 没有上传文件时按 JSON 请求体解析
+		var body struct {
+			Accounts []AccountInput `json:"accounts"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return nil, fmt.Errorf("请上传 CSV/JSON 文件或提交 JSON")
+		}
+		return body.Accounts, nil
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("无法读取上传文件")
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(file.Filename)) {
+	case ".csv":
+		return parseAccountCSV(f)
+	case ".json":
+		var body struct {
+			Accounts []AccountInput `json:"accounts"`
+		}
+		if err := json.NewDecoder(f).Decode(&body); err != nil {
+			return nil, fmt.Errorf("无效的 JSON 格式: %v", err)
+		}
+		return body.Accounts, nil
+	default:
+		return nil, fmt.Errorf("仅支持 .csv 或 .json 文件")
+	}
+}
+
+// parseAccountCSV 解析带表头的 CSV，表头列名与 JSON 字段一致，tags 用分号或逗号分隔
+func parseAccountCSV(r io.Reader) ([]AccountInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 CSV 表头: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["type"]; !ok {
+		return nil, fmt.Errorf("CSV 缺少 type 列")
+	}
+	if _, ok := columns["main"]; !ok {
+		return nil, fmt.Errorf("CSV 缺少 main 列")
+	}
+
+	var inputs []AccountInput
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CSV 第 %d 行格式错误: %v", line, err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		input := AccountInput{
+			Type:        field("type"),
+			Main:        field("main"),
+			Password:    field("password"),
+			Key2FA:      field("key_2fa"),
+			Source:      field("source"),
+			Description: field("description"),
+			Tags:        strings.FieldsFunc(field("tags"), func(r rune) bool { return r == ';' || r == ',' }),
+			Notes:       field("notes"),
+		}
+		if price := field("price"); price != "" {
+			value, err := strconv.Atoi(price)
+			if err != nil {
+				return nil, fmt.Errorf("CSV 第 %d 行价格无效: %q", line, price)
+			}
+			input.Price = value
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// retirable 可以在管理后台手动切换的状态
+func retirable(status string) bool {
+	return status == "available" || status == "retired"
+}
+
+// credentialsRotated 退款收回的账号需要更换密码，原来设置了 2FA 密钥的还需更换密钥，之后才能重新上架
+func credentialsRotated(account models.Account, input AccountUpdateInput) bool {
+	if input.Password == nil || *input.Password == "" || *input.Password == account.Password {
+		return false
+	}
+	if account.Key2FA == "" {
+		return true
+	}
+	return input.Key2FA != nil && *input.Key2FA != "" && *input.Key2FA != account.Key2FA
+}
+
+// detachRetiredAccount 账号下架后将其移出购物车并取消针对它的排队
+func detachRetiredAccount(tx *gorm.DB, accountID uint) error {
+	if err := tx.Where("account_id = ?", accountID).Delete(&models.CartItem{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.AccountWaitlist{}).
+		Where("account_id = ? AND status = ?", accountID, "waiting").
+		Update("status", "canceled").Error
+}
+
+// joinTags 去重、去空白后以逗号保存
+func joinTags(tags []string) string {
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(strings.ReplaceAll(tag, ",", " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return strings.Join(result, ",")
+}
+
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
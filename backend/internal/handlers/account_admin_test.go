@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"plain":    "plain",
+		"50%":      `50\%`,
+		"a_b":      `a\_b`,
+		`back\`:    `back\\`,
+		`%_\mixed`: `\%\_\\mixed`,
+	}
+	for input, want := range tests {
+		if got := escapeLike(input); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// createTaggedAccount 创建指定 main 和标签的账号
+func createTaggedAccount(t *testing.T, db *gorm.DB, main, source, tags string) models.Account {
+	t.Helper()
+	account := createTestAccount(t, db, "exclusive", "available", 100)
+	if err := db.Model(&account).Updates(map[string]interface{}{"main": main, "source": source, "tags": tags}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func listAdminAccounts(t *testing.T, h *AccountAdminHandler, query url.Values) []models.Account {
+	t.Helper()
+	target := "/admin/accounts?" + query.Encode()
+	w := performAs(t, 0, nil, h.ListAccounts, http.MethodGet, "/admin/accounts", target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list %s: status = %d", target, w.Code)
+	}
+	var response struct {
+		Accounts []models.Account `json:"accounts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Accounts
+}
+
+func TestListAccountsMatchesWildcardsLiterally(t *testing.T) {
+	db := openTestDB(t)
+	h := NewAccountAdminHandler(db)
+	suffix := uniqueSuffix()
+	source := "admin-" + suffix
+
+	literal := createTaggedAccount(t, db, "a_b-"+suffix+"@example.com", source, "50%_off")
+	createTaggedAccount(t, db, "axb-"+suffix+"@example.com", source, "50xxoff")
+
+	accounts := listAdminAccounts(t, h, url.Values{"source": {source}, "q": {"a_b-" + suffix}})
+	if len(accounts) != 1 || accounts[0].ID != literal.ID {
+		t.Errorf("q=a_b matched %d accounts, want only %d", len(accounts), literal.ID)
+	}
+
+	accounts = listAdminAccounts(t, h, url.Values{"source": {source}, "tag": {"50%_off"}})
+	if len(accounts) != 1 || accounts[0].ID != literal.ID {
+		t.Errorf("tag=50%%_off matched %d accounts, want only %d", len(accounts), literal.ID)
+	}
+
+	if accounts := listAdminAccounts(t, h, url.Values{"source": {source}, "q": {"%"}}); len(accounts) != 0 {
+		t.Errorf("q=%% matched %d accounts, want 0", len(accounts))
+	}
+}
+
+func TestUpdateAccountRejectsDuplicateMain(t *testing.T) {
+	db := openTestDB(t)
+	h := NewAccountAdminHandler(db)
+	admin := createTestUser(t, db)
+	suffix := uniqueSuffix()
+	taken := createTaggedAccount(t, db, "taken-"+suffix+"@example.com", "test", "")
+	account := createTaggedAccount(t, db, "other-"+suffix+"@example.com", "test", "")
+
+	target := fmt.Sprintf("/admin/accounts/%d", account.ID)
+	w := performAs(t, admin.ID, nil, h.UpdateAccount, http.MethodPut, "/admin/accounts/:id", target, AccountUpdateInput{Main: &taken.Main})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("duplicate main: status = %d, want 409", w.Code)
+	}
+	if err := db.First(&account, account.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if account.Main == taken.Main {
+		t.Error("main was changed to a duplicate")
+	}
+
+	// 保持自己的 main 不算重复
+	w = performAs(t, admin.ID, nil, h.UpdateAccount, http.MethodPut, "/admin/accounts/:id", target, AccountUpdateInput{Main: &account.Main})
+	if w.Code != http.StatusOK {
+		t.Errorf("unchanged main: status = %d, want 200", w.Code)
+	}
+}
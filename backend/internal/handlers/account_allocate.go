@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AccountAllocateRequest struct {
+	Type   string `json:"type" binding:"required,oneof=temporary exclusive"`
+	Source string `json:"source"`
+}
+
+// AllocateAccount 由服务端挑选一个可用账号，避免大量用户争抢同一个 account_id
+func (h *AccountHandler) AllocateAccount(c *gin.Context) {
+	var req AccountAllocateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tx := h.db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if req.Type == "temporary" && !h.checkClaimLimit(c, tx, userID.(uint)) {
+		tx.Rollback()
+		return
+	}
+
+	var account *models.Account
+	var queued *models.TemporaryUsage
+	handedOff := 0
+	for {
+		var err error
+		account, err = pickAvailableAccount(tx, req.Type, req.Source)
+		if err == gorm.ErrRecordNotFound && handedOff > 0 {
+			// 空闲账号都交给了排在前面的用户，移交结果需要提交
+			if err := tx.Commit().Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "No available account, users queued ahead were served first"})
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusConflict, gin.H{"error": "No available account"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate account"})
+			}
+			return
+		}
+		if req.Type != "temporary" {
+			break
+		}
+
+		// 临时账号先交给排队的用户，与 ClaimTemporary 一致
+		queued, err = h.serveWaitlistFirst(tx, account)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check waitlist"})
+			return
+		}
+		if queued == nil || queued.UserID == userID.(uint) {
+			break
+		}
+		handedOff++
+	}
+
+	response := gin.H{}
+	switch req.Type {
+	case "temporary":
+		usage := queued
+		if usage == nil {
+			var err error
+			usage, err = h.startTemporaryUsage(tx, account, userID.(uint), true)
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim account"})
+				return
+			}
+		}
+		response["message"] = "Account claimed"
+		response["expires_at"] = usage.ExpiresAt
+	case "exclusive":
+		payment, _, err := createAccountOrder(tx, userID.(uint), []uint{account.ID})
+		if err != nil {
+			tx.Rollback()
+			writeAccountOrderError(c, err)
+			return
+		}
+		account.Status = "reserved"
+		response["message"] = "Order created, complete payment to receive the account"
+		response["order"] = payment
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit"})
+		return
+	}
+
+	response["account"] = newAccountResponse(*account)
+	c.JSON(http.StatusOK, response)
+}
+
+// pickAvailableAccount 锁定一个指定类型的空闲账号。
+// SKIP LOCKED 让并发请求各自拿到不同的账号而不是排队等待同一行；
+// 按 updated_at 升序优先分配空闲最久的账号，使使用量在账号之间均匀分布。
+func pickAvailableAccount(tx *gorm.DB, accountType, source string) (*models.Account, error) {
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("type = ? AND status = ?", accountType, "available")
+	if source != "" {
+		query = query.Where("source = ?", source)
+	}
+	if accountType == "exclusive" {
+		// 未定价的独享账号不能下单
+		query = query.Where("price > 0")
+	}
+
+	var account models.Account
+	if err := query.Order("updated_at asc, id asc").First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// createSourcedAccounts 创建一组使用独立来源的账号，分配时按来源过滤，不会选中其他数据
+func createSourcedAccounts(t *testing.T, db *gorm.DB, accountType string, prices ...int) (string, []models.Account) {
+	t.Helper()
+	source := "allocate-" + uniqueSuffix()
+	accounts := make([]models.Account, 0, len(prices))
+	for _, price := range prices {
+		account := createTestAccount(t, db, accountType, "available", price)
+		if err := db.Model(&account).Update("source", source).Error; err != nil {
+			t.Fatal(err)
+		}
+		accounts = append(accounts, account)
+	}
+	return source, accounts
+}
+
+func TestAllocateTemporaryHandsOutDistinctAccounts(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	source, accounts := createSourcedAccounts(t, db, "temporary", 0, 0, 0)
+
+	users := make([]models.User, len(accounts)+1)
+	for i := range users {
+		users[i] = createTestUser(t, db)
+	}
+
+	// 并发分配时 SKIP LOCKED 让每个请求拿到不同的账号，多出的请求返回 409
+	codes := make([]int, len(users))
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, userID uint) {
+			defer wg.Done()
+			w := performAs(t, userID, nil, h.AllocateAccount, http.MethodPost, "/accounts/allocate", "/accounts/allocate",
+				AccountAllocateRequest{Type: "temporary", Source: source})
+			codes[i] = w.Code
+		}(i, user.ID)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if succeeded != len(accounts) {
+		t.Errorf("successful allocations = %d, want %d", succeeded, len(accounts))
+	}
+
+	holders := make(map[uint]bool)
+	for _, account := range accounts {
+		holder := activeHolder(t, db, account.ID)
+		if holder == 0 {
+			t.Errorf("account %d was not allocated", account.ID)
+		}
+		if holders[holder] {
+			t.Errorf("user %d received two accounts", holder)
+		}
+		holders[holder] = true
+	}
+}
+
+func TestAllocateTemporaryRespectsClaimLimit(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	user := createTestUser(t, db)
+	source, accounts := createSourcedAccounts(t, db, "temporary", 0)
+
+	// 每人同时最多占用 2 个
+	for i := 0; i < 2; i++ {
+		held := createTestAccount(t, db, "temporary", "locked", 0)
+		createTestUsage(t, db, held.ID, user.ID, time.Hour)
+	}
+
+	w := performAs(t, user.ID, nil, h.AllocateAccount, http.MethodPost, "/accounts/allocate", "/accounts/allocate",
+		AccountAllocateRequest{Type: "temporary", Source: source})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("allocate over the limit: status = %d, want 409", w.Code)
+	}
+	if holder := activeHolder(t, db, accounts[0].ID); holder != 0 {
+		t.Errorf("account allocated to %d despite the claim limit", holder)
+	}
+}
+
+func TestAllocateExclusiveReservesPricedAccount(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	user := createTestUser(t, db)
+	source, accounts := createSourcedAccounts(t, db, "exclusive", 0, 1990)
+	unpriced, priced := accounts[0], accounts[1]
+
+	w := performAs(t, user.ID, nil, h.AllocateAccount, http.MethodPost, "/accounts/allocate", "/accounts/allocate",
+		AccountAllocateRequest{Type: "exclusive", Source: source})
+	if w.Code != http.StatusOK {
+		t.Fatalf("allocate exclusive: status = %d, body = %s", w.Code, w.Body)
+	}
+	var response struct {
+		Order   models.Payment  `json:"order"`
+		Account AccountResponse `json:"account"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Account.ID != priced.ID || response.Order.Amount != priced.Price || response.Order.Status != "pending" {
+		t.Fatalf("response = %+v", response)
+	}
+
+	// 选中的账号预留给订单，未定价的账号不会被分配
+	if err := db.First(&priced, priced.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&unpriced, unpriced.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if priced.Status != "reserved" || unpriced.Status != "available" {
+		t.Errorf("priced status = %s, unpriced status = %s", priced.Status, unpriced.Status)
+	}
+	var items int64
+	db.Model(&models.PaymentItem{}).Where("payment_id = ? AND account_id = ?", response.Order.ID, priced.ID).Count(&items)
+	if items != 1 {
+		t.Errorf("order items = %d, want 1", items)
+	}
+
+	w = performAs(t, user.ID, nil, h.AllocateAccount, http.MethodPost, "/accounts/allocate", "/accounts/allocate",
+		AccountAllocateRequest{Type: "exclusive", Source: source})
+	if w.Code != http.StatusConflict {
+		t.Errorf("allocate with only unpriced accounts left: status = %d, want 409", w.Code)
+	}
+}
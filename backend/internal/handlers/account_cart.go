@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxCartItems 购物车最多容纳的账号数
+const maxCartItems = 20
+
+type CartAddRequest struct {
+	AccountID uint `json:"account_id" binding:"required"`
+}
+
+type CartCheckoutRequest struct {
+	// AllowPartial 为 true 时跳过已不可购买的账号，只为剩余账号下单
+	AllowPartial bool `json:"allow_partial"`
+	// 可选：同时发起支付，响应中返回支付链接或二维码
+	PaymentMethod string `json:"payment_method"`
+	// 可选：优惠码，按实际下单账号的总价计算折扣
+	CouponCode string `json:"coupon_code"`
+}
+
+type CartItemResponse struct {
+	ID        uint            `json:"id"`
+	Account   AccountResponse `json:"account"`
+	Available bool            `json:"available"`
+}
+
+// CartUnavailableItem 结算时已不可购买的账号
+type CartUnavailableItem struct {
+	AccountID uint   `json:"account_id"`
+	Reason    string `json:"reason"` // not_found, unavailable, unpriced
+}
+
+func (h *AccountHandler) GetCart(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var items []models.CartItem
+	if err := h.db.Where("user_id = ?", userID).Order("created_at asc").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	accountIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		accountIDs = append(accountIDs, item.AccountID)
+	}
+	accounts := make(map[uint]models.Account, len(items))
+	if len(accountIDs) > 0 {
+		var rows []models.Account
+		if err := h.db.Where("id IN ?", accountIDs).Find(&rows).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts"})
+			return
+		}
+		for _, account := range rows {
+			accounts[account.ID] = account
+		}
+	}
+
+	results := make([]CartItemResponse, 0, len(items))
+	total := 0
+	for _, item := range items {
+		account, ok := accounts[item.AccountID]
+		available := ok && account.Status == "available" && account.Price > 0
+		if available {
+			total += account.Price
+		}
+		response := newAccountResponse(account)
+		response.ID = item.AccountID
+		results = append(results, CartItemResponse{
+			ID:        item.ID,
+			Account:   response,
+			Available: available,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": results, "total": total})
+}
+
+func (h *AccountHandler) AddToCart(c *gin.Context) {
+	var req CartAddRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var account models.Account
+	if err := h.db.Where("id = ? AND type = ?", req.AccountID, "exclusive").First(&account).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch account"})
+		}
+		return
+	}
+	if account.Status != "available" || account.Price <= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is not available"})
+		return
+	}
+
+	var count int64
+	if err := h.db.Model(&models.CartItem{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+	if count >= maxCartItems {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cart is full", "max_items": maxCartItems})
+		return
+	}
+
+	item := models.CartItem{UserID: userID.(uint), AccountID: account.ID}
+	if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Added to cart"})
+}
+
+func (h *AccountHandler) RemoveFromCart(c *gin.Context) {
+	accountID, err := strconv.ParseUint(c.Param("account_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := h.db.Where("user_id = ? AND account_id = ?", userID, accountID).Delete(&models.CartItem{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from cart"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not in cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Removed from cart"})
+}
+
+// CheckoutCart 为购物车中的账号创建一个订单，所有账号在订单有效期内被预留。
+// 有账号已不可购买时默认不下单并返回这些账号；allow_partial 时跳过它们继续下单。
+// 与单个账号下单一样可以同时使用优惠码和发起支付
+func (h *PaymentHandler) CheckoutCart(c *gin.Context) {
+	var req CartCheckoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if req.PaymentMethod != "" {
+		if _, ok := h.providers.Gateway(req.PaymentMethod); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment method"})
+			return
+		}
+	}
+
+	var (
+		payment     *models.Payment
+		items       []models.PaymentItem
+		unavailable []CartUnavailableItem
+	)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var cart []models.CartItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			Order("created_at asc").
+			Find(&cart).Error; err != nil {
+			return err
+		}
+		if len(cart) == 0 {
+			return errEmptyCart
+		}
+
+		accountIDs := make([]uint, 0, len(cart))
+		for _, item := range cart {
+			accountIDs = append(accountIDs, item.AccountID)
+		}
+
+		// createAccountOrder 遇到第一个不可购买的账号就会失败，这里逐个剔除后重试，
+		// 以便一次性报告所有不可购买的账号
+		for len(accountIDs) > 0 {
+			var err error
+			payment, items, err = createAccountOrder(tx, userID.(uint), accountIDs)
+			if err == nil {
+				break
+			}
+			orderErr, ok := err.(*accountOrderError)
+			if !ok {
+				return err
+			}
+			unavailable = append(unavailable, CartUnavailableItem{AccountID: orderErr.AccountID, Reason: orderErr.Reason})
+			accountIDs = removeAccountID(accountIDs, orderErr.AccountID)
+		}
+
+		if len(unavailable) > 0 {
+			if !req.AllowPartial || payment == nil {
+				return errCartUnavailable
+			}
+			// 已下架或售出的账号从购物车移除
+			removed := make([]uint, 0, len(unavailable))
+			for _, item := range unavailable {
+				removed = append(removed, item.AccountID)
+			}
+			if err := tx.Where("user_id = ? AND account_id IN ?", userID, removed).Delete(&models.CartItem{}).Error; err != nil {
+				return err
+			}
+		}
+
+		ordered := make([]uint, 0, len(items))
+		for _, item := range items {
+			ordered = append(ordered, item.AccountID)
+		}
+		if err := tx.Where("user_id = ? AND account_id IN ?", userID, ordered).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+
+		// 优惠码无效时整个结算回滚，购物车保持不变
+		if req.CouponCode != "" {
+			return applyCoupon(tx, payment, req.CouponCode)
+		}
+		return nil
+	})
+
+	if writeCouponError(c, err) {
+		return
+	}
+	switch {
+	case err == errEmptyCart:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	case err == errCartUnavailable:
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       "Some items are no longer available",
+			"unavailable": unavailable,
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to checkout"})
+		return
+	}
+
+	response := gin.H{
+		"message":     "Order created, complete payment to receive the accounts",
+		"order":       payment,
+		"items":       items,
+		"unavailable": unavailable,
+	}
+	h.attachCheckout(c, response, payment, req.PaymentMethod)
+	c.JSON(http.StatusOK, response)
+}
+
+var (
+	errEmptyCart       = errors.New("cart is empty")
+	errCartUnavailable = errors.New("cart contains unavailable items")
+)
+
+func removeAccountID(ids []uint, target uint) []uint {
+	result := ids[:0]
+	for _, id := range ids {
+		if id != target {
+			result = append(result, id)
+		}
+	}
+	return result
+}
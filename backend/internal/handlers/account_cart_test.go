@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type cartCheckoutResponse struct {
+	Order       models.Payment        `json:"order"`
+	Items       []models.PaymentItem  `json:"items"`
+	Unavailable []CartUnavailableItem `json:"unavailable"`
+	Checkout    json.RawMessage       `json:"checkout"`
+}
+
+// fillTestCart 创建独享账号并放入用户的购物车
+func fillTestCart(t *testing.T, db *gorm.DB, userID uint, prices ...int) []models.Account {
+	t.Helper()
+	accounts := make([]models.Account, 0, len(prices))
+	for _, price := range prices {
+		account := createTestAccount(t, db, "exclusive", "available", price)
+		if err := db.Create(&models.CartItem{UserID: userID, AccountID: account.ID}).Error; err != nil {
+			t.Fatal(err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+func checkoutCart(t *testing.T, h *PaymentHandler, userID uint, req CartCheckoutRequest) (int, cartCheckoutResponse) {
+	t.Helper()
+	w := performAs(t, userID, nil, h.CheckoutCart, http.MethodPost, "/accounts/cart/checkout", "/accounts/cart/checkout", req)
+	var response cartCheckoutResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return w.Code, response
+}
+
+func cartSize(t *testing.T, db *gorm.DB, userID uint) int64 {
+	t.Helper()
+	var count int64
+	if err := db.Model(&models.CartItem{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCheckoutCartPartialFailure(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	user := createTestUser(t, db)
+	accounts := fillTestCart(t, db, user.ID, 1000, 2000, 3000)
+
+	// 加入购物车后有一个账号被其他人买走
+	sold := accounts[1]
+	if err := db.Model(&sold).Update("status", "sold").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 默认不下单，返回不可购买的账号，购物车和其他账号保持不变
+	code, response := checkoutCart(t, h, user.ID, CartCheckoutRequest{})
+	if code != http.StatusConflict {
+		t.Fatalf("checkout: status = %d, want 409", code)
+	}
+	if len(response.Unavailable) != 1 || response.Unavailable[0].AccountID != sold.ID || response.Unavailable[0].Reason != "unavailable" {
+		t.Errorf("unavailable = %+v", response.Unavailable)
+	}
+	if size := cartSize(t, db, user.ID); size != 3 {
+		t.Errorf("cart size = %d, want 3", size)
+	}
+	for _, account := range []models.Account{accounts[0], accounts[2]} {
+		if status := accountStatus(t, db, account.ID); status != "available" {
+			t.Errorf("account %d status = %s, want available", account.ID, status)
+		}
+	}
+
+	// allow_partial 时只为剩余账号下单并清空购物车
+	code, response = checkoutCart(t, h, user.ID, CartCheckoutRequest{AllowPartial: true})
+	if code != http.StatusOK {
+		t.Fatalf("partial checkout: status = %d", code)
+	}
+	if response.Order.Amount != 4000 || len(response.Items) != 2 || len(response.Unavailable) != 1 {
+		t.Errorf("partial checkout = %+v", response)
+	}
+	for _, account := range []models.Account{accounts[0], accounts[2]} {
+		if status := accountStatus(t, db, account.ID); status != "reserved" {
+			t.Errorf("account %d status = %s, want reserved", account.ID, status)
+		}
+	}
+	if size := cartSize(t, db, user.ID); size != 0 {
+		t.Errorf("cart size after checkout = %d, want 0", size)
+	}
+}
+
+func TestCheckoutCartWithCouponAndPaymentMethod(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	coupon := createTestCoupon(t, db, models.Coupon{})
+	user := createTestUser(t, db)
+	fillTestCart(t, db, user.ID, 1000, 2000)
+
+	code, response := checkoutCart(t, h, user.ID, CartCheckoutRequest{PaymentMethod: "local", CouponCode: coupon.Code})
+	if code != http.StatusOK {
+		t.Fatalf("checkout: status = %d", code)
+	}
+	if response.Order.Amount != 2900 || response.Order.DiscountAmount != 100 || len(response.Checkout) == 0 {
+		t.Fatalf("checkout = %+v", response)
+	}
+
+	var order models.Payment
+	if err := db.First(&order, response.Order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.PaymentMethod != "local" || order.CouponID == nil || *order.CouponID != coupon.ID {
+		t.Errorf("order = %+v", order)
+	}
+	if err := db.First(&coupon, coupon.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if coupon.UsedCount != 1 {
+		t.Errorf("coupon used_count = %d, want 1", coupon.UsedCount)
+	}
+}
+
+func TestCheckoutCartRejectsInvalidOptions(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	ended := time.Now().Add(-time.Hour)
+	coupon := createTestCoupon(t, db, models.Coupon{EndsAt: &ended})
+	user := createTestUser(t, db)
+	accounts := fillTestCart(t, db, user.ID, 1000)
+
+	if code, _ := checkoutCart(t, h, user.ID, CartCheckoutRequest{PaymentMethod: "nope"}); code != http.StatusBadRequest {
+		t.Errorf("unsupported payment method: status = %d, want 400", code)
+	}
+
+	// 优惠码无效时整个结算回滚
+	if code, _ := checkoutCart(t, h, user.ID, CartCheckoutRequest{CouponCode: coupon.Code}); code != http.StatusBadRequest {
+		t.Errorf("ended coupon: status = %d, want 400", code)
+	}
+	if status := accountStatus(t, db, accounts[0].ID); status != "available" {
+		t.Errorf("account status = %s, want available", status)
+	}
+	if size := cartSize(t, db, user.ID); size != 1 {
+		t.Errorf("cart size = %d, want 1", size)
+	}
+	var orders int64
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&orders)
+	if orders != 0 {
+		t.Errorf("orders = %d, want 0", orders)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func bindTestFamily(t *testing.T, h *AccountHandler, userID, accountID uint) int {
+	t.Helper()
+	w := performAs(t, userID, nil, h.BindFamily, http.MethodPost, "/accounts/family/bind", "/accounts/family/bind", AccountBindRequest{
+		AccountID:      accountID,
+		MemberEmail:    fmt.Sprintf("member-%d@example.com", userID),
+		MemberPassword: "secret",
+	})
+	return w.Code
+}
+
+func activeBindings(t *testing.T, db *gorm.DB, accountID uint) int64 {
+	t.Helper()
+	var count int64
+	if err := db.Model(&models.FamilyBinding{}).
+		Joins("JOIN family_groups ON family_groups.id = family_bindings.family_group_id").
+		Where("family_groups.account_id = ?", accountID).
+		Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestBindFamilyRespectsCapacityUnderConcurrency(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	account := createTestAccount(t, db, "family", "available", 0)
+	if err := db.Create(&models.FamilyGroup{AccountID: account.ID, Capacity: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	users := make([]models.User, 5)
+	for i := range users {
+		users[i] = createTestUser(t, db)
+	}
+
+	// 多个用户同时绑定，家庭组行锁保证只有容量内的请求成功
+	codes := make([]int, len(users))
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, userID uint) {
+			defer wg.Done()
+			codes[i] = bindTestFamily(t, h, userID, account.ID)
+		}(i, user.ID)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusBadRequest:
+		default:
+			t.Errorf("user %d: status = %d", users[i].ID, code)
+		}
+	}
+	if succeeded != 2 {
+		t.Errorf("%d bindings succeeded, want 2", succeeded)
+	}
+	if count := activeBindings(t, db, account.ID); count != 2 {
+		t.Errorf("active bindings = %d, want 2", count)
+	}
+}
+
+func TestBindFamilyRejectsDuplicateAndAllowsRebind(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	user := createTestUser(t, db)
+	account := createTestAccount(t, db, "family", "available", 0)
+
+	if code := bindTestFamily(t, h, user.ID, account.ID); code != http.StatusOK {
+		t.Fatalf("bind: status = %d", code)
+	}
+	if code := bindTestFamily(t, h, user.ID, account.ID); code != http.StatusConflict {
+		t.Errorf("duplicate bind: status = %d, want 409", code)
+	}
+
+	// 解绑是软删除，之后可以重新绑定
+	w := performAs(t, user.ID, nil, h.UnbindFamily, http.MethodPost, "/accounts/family/unbind", "/accounts/family/unbind",
+		AccountClaimRequest{AccountID: account.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("unbind: status = %d", w.Code)
+	}
+	if code := bindTestFamily(t, h, user.ID, account.ID); code != http.StatusOK {
+		t.Errorf("rebind: status = %d, want 200", code)
+	}
+	if count := activeBindings(t, db, account.ID); count != 1 {
+		t.Errorf("active bindings = %d, want 1", count)
+	}
+}
+
+func TestUpdateFamilyCapacityBelowUsage(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	admin := NewAccountAdminHandler(db)
+	account := createTestAccount(t, db, "family", "available", 0)
+	for i := 0; i < 2; i++ {
+		if code := bindTestFamily(t, h, createTestUser(t, db).ID, account.ID); code != http.StatusOK {
+			t.Fatalf("bind %d: status = %d", i, code)
+		}
+	}
+
+	target := fmt.Sprintf("/admin/accounts/%d/family", account.ID)
+	w := performAs(t, 0, nil, admin.UpdateFamilyCapacity, http.MethodPut, "/admin/accounts/:id/family", target, FamilyCapacityRequest{Capacity: 1})
+	if w.Code != http.StatusConflict {
+		t.Errorf("capacity below usage: status = %d, want 409", w.Code)
+	}
+	w = performAs(t, 0, nil, admin.UpdateFamilyCapacity, http.MethodPut, "/admin/accounts/:id/family", target, FamilyCapacityRequest{Capacity: 2})
+	if w.Code != http.StatusOK {
+		t.Fatalf("capacity at usage: status = %d", w.Code)
+	}
+
+	// 满员后新的绑定被拒绝
+	if code := bindTestFamily(t, h, createTestUser(t, db).ID, account.ID); code != http.StatusBadRequest {
+		t.Errorf("bind into full group: status = %d, want 400", code)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"fullstack-backend/internal/models"
+)
+
+// maxAccountPageSize ListAccounts 单页最多返回的账号数
+const maxAccountPageSize = 100
+
+// accountHoldings 一页账号的附加信息，每类信息只查询一次
+type accountHoldings struct {
+	family   map[uint]FamilyInfo
+	claims   map[uint]time.Time // 当前用户占用中的临时账号 -> 到期时间
+	bindings map[uint]bool      // 当前用户已绑定的家庭组账号
+	owned    map[uint]bool      // 当前用户已购买的独享账号
+}
+
+type familyCapacityRow struct {
+	AccountID uint
+	Capacity  int
+	Used      int
+}
+
+// loadAccountHoldings 批量查询家庭组容量以及当前用户的占用、绑定和购买情况
+func (h *AccountHandler) loadAccountHoldings(userID uint, accounts []models.Account) (*accountHoldings, error) {
+	holdings := &accountHoldings{
+		family:   map[uint]FamilyInfo{},
+		claims:   map[uint]time.Time{},
+		bindings: map[uint]bool{},
+		owned:    map[uint]bool{},
+	}
+
+	var temporaryIDs, exclusiveIDs, familyIDs []uint
+	for _, account := range accounts {
+		switch account.Type {
+		case "temporary":
+			temporaryIDs = append(temporaryIDs, account.ID)
+		case "exclusive":
+			exclusiveIDs = append(exclusiveIDs, account.ID)
+		case "family":
+			familyIDs = append(familyIDs, account.ID)
+		}
+	}
+
+	if len(familyIDs) > 0 {
+		var rows []familyCapacityRow
+		if err := h.db.Table("family_groups").
+			Select("family_groups.account_id, family_groups.capacity, COUNT(family_bindings.id) AS used").
+			Joins("LEFT JOIN family_bindings ON family_bindings.family_group_id = family_groups.id AND family_bindings.deleted_at IS NULL").
+			Where("family_groups.account_id IN ?", familyIDs).
+			Group("family_groups.account_id, family_groups.capacity").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			holdings.family[row.AccountID] = FamilyInfo{Capacity: row.Capacity, Used: row.Used}
+		}
+
+		var bound []uint
+		if err := h.db.Table("family_bindings").
+			Select("family_groups.account_id").
+			Joins("JOIN family_groups ON family_groups.id = family_bindings.family_group_id").
+			Where("family_bindings.user_id = ? AND family_bindings.deleted_at IS NULL AND family_groups.account_id IN ?", userID, familyIDs).
+			Scan(&bound).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range bound {
+			holdings.bindings[id] = true
+		}
+	}
+
+	if len(temporaryIDs) > 0 {
+		var usages []models.TemporaryUsage
+		if err := h.db.Select("account_id", "expires_at").
+			Where("user_id = ? AND account_id IN ? AND returned_at IS NULL AND expires_at > ?", userID, temporaryIDs, time.Now()).
+			Find(&usages).Error; err != nil {
+			return nil, err
+		}
+		for _, usage := range usages {
+			holdings.claims[usage.AccountID] = usage.ExpiresAt
+		}
+	}
+
+	if len(exclusiveIDs) > 0 {
+		var owned []uint
+		if err := h.db.Model(&models.ExclusivePurchase{}).
+			Where("user_id = ? AND account_id IN ? AND refunded_at IS NULL", userID, exclusiveIDs).
+			Pluck("account_id", &owned).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range owned {
+			holdings.owned[id] = true
+		}
+	}
+
+	return holdings, nil
+}
+
+// encodeAccountCursor 游标只包含上一页最后一个账号的 ID，对客户端保持不透明
+func encodeAccountCursor(lastID uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(lastID), 10)))
+}
+
+func decodeAccountCursor(cursor string) (uint, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MyExclusiveItem struct {
+	PurchaseID  uint            `json:"purchase_id"`
+	Account     AccountResponse `json:"account"`
+	PaymentID   *uint           `json:"payment_id,omitempty"`
+	PurchasedAt time.Time       `json:"purchased_at"`
+	RefundedAt  *time.Time      `json:"refunded_at,omitempty"`
+}
+
+type MyTemporaryItem struct {
+	UsageID    uint            `json:"usage_id"`
+	Account    AccountResponse `json:"account"`
+	StartedAt  time.Time       `json:"started_at"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	ReturnedAt *time.Time      `json:"returned_at,omitempty"`
+	Extensions int             `json:"extensions"`
+}
+
+type MyFamilyItem struct {
+	BindingID   uint            `json:"binding_id"`
+	Account     AccountResponse `json:"account"`
+	Family      *FamilyInfo     `json:"family,omitempty"`
+	MemberEmail string          `json:"member_email"`
+	BoundAt     time.Time       `json:"bound_at"`
+	UnboundAt   *time.Time      `json:"unbound_at,omitempty"`
+}
+
+type MyAccountsResponse struct {
+	Exclusive []MyExclusiveItem `json:"exclusive"`
+	// ExclusiveHistory 已退款的独享账号购买记录
+	ExclusiveHistory []MyExclusiveItem `json:"exclusive_history"`
+	Temporary        struct {
+		Active  []MyTemporaryItem `json:"active"`
+		History []MyTemporaryItem `json:"history"`
+	} `json:"temporary"`
+	Family struct {
+		Active  []MyFamilyItem `json:"active"`
+		History []MyFamilyItem `json:"history"`
+	} `json:"family"`
+}
+
+// GetMyAccounts 汇总当前用户购买的独享账号、临时账号占用和家庭组绑定（含历史记录）
+func (h *AccountHandler) GetMyAccounts(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	historyLimit, _ := strconv.Atoi(c.DefaultQuery("history_limit", "20"))
+	if historyLimit < 0 || historyLimit > 100 {
+		historyLimit = 20
+	}
+
+	now := time.Now()
+
+	var purchases []models.ExclusivePurchase
+	if err := h.db.Where("user_id = ? AND refunded_at IS NULL", userID).Order("purchased_at desc").Find(&purchases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchases"})
+		return
+	}
+
+	var refundedPurchases []models.ExclusivePurchase
+	if historyLimit > 0 {
+		if err := h.db.Where("user_id = ? AND refunded_at IS NOT NULL", userID).
+			Order("refunded_at desc").
+			Limit(historyLimit).
+			Find(&refundedPurchases).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchases"})
+			return
+		}
+	}
+
+	var activeUsages []models.TemporaryUsage
+	if err := h.db.Where("user_id = ? AND returned_at IS NULL AND expires_at > ?", userID, now).
+		Order("expires_at asc").
+		Find(&activeUsages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch temporary usages"})
+		return
+	}
+
+	var pastUsages []models.TemporaryUsage
+	if historyLimit > 0 {
+		if err := h.db.Where("user_id = ? AND (returned_at IS NOT NULL OR expires_at <= ?)", userID, now).
+			Order("started_at desc").
+			Limit(historyLimit).
+			Find(&pastUsages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch temporary usages"})
+			return
+		}
+	}
+
+	// 解绑使用软删除，历史记录需要 Unscoped 查询
+	var bindings []models.FamilyBinding
+	if err := h.db.Where("user_id = ?", userID).Order("created_at desc").Find(&bindings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch family bindings"})
+		return
+	}
+
+	var pastBindings []models.FamilyBinding
+	if historyLimit > 0 {
+		if err := h.db.Unscoped().
+			Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+			Order("deleted_at desc").
+			Limit(historyLimit).
+			Find(&pastBindings).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch family bindings"})
+			return
+		}
+	}
+
+	// 家庭组 -> 账号
+	groupIDs := make([]uint, 0, len(bindings)+len(pastBindings))
+	for _, binding := range append(append([]models.FamilyBinding{}, bindings...), pastBindings...) {
+		groupIDs = append(groupIDs, binding.FamilyGroupID)
+	}
+	groups := map[uint]models.FamilyGroup{}
+	if len(groupIDs) > 0 {
+		var rows []models.FamilyGroup
+		if err := h.db.Where("id IN ?", groupIDs).Find(&rows).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch family groups"})
+			return
+		}
+		for _, group := range rows {
+			groups[group.ID] = group
+		}
+	}
+
+	// 一次性加载涉及的全部账号（包括已下架的）
+	accountIDs := make([]uint, 0)
+	for _, purchase := range append(append([]models.ExclusivePurchase{}, purchases...), refundedPurchases...) {
+		accountIDs = append(accountIDs, purchase.AccountID)
+	}
+	for _, usage := range append(append([]models.TemporaryUsage{}, activeUsages...), pastUsages...) {
+		accountIDs = append(accountIDs, usage.AccountID)
+	}
+	for _, group := range groups {
+		accountIDs = append(accountIDs, group.AccountID)
+	}
+	accounts := map[uint]models.Account{}
+	if len(accountIDs) > 0 {
+		var rows []models.Account
+		if err := h.db.Unscoped().Where("id IN ?", accountIDs).Find(&rows).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts"})
+			return
+		}
+		for _, account := range rows {
+			accounts[account.ID] = account
+		}
+	}
+
+	familyAccounts := make([]models.Account, 0, len(groups))
+	for _, group := range groups {
+		if account, ok := accounts[group.AccountID]; ok {
+			familyAccounts = append(familyAccounts, account)
+		}
+	}
+	holdings, err := h.loadAccountHoldings(userID.(uint), familyAccounts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch family groups"})
+		return
+	}
+
+	response := MyAccountsResponse{
+		Exclusive:        toMyExclusiveItems(purchases, accounts),
+		ExclusiveHistory: toMyExclusiveItems(refundedPurchases, accounts),
+	}
+
+	response.Temporary.Active = toMyTemporaryItems(activeUsages, accounts)
+	response.Temporary.History = toMyTemporaryItems(pastUsages, accounts)
+
+	familyItem := func(binding models.FamilyBinding) MyFamilyItem {
+		group := groups[binding.FamilyGroupID]
+		item := MyFamilyItem{
+			BindingID:   binding.ID,
+			Account:     newAccountResponse(accounts[group.AccountID]),
+			MemberEmail: binding.MemberEmail,
+			BoundAt:     binding.CreatedAt,
+		}
+		if binding.DeletedAt.Valid {
+			unboundAt := binding.DeletedAt.Time
+			item.UnboundAt = &unboundAt
+		} else if family, ok := holdings.family[group.AccountID]; ok {
+			item.Family = &family
+		}
+		return item
+	}
+	response.Family.Active = make([]MyFamilyItem, 0, len(bindings))
+	for _, binding := range bindings {
+		response.Family.Active = append(response.Family.Active, familyItem(binding))
+	}
+	response.Family.History = make([]MyFamilyItem, 0, len(pastBindings))
+	for _, binding := range pastBindings {
+		response.Family.History = append(response.Family.History, familyItem(binding))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func toMyExclusiveItems(purchases []models.ExclusivePurchase, accounts map[uint]models.Account) []MyExclusiveItem {
+	items := make([]MyExclusiveItem, 0, len(purchases))
+	for _, purchase := range purchases {
+		items = append(items, MyExclusiveItem{
+			PurchaseID:  purchase.ID,
+			Account:     newAccountResponse(accounts[purchase.AccountID]),
+			PaymentID:   purchase.PaymentID,
+			PurchasedAt: purchase.PurchasedAt,
+			RefundedAt:  purchase.RefundedAt,
+		})
+	}
+	return items
+}
+
+func toMyTemporaryItems(usages []models.TemporaryUsage, accounts map[uint]models.Account) []MyTemporaryItem {
+	items := make([]MyTemporaryItem, 0, len(usages))
+	for _, usage := range usages {
+		items = append(items, MyTemporaryItem{
+			UsageID:    usage.ID,
+			Account:    newAccountResponse(accounts[usage.AccountID]),
+			StartedAt:  usage.StartedAt,
+			ExpiresAt:  usage.ExpiresAt,
+			ReturnedAt: usage.ReturnedAt,
+			Extensions: usage.Extensions,
+		})
+	}
+	return items
+}
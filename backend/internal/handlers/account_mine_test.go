@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// refundTestOrder 通过 RefundOrder 对订单全额退款
+func refundTestOrder(t *testing.T, db *gorm.DB, h *PaymentHandler, order models.Payment) {
+	t.Helper()
+	admin := NewPaymentAdminHandler(db, h.providers)
+	w := performAs(t, 0, nil, admin.RefundOrder, http.MethodPost, "/admin/payments/orders/:order_no/refunds",
+		"/admin/payments/orders/"+order.OrderNo+"/refunds", RefundRequest{Reason: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refund %s: status = %d, body = %s", order.OrderNo, w.Code, w.Body)
+	}
+}
+
+func getMyAccounts(t *testing.T, h *AccountHandler, userID uint) MyAccountsResponse {
+	t.Helper()
+	w := performAs(t, userID, nil, h.GetMyAccounts, http.MethodGet, "/accounts/mine", "/accounts/mine", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("mine: status = %d", w.Code)
+	}
+	var response MyAccountsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestRefundedExclusivePurchaseMovesToHistory(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	accounts := newTestAccountHandler(db)
+	router := newNotifyRouter(t, db)
+	buyer := createTestUser(t, db)
+	account := createTestAccount(t, db, "exclusive", "available", 1990)
+
+	code, order := orderExclusive(t, h, buyer.ID, account.ID)
+	if code != http.StatusOK {
+		t.Fatalf("order: status = %d", code)
+	}
+	paySuccess(t, router, order)
+	if mine := getMyAccounts(t, accounts, buyer.ID); len(mine.Exclusive) != 1 || len(mine.ExclusiveHistory) != 0 {
+		t.Fatalf("before refund: exclusive = %d, history = %d", len(mine.Exclusive), len(mine.ExclusiveHistory))
+	}
+
+	// 退款后购买记录保留，移到历史中
+	refundTestOrder(t, db, h, order)
+	mine := getMyAccounts(t, accounts, buyer.ID)
+	if len(mine.Exclusive) != 0 || len(mine.ExclusiveHistory) != 1 {
+		t.Fatalf("after refund: exclusive = %d, history = %d", len(mine.Exclusive), len(mine.ExclusiveHistory))
+	}
+	item := mine.ExclusiveHistory[0]
+	if item.Account.ID != account.ID || item.RefundedAt == nil || item.PaymentID == nil || *item.PaymentID != order.ID {
+		t.Errorf("history item = %+v", item)
+	}
+	var purchase models.ExclusivePurchase
+	if err := db.Where("account_id = ?", account.ID).First(&purchase).Error; err != nil {
+		t.Fatal(err)
+	}
+	if purchase.RefundID == nil {
+		t.Error("purchase refund_id not set")
+	}
+
+	// 账号轮换后重新上架，再次售出时新建购买记录
+	if err := db.Model(&account).Update("status", "available").Error; err != nil {
+		t.Fatal(err)
+	}
+	other := createTestUser(t, db)
+	code, order = orderExclusive(t, h, other.ID, account.ID)
+	if code != http.StatusOK {
+		t.Fatalf("resell order: status = %d", code)
+	}
+	paySuccess(t, router, order)
+	var purchases []models.ExclusivePurchase
+	if err := db.Where("account_id = ?", account.ID).Order("id").Find(&purchases).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(purchases) != 2 || purchases[1].UserID != other.ID || purchases[1].RefundedAt != nil {
+		t.Errorf("purchases after resale = %+v", purchases)
+	}
+	if mine := getMyAccounts(t, accounts, buyer.ID); len(mine.Exclusive) != 0 || len(mine.ExclusiveHistory) != 1 {
+		t.Errorf("original buyer after resale: exclusive = %d, history = %d", len(mine.Exclusive), len(mine.ExclusiveHistory))
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"fullstack-backend/internal/database"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// temporarySweepBatch 每次扫描最多释放的过期占用数
+const temporarySweepBatch = 200
+
+// ReleaseExpiredTemporary 自动释放超过 ExpiresAt 仍未归还的临时账号（由后台任务调用）
+// 通过 advisory lock 保证多副本部署时同一时刻只有一个实例在执行。
+func (h *AccountHandler) ReleaseExpiredTemporary(ctx context.Context) error {
+	var released, handed int
+	_, err := database.WithAdvisoryLock(h.db.WithContext(ctx), database.LockTemporaryUsageSweeper, func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var usages []models.TemporaryUsage
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("returned_at IS NULL AND expires_at <= ?", now).
+			Order("expires_at asc").
+			Limit(temporarySweepBatch).
+			Find(&usages).Error; err != nil {
+			return err
+		}
+
+		for _, usage := range usages {
+			if err := tx.Model(&models.TemporaryUsage{}).
+				Where("id = ?", usage.ID).
+				Update("returned_at", now).Error; err != nil {
+				return err
+			}
+
+			if err := recordAudit(tx, usage.UserID, "temporary.auto_release", "account", usage.AccountID, map[string]interface{}{
+				"usage_id":   usage.ID,
+				"expires_at": usage.ExpiresAt,
+			}); err != nil {
+				return err
+			}
+
+			handedOff, err := h.releaseTemporaryAccount(tx, usage.AccountID)
+			if err != nil {
+				return err
+			}
+			released++
+			if handedOff {
+				handed++
+			}
+		}
+
+		// 账号可能通过其他途径变为空闲（归还失败后重试、新上架），顺带分配给排队用户
+		assigned, err := h.assignAvailableToWaitlist(tx)
+		if err != nil {
+			return err
+		}
+		handed += assigned
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if released > 0 || handed > 0 {
+		log.Printf("released %d expired temporary accounts, handed %d to waitlist", released, handed)
+	}
+	return nil
+}
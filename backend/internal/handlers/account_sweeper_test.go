@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// createTestUsage 创建一条未归还的占用记录，expiresIn 为负表示已过期
+func createTestUsage(t *testing.T, db *gorm.DB, accountID, userID uint, expiresIn time.Duration) models.TemporaryUsage {
+	t.Helper()
+	now := time.Now()
+	usage := models.TemporaryUsage{
+		AccountID: accountID,
+		UserID:    userID,
+		StartedAt: now.Add(-time.Hour),
+		ExpiresAt: now.Add(expiresIn),
+	}
+	if err := db.Create(&usage).Error; err != nil {
+		t.Fatal(err)
+	}
+	return usage
+}
+
+func TestReleaseExpiredTemporary(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	user := createTestUser(t, db)
+
+	expiredAccount := createTestAccount(t, db, "temporary", "locked", 0)
+	expired := createTestUsage(t, db, expiredAccount.ID, user.ID, -time.Minute)
+	activeAccount := createTestAccount(t, db, "temporary", "locked", 0)
+	active := createTestUsage(t, db, activeAccount.ID, user.ID, time.Hour)
+
+	if err := h.ReleaseExpiredTemporary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.First(&expired, expired.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expired.ReturnedAt == nil {
+		t.Error("expired usage was not released")
+	}
+	if err := db.First(&expiredAccount, expiredAccount.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expiredAccount.Status != "available" {
+		t.Errorf("released account status = %s, want available", expiredAccount.Status)
+	}
+
+	if err := db.First(&active, active.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&activeAccount, activeAccount.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if active.ReturnedAt != nil || activeAccount.Status != "locked" {
+		t.Errorf("unexpired claim was touched: usage %+v, account %s", active, activeAccount.Status)
+	}
+
+	var audits int64
+	db.Model(&models.AuditLog{}).Where("user_id = ? AND action = ?", user.ID, "temporary.auto_release").Count(&audits)
+	if audits != 1 {
+		t.Errorf("auto release audit entries = %d, want 1", audits)
+	}
+
+	// 再次执行不会重复释放
+	if err := h.ReleaseExpiredTemporary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.AuditLog{}).Where("user_id = ? AND action = ?", user.ID, "temporary.auto_release").Count(&audits)
+	if audits != 1 {
+		t.Errorf("auto release audit entries after second sweep = %d, want 1", audits)
+	}
+}
+
+func TestReleaseExpiredTemporaryHandsOffToWaitlist(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	holder := createTestUser(t, db)
+	waiter := createTestUser(t, db)
+	createTestSubscription(t, db, waiter.ID)
+
+	account := createTestAccount(t, db, "temporary", "locked", 0)
+	createTestUsage(t, db, account.ID, holder.ID, -time.Minute)
+	entry := models.AccountWaitlist{UserID: waiter.ID, AccountID: &account.ID, Status: "waiting"}
+	if err := db.Create(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.ReleaseExpiredTemporary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.First(&account, account.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if account.Status != "locked" {
+		t.Errorf("account status = %s, want locked by the waiting user", account.Status)
+	}
+	if err := db.First(&entry, entry.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != "fulfilled" || entry.UsageID == nil {
+		t.Fatalf("waitlist entry = %+v, want fulfilled", entry)
+	}
+	var usage models.TemporaryUsage
+	if err := db.First(&usage, *entry.UsageID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if usage.UserID != waiter.ID || usage.AccountID != account.ID || usage.ReturnedAt != nil {
+		t.Errorf("handed off usage = %+v", usage)
+	}
+
+	var notifications int64
+	db.Model(&models.Notification{}).Where("user_id = ? AND type = ?", waiter.ID, "waitlist.fulfilled").Count(&notifications)
+	if notifications != 1 {
+		t.Errorf("notifications = %d, want 1", notifications)
+	}
+}
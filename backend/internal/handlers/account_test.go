@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func extendTemporary(t *testing.T, h *AccountHandler, userID uint, values gin.H, req AccountExtendRequest) (int, models.TemporaryUsage) {
+	t.Helper()
+	w := performAs(t, userID, values, h.ExtendTemporary, http.MethodPost, "/accounts/extend", "/accounts/extend", req)
+	var usage models.TemporaryUsage
+	if w.Code == http.StatusOK {
+		if err := h.db.Where("account_id = ? AND user_id = ? AND returned_at IS NULL", req.AccountID, userID).First(&usage).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, usage
+}
+
+// reloadUsage 重新读取占用记录，时间取数据库保存的精度
+func reloadUsage(t *testing.T, h *AccountHandler, usage *models.TemporaryUsage) {
+	t.Helper()
+	if err := h.db.First(usage, usage.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtendTemporaryLimits(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	user := createTestUser(t, db)
+
+	// 已占用 1 小时，剩余 30 分钟
+	account := createTestAccount(t, db, "temporary", "locked", 0)
+	usage := createTestUsage(t, db, account.ID, user.ID, 30*time.Minute)
+	reloadUsage(t, h, &usage)
+
+	// 单次续期不能超过账号类型的占用时长
+	if code, _ := extendTemporary(t, h, user.ID, nil, AccountExtendRequest{AccountID: account.ID, Hours: 2}); code != http.StatusBadRequest {
+		t.Errorf("2h extension: status = %d, want 400", code)
+	}
+
+	code, extended := extendTemporary(t, h, user.ID, nil, AccountExtendRequest{AccountID: account.ID})
+	if code != http.StatusOK || extended.Extensions != 1 || !extended.ExpiresAt.Equal(usage.ExpiresAt.Add(time.Hour)) {
+		t.Fatalf("default extension: status = %d, usage = %+v", code, extended)
+	}
+
+	// 总时长上限 4 小时：第二次续期被截断到上限，之后不能再续
+	code, extended = extendTemporary(t, h, user.ID, nil, AccountExtendRequest{AccountID: account.ID})
+	if code != http.StatusOK {
+		t.Fatalf("second extension: status = %d", code)
+	}
+	code, extended = extendTemporary(t, h, user.ID, nil, AccountExtendRequest{AccountID: account.ID})
+	if limit := usage.StartedAt.Add(4 * time.Hour); code != http.StatusOK || !extended.ExpiresAt.Equal(limit) {
+		t.Fatalf("capped extension: status = %d, expires_at = %s, want %s", code, extended.ExpiresAt, limit)
+	}
+	if code, _ := extendTemporary(t, h, user.ID, nil, AccountExtendRequest{AccountID: account.ID}); code != http.StatusConflict {
+		t.Errorf("extension past the cap: status = %d, want 409", code)
+	}
+
+	// 只能续期自己的占用
+	other := createTestUser(t, db)
+	if code, _ := extendTemporary(t, h, other.ID, nil, AccountExtendRequest{AccountID: account.ID}); code != http.StatusNotFound {
+		t.Errorf("extending another user's claim: status = %d, want 404", code)
+	}
+
+	var audits int64
+	db.Model(&models.AuditLog{}).Where("user_id = ? AND action = ?", user.ID, "temporary.extend").Count(&audits)
+	if audits != 3 {
+		t.Errorf("extend audit entries = %d, want 3", audits)
+	}
+}
+
+func TestExtendTemporaryUsesSubscriptionPlanLimit(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	h.claimMaxTotal["monthly"] = 8 * time.Hour
+	user := createTestUser(t, db)
+	subscription := createTestSubscription(t, db, user.ID)
+
+	// 已占用 1 小时，剩余 3 小时，默认上限下已无法续期
+	account := createTestAccount(t, db, "temporary", "locked", 0)
+	usage := createTestUsage(t, db, account.ID, user.ID, 3*time.Hour)
+	reloadUsage(t, h, &usage)
+	if code, _ := extendTemporary(t, h, user.ID, nil, AccountExtendRequest{AccountID: account.ID}); code != http.StatusConflict {
+		t.Fatalf("without subscription: status = %d, want 409", code)
+	}
+
+	code, extended := extendTemporary(t, h, user.ID, gin.H{"subscription": subscription}, AccountExtendRequest{AccountID: account.ID})
+	if code != http.StatusOK || !extended.ExpiresAt.Equal(usage.ExpiresAt.Add(time.Hour)) {
+		t.Fatalf("with monthly plan: status = %d, usage = %+v", code, extended)
+	}
+}
+
+func TestListAccountsValidatesFilters(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	user := createTestUser(t, db)
+	source := "list-" + uniqueSuffix()
+	for _, price := range []int{500, 1500} {
+		account := createTestAccount(t, db, "exclusive", "available", price)
+		if err := db.Model(&account).Update("source", source).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		items int
+	}{
+		{"min_price=1000", http.StatusOK, 1},
+		{"max_price=1000", http.StatusOK, 1},
+		{"min_price=0&max_price=2000", http.StatusOK, 2},
+		{"min_price=abc", http.StatusBadRequest, 0},
+		{"max_price=10.5", http.StatusBadRequest, 0},
+		{"min_price=-1", http.StatusBadRequest, 0},
+		{"cursor=%21", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		target := "/accounts?source=" + source + "&" + tt.query
+		w := performAs(t, user.ID, nil, h.ListAccounts, http.MethodGet, "/accounts", target, nil)
+		if w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.query, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var response AccountListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Items) != tt.items {
+			t.Errorf("%s: %d items, want %d", tt.query, len(response.Items), tt.items)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// waitlistScanLimit 移交时最多检查的排队记录数（跳过不符合条件的用户）
+const waitlistScanLimit = 20
+
+type WaitlistJoinRequest struct {
+	AccountID *uint `json:"account_id"` // 为空表示任意临时账号
+}
+
+type WaitlistEntryResponse struct {
+	models.AccountWaitlist
+	Position int `json:"position,omitempty"`
+}
+
+func (h *AccountHandler) JoinWaitlist(c *gin.Context) {
+	var req WaitlistJoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if req.AccountID != nil {
+		var account models.Account
+		if err := h.db.Where("id = ? AND type = ?", *req.AccountID, "temporary").First(&account).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch account"})
+			}
+			return
+		}
+		if account.Status == "available" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account is available, claim it directly"})
+			return
+		}
+		if account.Status != "locked" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account is not in service"})
+			return
+		}
+	} else {
+		var available int64
+		if err := h.db.Model(&models.Account{}).
+			Where("type = ? AND status = ?", "temporary", "available").
+			Count(&available).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check availability"})
+			return
+		}
+		if available > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Temporary accounts are available, claim one directly"})
+			return
+		}
+	}
+
+	duplicate := h.db.Model(&models.AccountWaitlist{}).Where("user_id = ? AND status = ?", userID, "waiting")
+	if req.AccountID != nil {
+		duplicate = duplicate.Where("account_id = ?", *req.AccountID)
+	} else {
+		duplicate = duplicate.Where("account_id IS NULL")
+	}
+	var count int64
+	if err := duplicate.Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check waitlist"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already on the waitlist"})
+		return
+	}
+
+	entry := models.AccountWaitlist{
+		UserID:    userID.(uint),
+		AccountID: req.AccountID,
+		Status:    "waiting",
+	}
+	if err := h.db.Create(&entry).Error; err != nil {
+		// 并发请求越过了上面的检查，由部分唯一索引拦截
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Already on the waitlist"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join waitlist"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, WaitlistEntryResponse{
+		AccountWaitlist: entry,
+		Position:        h.waitlistPosition(entry),
+	})
+}
+
+func (h *AccountHandler) GetMyWaitlist(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var entries []models.AccountWaitlist
+	if err := h.db.Where("user_id = ?", userID).Order("created_at desc").Limit(50).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch waitlist"})
+		return
+	}
+
+	results := make([]WaitlistEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		item := WaitlistEntryResponse{AccountWaitlist: entry}
+		if entry.Status == "waiting" {
+			item.Position = h.waitlistPosition(entry)
+		}
+		results = append(results, item)
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+func (h *AccountHandler) LeaveWaitlist(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid waitlist ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := h.db.Model(&models.AccountWaitlist{}).
+		Where("id = ? AND user_id = ? AND status = ?", id, userID, "waiting").
+		Update("status", "canceled")
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave waitlist"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Waitlist entry not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Left waitlist"})
+}
+
+// waitlistPosition 估算排队位置：排在前面且会竞争同一账号的记录数 + 1
+func (h *AccountHandler) waitlistPosition(entry models.AccountWaitlist) int {
+	query := h.db.Model(&models.AccountWaitlist{}).
+		Where("status = ? AND (created_at < ? OR (created_at = ? AND id < ?))", "waiting", entry.CreatedAt, entry.CreatedAt, entry.ID)
+	if entry.AccountID != nil {
+		query = query.Where("account_id = ? OR account_id IS NULL", *entry.AccountID)
+	} else {
+		query = query.Where("account_id IS NULL")
+	}
+
+	var ahead int64
+	query.Count(&ahead)
+	return int(ahead) + 1
+}
+
+// releaseTemporaryAccount 释放临时账号：有人排队则直接移交，否则置为 available
+func (h *AccountHandler) releaseTemporaryAccount(tx *gorm.DB, accountID uint) (bool, error) {
+	var account models.Account
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND type = ?", accountID, "temporary").
+		First(&account).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if account.Status != "locked" {
+		return false, nil
+	}
+
+	usage, err := h.handOffTemporary(tx, account)
+	if err != nil || usage != nil {
+		return usage != nil, err
+	}
+	return false, tx.Model(&models.Account{}).Where("id = ?", account.ID).Update("status", "available").Error
+}
+
+// handOffTemporary 将账号交给排队中的下一位符合条件的用户，调用方需已锁定账号行。
+// 没有符合条件的排队用户时返回 nil
+func (h *AccountHandler) handOffTemporary(tx *gorm.DB, account models.Account) (*models.TemporaryUsage, error) {
+	var entries []models.AccountWaitlist
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND (account_id = ? OR account_id IS NULL)", "waiting", account.ID).
+		Order("created_at asc, id asc").
+		Limit(waitlistScanLimit).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		// 与 checkClaimLimit 使用同一把用户行锁，避免与该用户的并发申请一起突破占用上限。
+		// 用户正在申请时跳过，保留其排队位置等待下次释放，也避免与申请路径互相等待
+		locked, err := tryLockUser(tx, entry.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !locked {
+			continue
+		}
+
+		eligible, err := h.waitlistEligible(tx, entry.UserID, now)
+		if err != nil {
+			return nil, err
+		}
+		if !eligible {
+			continue
+		}
+
+		usage := models.TemporaryUsage{
+			AccountID: account.ID,
+			UserID:    entry.UserID,
+			StartedAt: now,
+			ExpiresAt: now.Add(h.claimDuration(account.Type)),
+		}
+		if err := tx.Create(&usage).Error; err != nil {
+			return nil, err
+		}
+
+		if err := tx.Model(&models.AccountWaitlist{}).Where("id = ?", entry.ID).Updates(map[string]interface{}{
+			"status":       "fulfilled",
+			"usage_id":     usage.ID,
+			"fulfilled_at": now,
+		}).Error; err != nil {
+			return nil, err
+		}
+
+		if err := recordAudit(tx, entry.UserID, "temporary.handoff", "account", account.ID, map[string]interface{}{
+			"usage_id":    usage.ID,
+			"waitlist_id": entry.ID,
+			"expires_at":  usage.ExpiresAt,
+		}); err != nil {
+			return nil, err
+		}
+
+		if err := notifyUser(tx, entry.UserID, "waitlist.fulfilled", "排队的临时账号已分配",
+			fmt.Sprintf("临时账号 %s 已分配给你，有效期至 %s。", account.Main, usage.ExpiresAt.Format("2006-01-02 15:04"))); err != nil {
+			return nil, err
+		}
+		return &usage, nil
+	}
+
+	return nil, nil
+}
+
+// tryLockUser 不等待地锁定用户行，行已被其他事务锁定时返回 false
+func tryLockUser(tx *gorm.DB, userID uint) (bool, error) {
+	var users []models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Select("id").
+		Where("id = ?", userID).
+		Find(&users).Error; err != nil {
+		return false, err
+	}
+	return len(users) > 0, nil
+}
+
+// waitlistEligible 用户需要有有效订阅且未达到并发占用上限
+func (h *AccountHandler) waitlistEligible(tx *gorm.DB, userID uint, now time.Time) (bool, error) {
+	var subscriptions int64
+	if err := tx.Model(&models.Subscription{}).
+		Where("user_id = ? AND status IN ? AND starts_at <= ? AND (expires_at > ? OR grace_ends_at > ?)",
+			userID, []string{"active", "past_due"}, now, now, now).
+		Count(&subscriptions).Error; err != nil {
+		return false, err
+	}
+	if subscriptions == 0 {
+		return false, nil
+	}
+
+	if h.claimMaxConcurrent <= 0 {
+		return true, nil
+	}
+	var active int64
+	if err := tx.Model(&models.TemporaryUsage{}).
+		Where("user_id = ? AND returned_at IS NULL AND expires_at > ?", userID, now).
+		Count(&active).Error; err != nil {
+		return false, err
+	}
+	return int(active) < h.claimMaxConcurrent, nil
+}
+
+// assignAvailableToWaitlist 为排队用户分配当前空闲的临时账号（例如新上架的账号）
+func (h *AccountHandler) assignAvailableToWaitlist(tx *gorm.DB) (int, error) {
+	var waiting int64
+	if err := tx.Model(&models.AccountWaitlist{}).Where("status = ?", "waiting").Count(&waiting).Error; err != nil {
+		return 0, err
+	}
+	if waiting == 0 {
+		return 0, nil
+	}
+
+	var accounts []models.Account
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("type = ? AND status = ?", "temporary", "available").
+		Order("id asc").
+		Limit(int(waiting)).
+		Find(&accounts).Error; err != nil {
+		return 0, err
+	}
+
+	assigned := 0
+	for i := range accounts {
+		usage, err := h.serveWaitlistFirst(tx, &accounts[i])
+		if err != nil {
+			return assigned, err
+		}
+		if usage != nil {
+			assigned++
+		}
+	}
+	return assigned, nil
+}
+
+// serveWaitlistFirst 空闲账号先交给排队中的用户，保证先到先得，移交后账号置为 locked。
+// 返回 nil 表示没有符合条件的排队用户，调用方可以自行分配。调用方需已锁定账号行
+func (h *AccountHandler) serveWaitlistFirst(tx *gorm.DB, account *models.Account) (*models.TemporaryUsage, error) {
+	usage, err := h.handOffTemporary(tx, *account)
+	if err != nil || usage == nil {
+		return nil, err
+	}
+	account.Status = "locked"
+	if err := tx.Model(&models.Account{}).Where("id = ?", account.ID).Update("status", "locked").Error; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
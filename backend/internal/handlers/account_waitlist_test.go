@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// joinTestWaitlist 为用户开通订阅并加入指定账号的排队
+func joinTestWaitlist(t *testing.T, db *gorm.DB, userID, accountID uint) models.AccountWaitlist {
+	t.Helper()
+	createTestSubscription(t, db, userID)
+	entry := models.AccountWaitlist{UserID: userID, AccountID: &accountID, Status: "waiting"}
+	if err := db.Create(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+// activeHolder 返回账号当前占用人，未被占用时返回 0
+func activeHolder(t *testing.T, db *gorm.DB, accountID uint) uint {
+	t.Helper()
+	var usages []models.TemporaryUsage
+	if err := db.Where("account_id = ? AND returned_at IS NULL", accountID).Find(&usages).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 {
+		if len(usages) > 1 {
+			t.Fatalf("account %d has %d active usages", accountID, len(usages))
+		}
+		return 0
+	}
+	return usages[0].UserID
+}
+
+func TestClaimTemporaryServesWaitlistFirst(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	waiter := createTestUser(t, db)
+	requester := createTestUser(t, db)
+
+	// 账号刚被归还但还没移交时，排队的用户仍然优先
+	account := createTestAccount(t, db, "temporary", "available", 0)
+	entry := joinTestWaitlist(t, db, waiter.ID, account.ID)
+
+	w := performAs(t, requester.ID, nil, h.ClaimTemporary, http.MethodPost, "/accounts/temporary/claim", "/accounts/temporary/claim",
+		AccountClaimRequest{AccountID: account.ID})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("claim ahead of the queue: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	if holder := activeHolder(t, db, account.ID); holder != waiter.ID {
+		t.Errorf("account holder = %d, want waiting user %d", holder, waiter.ID)
+	}
+	if err := db.First(&account, account.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&entry, entry.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if account.Status != "locked" || entry.Status != "fulfilled" {
+		t.Errorf("account status = %s, waitlist status = %s", account.Status, entry.Status)
+	}
+}
+
+func TestClaimTemporaryByQueueHead(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	waiter := createTestUser(t, db)
+
+	account := createTestAccount(t, db, "temporary", "available", 0)
+	entry := joinTestWaitlist(t, db, waiter.ID, account.ID)
+
+	// 排在队首的用户直接申请，获得账号并完成自己的排队记录
+	w := performAs(t, waiter.ID, nil, h.ClaimTemporary, http.MethodPost, "/accounts/temporary/claim", "/accounts/temporary/claim",
+		AccountClaimRequest{AccountID: account.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("claim by queue head: status = %d, body = %s", w.Code, w.Body)
+	}
+	if holder := activeHolder(t, db, account.ID); holder != waiter.ID {
+		t.Errorf("account holder = %d, want %d", holder, waiter.ID)
+	}
+	if err := db.First(&entry, entry.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != "fulfilled" {
+		t.Errorf("waitlist status = %s, want fulfilled", entry.Status)
+	}
+}
+
+func TestAllocateTemporarySkipsAccountsOwedToWaitlist(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestAccountHandler(db)
+	waiter := createTestUser(t, db)
+	requester := createTestUser(t, db)
+
+	source := "waitlist-" + uniqueSuffix()
+	owed := createTestAccount(t, db, "temporary", "available", 0)
+	free := createTestAccount(t, db, "temporary", "available", 0)
+	db.Model(&models.Account{}).Where("id IN ?", []uint{owed.ID, free.ID}).Update("source", source)
+	joinTestWaitlist(t, db, waiter.ID, owed.ID)
+
+	w := performAs(t, requester.ID, nil, h.AllocateAccount, http.MethodPost, "/accounts/allocate", "/accounts/allocate",
+		AccountAllocateRequest{Type: "temporary", Source: source})
+	if w.Code != http.StatusOK {
+		t.Fatalf("allocate: status = %d, body = %s", w.Code, w.Body)
+	}
+	if holder := activeHolder(t, db, owed.ID); holder != waiter.ID {
+		t.Errorf("owed account holder = %d, want waiting user %d", holder, waiter.ID)
+	}
+	if holder := activeHolder(t, db, free.ID); holder != requester.ID {
+		t.Errorf("free account holder = %d, want requester %d", holder, requester.ID)
+	}
+
+	// 没有剩余的空闲账号时返回 409，已完成的移交仍然保留
+	other := createTestUser(t, db)
+	w = performAs(t, other.ID, nil, h.AllocateAccount, http.MethodPost, "/accounts/allocate", "/accounts/allocate",
+		AccountAllocateRequest{Type: "temporary", Source: source})
+	if w.Code != http.StatusConflict {
+		t.Errorf("allocate with nothing left: status = %d, want 409", w.Code)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// recordAudit 写入一条审计日志
+func recordAudit(tx *gorm.DB, userID uint, action, targetType string, targetID uint, metadata interface{}) error {
+	entry := models.AuditLog{
+		UserID:     userID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatUint(uint64(targetID), 10),
+	}
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		entry.Metadata = string(data)
+	}
+	return tx.Create(&entry).Error
+}
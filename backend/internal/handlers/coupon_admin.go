@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CouponAdminHandler 管理员维护优惠码
+type CouponAdminHandler struct {
+	db *gorm.DB
+}
+
+func NewCouponAdminHandler(db *gorm.DB) *CouponAdminHandler {
+	return &CouponAdminHandler{db: db}
+}
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// CouponInput 创建优惠码请求
+type CouponInput struct {
+	Code          string     `json:"code" binding:"required"`
+	Description   string     `json:"description"`
+	DiscountType  string     `json:"discount_type" binding:"required,oneof=percent fixed"`
+	DiscountValue int        `json:"discount_value" binding:"min=1"`
+	ProductTypes  []string   `json:"product_types"`
+	MaxUses       int        `json:"max_uses" binding:"min=0"`
+	PerUserLimit  int        `json:"per_user_limit" binding:"min=0"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	Active        *bool      `json:"active"`
+}
+
+// CouponUpdate 修改优惠码请求。优惠码和折扣方式创建后不能修改，已下单的订单金额不受影响
+type CouponUpdate struct {
+	Description   *string    `json:"description"`
+	DiscountValue *int       `json:"discount_value"`
+	ProductTypes  *[]string  `json:"product_types"`
+	MaxUses       *int       `json:"max_uses"`
+	PerUserLimit  *int       `json:"per_user_limit"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	Active        *bool      `json:"active"`
+}
+
+// validateCouponDiscount percent 折扣比例为 1-99，fixed 金额大于 0
+func validateCouponDiscount(discountType string, value int) string {
+	if value < 1 {
+		return "折扣必须大于 0"
+	}
+	if discountType == "percent" && value > 99 {
+		return "折扣比例必须在 1-99 之间"
+	}
+	return ""
+}
+
+// cleanProductTypes 去除空白和重复的产品类型
+func cleanProductTypes(productTypes []string) []string {
+	seen := make(map[string]bool, len(productTypes))
+	result := make([]string, 0, len(productTypes))
+	for _, t := range productTypes {
+		t = strings.TrimSpace(t)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// ListCoupons 分页查询优惠码，可按 active 过滤或按 code 前缀搜索
+func (h *CouponAdminHandler) ListCoupons(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := h.db.Model(&models.Coupon{})
+	if active := c.Query("active"); active != "" {
+		query = query.Where("active = ?", active == "true")
+	}
+	if code := normalizeCouponCode(c.Query("code")); code != "" {
+		query = query.Where("code LIKE ?", code+"%")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询优惠码失败"})
+		return
+	}
+
+	var coupons []models.Coupon
+	if err := query.Order("id desc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&coupons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询优惠码失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"coupons":   coupons,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetCoupon 查询优惠码及其订单使用情况
+func (h *CouponAdminHandler) GetCoupon(c *gin.Context) {
+	var coupon models.Coupon
+	if err := h.db.First(&coupon, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "优惠码不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询优惠码失败"})
+		}
+		return
+	}
+
+	var usage []struct {
+		Status   string `json:"status"`
+		Orders   int64  `json:"orders"`
+		Discount int64  `json:"discount"`
+	}
+	if err := h.db.Model(&models.Payment{}).
+		Select("status, COUNT(*) AS orders, COALESCE(SUM(discount_amount), 0) AS discount").
+		Where("coupon_id = ?", coupon.ID).
+		Group("status").
+		Scan(&usage).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询优惠码失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"coupon": coupon, "usage": usage})
+}
+
+// CreateCoupon 创建优惠码
+func (h *CouponAdminHandler) CreateCoupon(c *gin.Context) {
+	var in CouponInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code := normalizeCouponCode(in.Code)
+	if !couponCodePattern.MatchString(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "优惠码只能包含 3-32 位字母、数字、下划线和连字符"})
+		return
+	}
+	if msg := validateCouponDiscount(in.DiscountType, in.DiscountValue); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "结束时间必须晚于开始时间"})
+		return
+	}
+	operatorID := c.GetUint("user_id")
+
+	coupon := models.Coupon{
+		Code:          code,
+		Description:   in.Description,
+		DiscountType:  in.DiscountType,
+		DiscountValue: in.DiscountValue,
+		ProductTypes:  cleanProductTypes(in.ProductTypes),
+		MaxUses:       in.MaxUses,
+		PerUserLimit:  in.PerUserLimit,
+		StartsAt:      in.StartsAt,
+		EndsAt:        in.EndsAt,
+		Active:        true,
+		CreatedBy:     operatorID,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&coupon).Error; err != nil {
+			return err
+		}
+		// Active 有数据库默认值，false 需要单独更新
+		if in.Active != nil && !*in.Active {
+			if err := tx.Model(&coupon).Update("active", false).Error; err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, operatorID, "coupon.create", "coupon", coupon.ID, map[string]interface{}{
+			"code":           coupon.Code,
+			"discount_type":  coupon.DiscountType,
+			"discount_value": coupon.DiscountValue,
+			"max_uses":       coupon.MaxUses,
+		})
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "优惠码已存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建优惠码失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"coupon": coupon})
+}
+
+// UpdateCoupon 修改优惠码。max_uses 可以调低到已用次数以下，此时优惠码不能再被使用
+func (h *CouponAdminHandler) UpdateCoupon(c *gin.Context) {
+	var in CouponUpdate
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.MaxUses != nil && *in.MaxUses < 0 || in.PerUserLimit != nil && *in.PerUserLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "使用次数不能为负数"})
+		return
+	}
+	operatorID := c.GetUint("user_id")
+
+	var (
+		coupon  models.Coupon
+		message string
+	)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&coupon, c.Param("id")).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if in.Description != nil {
+			updates["description"] = *in.Description
+		}
+		if in.DiscountValue != nil {
+			if message = validateCouponDiscount(coupon.DiscountType, *in.DiscountValue); message != "" {
+				return errInvalidInput
+			}
+			updates["discount_value"] = *in.DiscountValue
+		}
+		if in.ProductTypes != nil {
+			updates["product_types"] = cleanProductTypes(*in.ProductTypes)
+		}
+		if in.MaxUses != nil {
+			updates["max_uses"] = *in.MaxUses
+		}
+		if in.PerUserLimit != nil {
+			updates["per_user_limit"] = *in.PerUserLimit
+		}
+		if in.StartsAt != nil {
+			updates["starts_at"] = *in.StartsAt
+		}
+		if in.EndsAt != nil {
+			updates["ends_at"] = *in.EndsAt
+		}
+		if in.Active != nil {
+			updates["active"] = *in.Active
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		startsAt, endsAt := coupon.StartsAt, coupon.EndsAt
+		if in.StartsAt != nil {
+			startsAt = in.StartsAt
+		}
+		if in.EndsAt != nil {
+			endsAt = in.EndsAt
+		}
+		if startsAt != nil && endsAt != nil && !endsAt.After(*startsAt) {
+			message = "结束时间必须晚于开始时间"
+			return errInvalidInput
+		}
+
+		// product_types 需要经过 json 序列化，单独按结构体更新
+		columns := make(map[string]interface{}, len(updates))
+		for k, v := range updates {
+			if k != "product_types" {
+				columns[k] = v
+			}
+		}
+		if len(columns) > 0 {
+			if err := tx.Model(&coupon).Updates(columns).Error; err != nil {
+				return err
+			}
+		}
+		if in.ProductTypes != nil {
+			if err := tx.Model(&coupon).Select("product_types").
+				Updates(&models.Coupon{ProductTypes: updates["product_types"].([]string)}).Error; err != nil {
+				return err
+			}
+		}
+		if err := recordAudit(tx, operatorID, "coupon.update", "coupon", coupon.ID, map[string]interface{}{
+			"code":   coupon.Code,
+			"fields": updates,
+		}); err != nil {
+			return err
+		}
+		return tx.First(&coupon, coupon.ID).Error
+	})
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"coupon": coupon})
+	case errors.Is(err, errInvalidInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "优惠码不存在"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新优惠码失败"})
+	}
+}
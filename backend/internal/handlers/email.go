@@ -1,27 +1,38 @@
 package handlers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"fullstack-backend/internal/config"
 	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/secretbox"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type EmailHandler struct {
-	db *gorm.DB
+	db                *gorm.DB
+	apiVerifier       *APIVerifier
+	verifyCacheMaxAge time.Duration
+	cachedHitCost     int
+	secrets           *secretbox.Box
 }
 
-func NewEmailHandler(db *gorm.DB) *EmailHandler {
-	return &EmailHandler{db: db}
+func NewEmailHandler(db *gorm.DB, cfg *config.Config) *EmailHandler {
+	return &EmailHandler{
+		db:                db,
+		apiVerifier:       NewAPIVerifier(cfg),
+		verifyCacheMaxAge: cfg.VerifyCacheMaxAge,
+		cachedHitCost:     cfg.VerifyCachedHitCost,
+		secrets:           secretbox.New(cfg.DataEncryptionKey),
+	}
 }
 
 type EmailMeta struct {
@@ -378,105 +389,33 @@ func (h *EmailHandler) ImportEmails(c *gin.Context) {
 	}
 	userID := userIDValue.(uint)
 
-	// Check for duplicates in database
-	for _, emailInput := range req.Emails {
-		var existing models.Email
-		if err := h.db.Where("user_id = ? AND main = ?", userID, emailInput.Main).First(&existing).Error; err == nil {
-			c.JSON(http.StatusConflict, gin.H{
-				"error": fmt.Sprintf("Email already exists: %s", emailInput.Main),
-			})
-			return
-		}
-	}
-
-	// Use transaction to insert all emails
-	tx := h.db.Begin()
-	imported := 0
-
 	importName := strings.TrimSpace(file.Filename)
 	if importName == "" {
 		importName = fmt.Sprintf("import-%s", time.Now().Format("20060102-150405"))
 	}
 
-	importRecord := models.EmailImport{
-		UserID:     userID,
-		Name:       importName,
-		SourceFile: file.Filename,
-	}
-	if err := tx.Create(&importRecord).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import record"})
+	// 大文件写入耗时较长，交给队列工作进程执行，客户端通过 GET /emails/jobs/:id 查询结果
+	input, err := json.Marshal(importJobInput{Name: importName, SourceFile: file.Filename, Emails: req.Emails})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue import"})
 		return
 	}
-
-	for _, emailInput := range req.Emails {
-		email := models.Email{
-			UserID:   userID,
-			ImportID: importRecord.ID,
-			Main:     emailInput.Main,
-			Password: emailInput.Password,
-			Deputy:   emailInput.Deputy,
-			Key2FA:   emailInput.Key2FA,
-		}
-
-		if emailInput.Meta != nil {
-			if emailInput.Meta.Banned != nil {
-				email.Banned = *emailInput.Meta.Banned
-			}
-			if emailInput.Meta.Price != nil {
-				email.Price = *emailInput.Meta.Price
-			}
-			if emailInput.Meta.Sold != nil {
-				email.Sold = *emailInput.Meta.Sold
-			}
-			if emailInput.Meta.NeedRepair != nil {
-				email.NeedRepair = *emailInput.Meta.NeedRepair
-			}
-			if emailInput.Meta.From != nil {
-				email.Source = *emailInput.Meta.From
-			}
-		}
-
-		if err := tx.Create(&email).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to import email %s: %s", emailInput.Main, err.Error()),
-			})
-			return
-		}
-
-		// Insert family emails
-		for _, familyInput := range emailInput.Familys {
-			family := models.EmailFamily{
-				EmailID:  email.ID,
-				Email:    familyInput.Email,
-				Password: familyInput.Password,
-				Code:     familyInput.Code,
-				Contact:  familyInput.Contact,
-				Issue:    familyInput.Issue,
-			}
-			if err := tx.Create(&family).Error; err != nil {
-				tx.Rollback()
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": fmt.Sprintf("Failed to import family email for %s: %s", emailInput.Main, err.Error()),
-				})
-				return
-			}
-		}
-
-		imported++
+	job := models.EmailJob{
+		UserID: userID,
+		Type:   "import",
+		Input:  string(input),
+		Total:  len(req.Emails),
 	}
-
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+	if err := h.enqueueEmailJob(&job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue import"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Import successful",
-		"imported":    imported,
-		"import_id":   importRecord.ID,
-		"import_name": importRecord.Name,
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Import queued",
+		"job_id":  job.ID,
+		"status":  job.Status,
+		"total":   job.Total,
 	})
 }
 
@@ -538,12 +477,15 @@ type VerifyEmailRequest struct {
 	Emails []string `json:"mail" binding:"required"`
 	Key    string   `json:"key"`                       // 第三方 API 需要
 	Method string   `json:"method" binding:"required"` // "api" 或 "smtp"
+	MaxAge *int     `json:"max_age"`                   // 可接受的缓存结果最长时间（秒），0 表示强制重新验证
 }
 
 type VerifyEmailResponse struct {
-	Email  string `json:"email"`
-	Status string `json:"status"` // live, verify, dead, error
-	Error  string `json:"error,omitempty"`
+	Email     string     `json:"email"`
+	Status    string     `json:"status"` // live, verify, dead, error
+	Error     string     `json:"error,omitempty"`
+	Cached    bool       `json:"cached,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
 }
 
 func (h *EmailHandler) VerifyEmails(c *gin.Context) {
@@ -565,104 +507,102 @@ func (h *EmailHandler) VerifyEmails(c *gin.Context) {
 	}
 	userID := userIDValue.(uint)
 
-	var results []VerifyEmailResponse
-
-	// 根据验证方法选择不同的验证逻辑
-	switch req.Method {
-	case "smtp":
-		// 使用 SMTP 验证
-		results = h.verifyEmailsSMTP(req.Emails)
-	case "api":
-		// 使用第三方 API 验证
-		if req.Key == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required for API method"})
+	maxAge := h.verifyCacheMaxAge
+	if req.MaxAge != nil {
+		if *req.MaxAge < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_age must not be negative"})
 			return
 		}
-		var err error
-		results, err = h.verifyEmailsAPI(req.Emails, req.Key)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-	default:
+		maxAge = time.Duration(*req.MaxAge) * time.Second
+	}
+
+	if req.Method != "smtp" && req.Method != "api" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid method. Use 'smtp' or 'api'"})
 		return
 	}
-
-	// 更新数据库中的邮箱状态
-	for _, result := range results {
-		var dbEmail models.Email
-		if err := h.db.Where("user_id = ? AND main = ?", userID, result.Email).First(&dbEmail).Error; err == nil {
-			dbEmail.Status = result.Status
-			h.db.Save(&dbEmail)
-		}
+	if req.Method == "api" && req.Key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key is required for API method"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"results": results,
-		"total":   len(results),
-		"method":  req.Method,
-	})
-}
-
-// verifyEmailsAPI 使用第三方 API 验证邮箱
-func (h *EmailHandler) verifyEmailsAPI(emails []string, key string) ([]VerifyEmailResponse, error) {
-	apiURL := "https://gmailver.com/php/check1.php"
-	payload := map[string]interface{}{
-		"mail":      emails,
-		"key":       key,
-		"fastCheck": false,
+	// 按缓存命中情况预估额度，明显不足时直接拒绝；实际扣减在任务完成时进行
+	_, pending := h.splitCachedResults(req.Emails, req.Method, maxAge)
+	cachedCount := len(req.Emails) - len(pending)
+	cost := len(pending) + cachedCount*h.cachedHitCost
+	keyValue, ok := c.Get("license_key")
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "需要有效的 License Key"})
+		return
 	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare request")
+	key := keyValue.(models.LicenseKey)
+	if remaining := key.QuotaTotal - key.QuotaUsed; cost > remaining {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":           "License Key 剩余额度不足",
+			"quota_required":  cost,
+			"quota_remaining": remaining,
+		})
+		return
 	}
 
-	// 发送请求到第三方 API
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(payloadBytes))
+	apiKey, err := h.secrets.Seal(req.Key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify emails: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue verification"})
+		return
 	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
+	input, err := json.Marshal(verifyJobInput{
+		Emails: req.Emails,
+		Method: req.Method,
+		APIKey: apiKey,
+		MaxAge: int(maxAge / time.Second),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue verification"})
+		return
 	}
-
-	// 解析响应 - 第三方 API 返回格式：{"data": {"email": "status", ...}}
-	var apiResponse struct {
-		Message      string                 `json:"message"`
-		Data         map[string]interface{} `json:"data"`
-		ResponseTime string                 `json:"responseTime"`
-		Status       string                 `json:"status"`
+	job := models.EmailJob{
+		UserID:       userID,
+		Type:         "verify",
+		Input:        string(input),
+		LicenseKeyID: &key.ID,
+		Total:        len(req.Emails),
 	}
-
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %s", string(body))
+	if err := h.enqueueEmailJob(&job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue verification"})
+		return
 	}
 
-	// 检查 API 是否返回错误
-	if apiResponse.Status == "error" || apiResponse.Data == nil {
-		return nil, fmt.Errorf("third-party API returned error: %s", apiResponse.Message)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Verification queued",
+		"job_id":  job.ID,
+		"status":  job.Status,
+		"total":   job.Total,
+		"method":  req.Method,
+	})
+}
+
+// verifyFresh 对未命中缓存的地址发起实时验证，并写入共享缓存
+func (h *EmailHandler) verifyFresh(ctx context.Context, emails []string, method, key string) ([]VerifyEmailResponse, error) {
+	if len(emails) == 0 {
+		return nil, nil
 	}
 
-	// 从 data 字段中提取邮箱状态
-	results := make([]VerifyEmailResponse, 0)
-	for email, statusInterface := range apiResponse.Data {
-		status, ok := statusInterface.(string)
-		if !ok {
-			status = "error"
+	var results []VerifyEmailResponse
+	switch method {
+	case "smtp":
+		// 使用 SMTP 验证
+		results = h.verifyEmailsSMTP(emails)
+	case "api":
+		// 使用第三方 API 验证
+		var err error
+		results, err = h.apiVerifier.Verify(ctx, emails, key)
+		if err != nil {
+			return nil, err
 		}
-
-		results = append(results, VerifyEmailResponse{
-			Email:  email,
-			Status: status,
-		})
+	default:
+		return nil, fmt.Errorf("invalid method: %s", method)
 	}
 
+	h.storeVerificationResults(results, method)
 	return results, nil
 }
 
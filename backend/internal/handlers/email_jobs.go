@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/queue"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 邮箱任务状态
+const (
+	emailJobQueued  = "queued"
+	emailJobRunning = "running"
+	emailJobDone    = "done"
+	emailJobFailed  = "failed"
+)
+
+// emailJobQueueTypes 邮箱任务类型对应的队列任务类型
+var emailJobQueueTypes = map[string]string{
+	"import": JobTypeEmailImport,
+	"verify": JobTypeEmailVerify,
+}
+
+type emailJobPayload struct {
+	JobID uint `json:"job_id"`
+}
+
+// importJobInput 导入任务参数，上传的文件在请求中解析后保存
+type importJobInput struct {
+	Name       string             `json:"name"`
+	SourceFile string             `json:"source_file"`
+	Emails     []ImportEmailInput `json:"emails"`
+}
+
+// verifyJobInput 批量验证任务参数
+type verifyJobInput struct {
+	Emails []string `json:"emails"`
+	Method string   `json:"method"`
+	APIKey string   `json:"api_key"` // secretbox 加密
+	MaxAge int      `json:"max_age"` // 秒
+}
+
+// emailJobFailure 因输入或额度问题失败的任务，重试也不会成功，直接标记为失败
+type emailJobFailure string
+
+func (e emailJobFailure) Error() string { return string(e) }
+
+// enqueueEmailJob 保存任务并入队，两者在同一事务中提交
+func (h *EmailHandler) enqueueEmailJob(job *models.EmailJob) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		job.Status = emailJobQueued
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		queued, err := queue.Enqueue(tx, emailJobQueueTypes[job.Type], emailJobPayload{JobID: job.ID}, queue.EnqueueOptions{
+			MaxAttempts: 3,
+		})
+		if err != nil {
+			return err
+		}
+		job.QueueJobID = queued.ID
+		return tx.Model(job).Update("queue_job_id", queued.ID).Error
+	})
+}
+
+// ProcessEmailJob 队列任务：执行邮箱导入或批量验证。结果和任务完成状态在同一事务中写入，
+// 队列重试时已完成的任务直接跳过，不会重复导入或重复扣减额度
+func (h *EmailHandler) ProcessEmailJob(ctx context.Context, payload []byte) error {
+	var p emailJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var job models.EmailJob
+	if err := h.db.First(&job, p.JobID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if job.Status == emailJobDone || job.Status == emailJobFailed {
+		return nil
+	}
+
+	now := time.Now()
+	if err := h.db.Model(&models.EmailJob{}).
+		Where("id = ? AND status IN ?", job.ID, []string{emailJobQueued, emailJobRunning}).
+		Updates(map[string]interface{}{"status": emailJobRunning, "started_at": now}).Error; err != nil {
+		return err
+	}
+
+	var err error
+	switch job.Type {
+	case "import":
+		err = h.runImportJob(job)
+	case "verify":
+		err = h.runVerifyJob(ctx, job)
+	default:
+		err = emailJobFailure("unknown job type: " + job.Type)
+	}
+
+	var failure emailJobFailure
+	if errors.As(err, &failure) {
+		return finishEmailJob(h.db, job.ID, emailJobFailed, nil, failure.Error())
+	}
+	return err
+}
+
+// lockEmailJob 锁定任务行，返回任务是否仍需执行
+func lockEmailJob(tx *gorm.DB, id uint) (bool, error) {
+	var job models.EmailJob
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&job, id).Error; err != nil {
+		return false, err
+	}
+	return job.Status != emailJobDone && job.Status != emailJobFailed, nil
+}
+
+// finishEmailJob 记录任务结果并清空任务参数（其中可能包含邮箱密码和 API key）
+func finishEmailJob(tx *gorm.DB, id uint, status string, result interface{}, errMsg string) error {
+	encoded := ""
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		encoded = string(data)
+	}
+	return tx.Model(&models.EmailJob{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      status,
+		"result":      encoded,
+		"error":       errMsg,
+		"input":       "",
+		"finished_at": time.Now(),
+	}).Error
+}
+
+// runImportJob 在一个事务中写入导入记录和全部邮箱，任一邮箱已存在时整个任务失败
+func (h *EmailHandler) runImportJob(job models.EmailJob) error {
+	var input importJobInput
+	if err := json.Unmarshal([]byte(job.Input), &input); err != nil {
+		return emailJobFailure("invalid job input")
+	}
+
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		pending, err := lockEmailJob(tx, job.ID)
+		if err != nil || !pending {
+			return err
+		}
+
+		for _, emailInput := range input.Emails {
+			var existing models.Email
+			err := tx.Where("user_id = ? AND main = ?", job.UserID, emailInput.Main).First(&existing).Error
+			if err == nil {
+				return emailJobFailure(fmt.Sprintf("Email already exists: %s", emailInput.Main))
+			}
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+		}
+
+		importRecord := models.EmailImport{
+			UserID:     job.UserID,
+			Name:       input.Name,
+			SourceFile: input.SourceFile,
+		}
+		if err := tx.Create(&importRecord).Error; err != nil {
+			return err
+		}
+
+		for _, emailInput := range input.Emails {
+			email := models.Email{
+				UserID:   job.UserID,
+				ImportID: importRecord.ID,
+				Main:     emailInput.Main,
+				Password: emailInput.Password,
+				Deputy:   emailInput.Deputy,
+				Key2FA:   emailInput.Key2FA,
+			}
+
+			if emailInput.Meta != nil {
+				if emailInput.Meta.Banned != nil {
+					email.Banned = *emailInput.Meta.Banned
+				}
+				if emailInput.Meta.Price != nil {
+					email.Price = *emailInput.Meta.Price
+				}
+				if emailInput.Meta.Sold != nil {
+					email.Sold = *emailInput.Meta.Sold
+				}
+				if emailInput.Meta.NeedRepair != nil {
+					email.NeedRepair = *emailInput.Meta.NeedRepair
+				}
+				if emailInput.Meta.From != nil {
+					email.Source = *emailInput.Meta.From
+				}
+			}
+
+			if err := tx.Create(&email).Error; err != nil {
+				return fmt.Errorf("failed to import email %s: %v", emailInput.Main, err)
+			}
+
+			for _, familyInput := range emailInput.Familys {
+				family := models.EmailFamily{
+					EmailID:  email.ID,
+					Email:    familyInput.Email,
+					Password: familyInput.Password,
+					Code:     familyInput.Code,
+					Contact:  familyInput.Contact,
+					Issue:    familyInput.Issue,
+				}
+				if err := tx.Create(&family).Error; err != nil {
+					return fmt.Errorf("failed to import family email for %s: %v", emailInput.Main, err)
+				}
+			}
+		}
+
+		return finishEmailJob(tx, job.ID, emailJobDone, gin.H{
+			"message":     "Import successful",
+			"imported":    len(input.Emails),
+			"import_id":   importRecord.ID,
+			"import_name": importRecord.Name,
+		}, "")
+	})
+}
+
+// runVerifyJob 验证邮箱后在一个事务中扣减额度、更新邮箱状态并完成任务。
+// 远程验证失败（包括熔断）时返回错误，由队列退避重试
+func (h *EmailHandler) runVerifyJob(ctx context.Context, job models.EmailJob) error {
+	var input verifyJobInput
+	if err := json.Unmarshal([]byte(job.Input), &input); err != nil {
+		return emailJobFailure("invalid job input")
+	}
+	if job.LicenseKeyID == nil {
+		return emailJobFailure("License Key 不存在")
+	}
+	apiKey, err := h.secrets.Open(input.APIKey)
+	if err != nil {
+		return emailJobFailure("stored api key cannot be decrypted")
+	}
+
+	results, pending := h.splitCachedResults(input.Emails, input.Method, time.Duration(input.MaxAge)*time.Second)
+	cachedCount := len(results)
+	cost := len(pending) + cachedCount*h.cachedHitCost
+
+	// 验证前先检查额度，避免为付不起的请求发起远程验证
+	var key models.LicenseKey
+	if err := h.db.Where("id = ? AND user_id = ?", *job.LicenseKeyID, job.UserID).First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return emailJobFailure("License Key 不存在")
+		}
+		return err
+	}
+	if key.Status != "active" || key.QuotaTotal-key.QuotaUsed < cost {
+		return emailJobFailure("License Key 剩余额度不足")
+	}
+
+	fresh, err := h.verifyFresh(ctx, pending, input.Method, apiKey)
+	if err != nil {
+		return err
+	}
+	results = orderResults(input.Emails, results, fresh)
+
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		pendingJob, err := lockEmailJob(tx, job.ID)
+		if err != nil || !pendingJob {
+			return err
+		}
+
+		// 入队后额度可能已被其他请求用掉，扣减前在行锁下重新检查
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, key.ID).Error; err != nil {
+			return err
+		}
+		if key.Status != "active" || key.QuotaTotal-key.QuotaUsed < cost {
+			return emailJobFailure("License Key 剩余额度不足")
+		}
+		metadata := fmt.Sprintf(`{"method":%q,"fresh":%d,"cached":%d,"job_id":%d}`, input.Method, len(pending), cachedCount, job.ID)
+		if err := chargeQuota(tx, key, cost, "email_verify", metadata); err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if err := tx.Model(&models.Email{}).
+				Where("user_id = ? AND main = ?", job.UserID, result.Email).
+				Update("status", result.Status).Error; err != nil {
+				return err
+			}
+		}
+
+		return finishEmailJob(tx, job.ID, emailJobDone, gin.H{
+			"results": results,
+			"total":   len(results),
+			"cached":  cachedCount,
+			"method":  input.Method,
+		}, "")
+	})
+}
+
+// chargeQuota 扣减 License Key 额度并记录流水，额度用尽时标记为 exhausted
+func chargeQuota(tx *gorm.DB, key models.LicenseKey, cost int, reason, metadata string) error {
+	if cost > 0 {
+		if err := tx.Model(&models.LicenseKey{}).
+			Where("id = ?", key.ID).
+			UpdateColumn("quota_used", gorm.Expr("quota_used + ?", cost)).
+			Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.LicenseKey{}).
+			Where("id = ? AND quota_used >= quota_total", key.ID).
+			Update("status", "exhausted").Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Create(&models.QuotaLedger{
+		LicenseKeyID: key.ID,
+		UserID:       key.UserID,
+		Amount:       cost,
+		Reason:       reason,
+		Metadata:     metadata,
+	}).Error
+}
+
+type EmailJobResponse struct {
+	ID         uint            `json:"id"`
+	Type       string          `json:"type"`
+	Status     string          `json:"status"`
+	Total      int             `json:"total"`
+	Error      string          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+}
+
+// GetEmailJob 查询导入或验证任务的状态，完成后 result 与原同步接口的响应相同
+func (h *EmailHandler) GetEmailJob(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var job models.EmailJob
+	if err := h.db.Where("id = ? AND user_id = ?", id, userIDValue.(uint)).First(&job).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	response := EmailJobResponse{
+		ID:         job.ID,
+		Type:       job.Type,
+		Status:     job.Status,
+		Total:      job.Total,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Result != "" {
+		response.Result = json.RawMessage(job.Result)
+	}
+
+	// 重试次数用尽进入死信的任务不会再执行，按失败返回
+	if job.Status == emailJobQueued || job.Status == emailJobRunning {
+		var queued models.QueueJob
+		if err := h.db.Select("status", "last_error").First(&queued, job.QueueJobID).Error; err == nil && queued.Status == queue.StatusDead {
+			response.Status = emailJobFailed
+			response.Error = queued.LastError
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
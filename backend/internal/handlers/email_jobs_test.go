@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// enqueueTestImport 入队一个导入任务，测试结束时连同导入的邮箱和队列任务一起删除
+func enqueueTestImport(t *testing.T, db *gorm.DB, h *EmailHandler, userID uint, mains ...string) models.EmailJob {
+	t.Helper()
+	input := importJobInput{Name: "job test"}
+	for _, main := range mains {
+		input.Emails = append(input.Emails, ImportEmailInput{Main: main, Password: "x"})
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := models.EmailJob{UserID: userID, Type: "import", Input: string(data), Total: len(mains)}
+	if err := h.enqueueEmailJob(&job); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("id = ?", job.QueueJobID).Delete(&models.QueueJob{})
+		db.Delete(&job)
+		db.Unscoped().Where("user_id = ? AND main IN ?", userID, mains).Delete(&models.Email{})
+		db.Where("user_id = ?", userID).Delete(&models.EmailImport{})
+	})
+	return job
+}
+
+func runTestEmailJob(t *testing.T, db *gorm.DB, h *EmailHandler, job models.EmailJob) models.EmailJob {
+	t.Helper()
+	payload, _ := json.Marshal(emailJobPayload{JobID: job.ID})
+	if err := h.ProcessEmailJob(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&job, job.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestImportJobLifecycle(t *testing.T) {
+	db := openTestDB(t)
+	h := NewEmailHandler(db, &config.Config{})
+	user := createTestUser(t, db)
+	suffix := uniqueSuffix()
+	mains := []string{"a-" + suffix + "@example.com", "b-" + suffix + "@example.com"}
+
+	job := enqueueTestImport(t, db, h, user.ID, mains...)
+	if job.Status != emailJobQueued || job.QueueJobID == 0 {
+		t.Fatalf("enqueued job = %+v", job)
+	}
+
+	job = runTestEmailJob(t, db, h, job)
+	if job.Status != emailJobDone || job.Input != "" || job.FinishedAt == nil {
+		t.Fatalf("job = %+v, want done with input cleared", job)
+	}
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	if err := json.Unmarshal([]byte(job.Result), &result); err != nil || result.Imported != 2 {
+		t.Fatalf("result = %s", job.Result)
+	}
+
+	// 队列重投已完成的任务不会重复导入
+	runTestEmailJob(t, db, h, job)
+	var count int64
+	db.Model(&models.Email{}).Where("user_id = ? AND main IN ?", user.ID, mains).Count(&count)
+	if count != 2 {
+		t.Errorf("imported emails = %d, want 2", count)
+	}
+}
+
+func TestImportJobFailsOnDuplicateEmail(t *testing.T) {
+	db := openTestDB(t)
+	h := NewEmailHandler(db, &config.Config{})
+	user := createTestUser(t, db)
+	suffix := uniqueSuffix()
+	existing := "dup-" + suffix + "@example.com"
+
+	runTestEmailJob(t, db, h, enqueueTestImport(t, db, h, user.ID, existing))
+
+	// 重复邮箱是终态失败，不交给队列重试，也不导入同批的其他邮箱
+	fresh := "new-" + suffix + "@example.com"
+	job := runTestEmailJob(t, db, h, enqueueTestImport(t, db, h, user.ID, fresh, existing))
+	if job.Status != emailJobFailed || job.Error != "Email already exists: "+existing {
+		t.Fatalf("job = %+v, want failed on the duplicate", job)
+	}
+	var count int64
+	db.Model(&models.Email{}).Where("user_id = ? AND main = ?", user.ID, fresh).Count(&count)
+	if count != 0 {
+		t.Errorf("emails from the failed batch were imported: %d", count)
+	}
+}
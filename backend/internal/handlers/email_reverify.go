@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/queue"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reverifyBatchLimit 单次执行最多重新验证的邮箱数量
+const reverifyBatchLimit = 500
+
+// errReverifyQuota 扣减时 License Key 已停用或剩余额度不足
+var errReverifyQuota = errors.New("insufficient quota")
+
+var reverifyStatusFilters = map[string]bool{
+	"":        true,
+	"live":    true,
+	"verify":  true,
+	"dead":    true,
+	"unknown": true,
+}
+
+type ReverifyScheduleRequest struct {
+	Name          string `json:"name" binding:"required"`
+	ImportID      *uint  `json:"import_id"`
+	StatusFilter  string `json:"status_filter"`
+	IntervalHours int    `json:"interval_hours" binding:"required,min=1"`
+	Method        string `json:"method"`
+	APIKey        string `json:"api_key"`
+	LicenseKey    string `json:"license_key" binding:"required"`
+}
+
+type UpdateReverifyScheduleRequest struct {
+	Name          string  `json:"name"`
+	StatusFilter  *string `json:"status_filter"`
+	IntervalHours *int    `json:"interval_hours"`
+	Method        string  `json:"method"`
+	APIKey        string  `json:"api_key"`
+	LicenseKey    string  `json:"license_key"`
+	Enabled       *bool   `json:"enabled"`
+}
+
+// findReverifyLicenseKey 查找用户可用于重新验证的 License Key
+func (h *EmailHandler) findReverifyLicenseKey(userID uint, keyCode string) (*models.LicenseKey, int, string) {
+	var key models.LicenseKey
+	if err := h.db.Where("key_code = ? AND user_id = ?", keyCode, userID).First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, http.StatusBadRequest, "License Key not found"
+		}
+		return nil, http.StatusInternalServerError, "Failed to fetch license key"
+	}
+	if key.Status != "active" {
+		return nil, http.StatusBadRequest, "License Key is not active"
+	}
+	allowed, err := catalog.HasFeature(h.db, &key, "email_verify")
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to fetch license key"
+	}
+	if !allowed {
+		return nil, http.StatusBadRequest, "License Key does not support email verification"
+	}
+	return &key, 0, ""
+}
+
+func (h *EmailHandler) CreateReverifySchedule(c *gin.Context) {
+	var req ReverifyScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID := userIDValue.(uint)
+
+	if !reverifyStatusFilters[req.StatusFilter] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status filter"})
+		return
+	}
+	if req.Method == "" {
+		req.Method = "smtp"
+	}
+	if req.Method != "smtp" && req.Method != "api" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid method. Use 'smtp' or 'api'"})
+		return
+	}
+	if req.Method == "api" && req.APIKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_key is required for API method"})
+		return
+	}
+
+	if req.ImportID != nil {
+		var importRecord models.EmailImport
+		if err := h.db.Where("id = ? AND user_id = ?", *req.ImportID, userID).First(&importRecord).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Import not found"})
+			return
+		}
+	}
+
+	key, status, msg := h.findReverifyLicenseKey(userID, req.LicenseKey)
+	if key == nil {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	apiKey, err := h.secrets.Seal(req.APIKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+
+	schedule := models.ReverifySchedule{
+		UserID:        userID,
+		Name:          req.Name,
+		ImportID:      req.ImportID,
+		StatusFilter:  req.StatusFilter,
+		IntervalHours: req.IntervalHours,
+		Method:        req.Method,
+		APIKey:        apiKey,
+		LicenseKeyID:  key.ID,
+		Enabled:       true,
+		NextRunAt:     time.Now(),
+	}
+	if err := h.db.Create(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func (h *EmailHandler) GetReverifySchedules(c *gin.Context) {
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var schedules []models.ReverifySchedule
+	if err := h.db.Where("user_id = ?", userIDValue).Order("id asc").Find(&schedules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+func (h *EmailHandler) UpdateReverifySchedule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	var req UpdateReverifyScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userID := userIDValue.(uint)
+
+	var schedule models.ReverifySchedule
+	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&schedule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule"})
+		return
+	}
+
+	if req.Name != "" {
+		schedule.Name = req.Name
+	}
+	if req.StatusFilter != nil {
+		if !reverifyStatusFilters[*req.StatusFilter] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status filter"})
+			return
+		}
+		schedule.StatusFilter = *req.StatusFilter
+	}
+	if req.IntervalHours != nil {
+		if *req.IntervalHours < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interval_hours must be at least 1"})
+			return
+		}
+		schedule.IntervalHours = *req.IntervalHours
+	}
+	if req.Method != "" {
+		if req.Method != "smtp" && req.Method != "api" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid method. Use 'smtp' or 'api'"})
+			return
+		}
+		schedule.Method = req.Method
+	}
+	if req.APIKey != "" {
+		sealed, err := h.secrets.Seal(req.APIKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+			return
+		}
+		schedule.APIKey = sealed
+	}
+	if schedule.Method == "api" && schedule.APIKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_key is required for API method"})
+		return
+	}
+	if req.LicenseKey != "" {
+		key, status, msg := h.findReverifyLicenseKey(userID, req.LicenseKey)
+		if key == nil {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		schedule.LicenseKeyID = key.ID
+	}
+	if req.Enabled != nil {
+		if *req.Enabled && !schedule.Enabled {
+			schedule.NextRunAt = time.Now()
+		}
+		schedule.Enabled = *req.Enabled
+	}
+
+	if err := h.db.Save(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (h *EmailHandler) DeleteReverifySchedule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := h.db.Where("id = ? AND user_id = ?", id, userIDValue).Delete(&models.ReverifySchedule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+func (h *EmailHandler) GetReverifyRuns(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	userIDValue, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var runs []models.ReverifyRun
+	if err := h.db.Where("schedule_id = ? AND user_id = ?", id, userIDValue).
+		Order("started_at desc").
+		Limit(50).
+		Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// RunDueReverifySchedules 执行所有到期的重新验证计划（由后台任务调用）
+func (h *EmailHandler) RunDueReverifySchedules(ctx context.Context) error {
+	var schedules []models.ReverifySchedule
+	if err := h.db.Where("enabled = ? AND next_run_at <= ?", true, time.Now()).
+		Order("next_run_at asc").
+		Find(&schedules).Error; err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// 先推进 next_run_at 以认领计划，避免多个实例重复执行
+		next := time.Now().Add(time.Duration(schedule.IntervalHours) * time.Hour)
+		claim := h.db.Model(&models.ReverifySchedule{}).
+			Where("id = ? AND next_run_at = ?", schedule.ID, schedule.NextRunAt).
+			Update("next_run_at", next)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		// SMTP 验证耗时较长，交给队列工作进程执行
+		if _, err := queue.Enqueue(h.db, JobTypeEmailReverify, reverifyJobPayload{ScheduleID: schedule.ID}, queue.EnqueueOptions{
+			MaxAttempts: 3,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type reverifyJobPayload struct {
+	ScheduleID uint `json:"schedule_id"`
+}
+
+// ProcessReverifyJob 队列任务：执行一次重新验证计划
+func (h *EmailHandler) ProcessReverifyJob(ctx context.Context, payload []byte) error {
+	var p reverifyJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var schedule models.ReverifySchedule
+	if err := h.db.Where("id = ?", p.ScheduleID).First(&schedule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// 计划已删除，无需执行
+			return nil
+		}
+		return err
+	}
+
+	run := h.runReverifySchedule(ctx, schedule)
+	if run.Status == "failed" {
+		return fmt.Errorf("reverify schedule %d failed: %s", schedule.ID, run.Error)
+	}
+	return nil
+}
+
+// runReverifySchedule 执行单个计划并记录结果
+func (h *EmailHandler) runReverifySchedule(ctx context.Context, schedule models.ReverifySchedule) models.ReverifyRun {
+	run := models.ReverifyRun{
+		ScheduleID: schedule.ID,
+		UserID:     schedule.UserID,
+		Status:     "success",
+		StartedAt:  time.Now(),
+	}
+	defer func() {
+		finished := time.Now()
+		run.FinishedAt = &finished
+		if err := h.db.Create(&run).Error; err != nil {
+			log.Printf("reverify: failed to record run for schedule %d: %v", schedule.ID, err)
+		}
+		if err := h.db.Model(&models.ReverifySchedule{}).Where("id = ?", schedule.ID).
+			Update("last_run_at", run.StartedAt).Error; err != nil {
+			log.Printf("reverify: failed to update last run of schedule %d: %v", schedule.ID, err)
+		}
+	}()
+
+	var key models.LicenseKey
+	if err := h.db.Where("id = ? AND user_id = ?", schedule.LicenseKeyID, schedule.UserID).First(&key).Error; err != nil {
+		run.Status = "skipped"
+		run.Error = "license key not found"
+		return run
+	}
+	remaining := key.QuotaTotal - key.QuotaUsed
+	if key.Status != "active" || remaining <= 0 {
+		run.Status = "skipped"
+		run.Error = "license key is not active or has no remaining quota"
+		return run
+	}
+
+	// 每个地址最多消耗 max(1, cachedHitCost) 额度，按剩余额度限制本次数量
+	perEmail := 1
+	if h.cachedHitCost > perEmail {
+		perEmail = h.cachedHitCost
+	}
+	limit := remaining / perEmail
+	if limit > reverifyBatchLimit {
+		limit = reverifyBatchLimit
+	}
+	if limit == 0 {
+		run.Status = "skipped"
+		run.Error = "insufficient quota"
+		return run
+	}
+
+	// 最久未更新的邮箱优先
+	var emails []models.Email
+	query := h.db.Where("user_id = ?", schedule.UserID).Order("updated_at asc").Limit(limit)
+	if schedule.ImportID != nil {
+		query = query.Where("import_id = ?", *schedule.ImportID)
+	}
+	if schedule.StatusFilter != "" {
+		query = query.Where("status = ?", schedule.StatusFilter)
+	}
+	if err := query.Find(&emails).Error; err != nil {
+		run.Status = "failed"
+		run.Error = "failed to fetch emails"
+		return run
+	}
+	if len(emails) == 0 {
+		return run
+	}
+
+	addresses := make([]string, 0, len(emails))
+	for _, email := range emails {
+		addresses = append(addresses, email.Main)
+	}
+
+	results, pending := h.splitCachedResults(addresses, schedule.Method, h.verifyCacheMaxAge)
+	cachedCount := len(results)
+	apiKey, err := h.secrets.Open(schedule.APIKey)
+	if err != nil {
+		run.Status = "failed"
+		run.Error = "stored api key cannot be decrypted, update the schedule with a new key"
+		return run
+	}
+	fresh, err := h.verifyFresh(ctx, pending, schedule.Method, apiKey)
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+		return run
+	}
+	results = append(results, fresh...)
+
+	statusByAddress := make(map[string]string, len(results))
+	for _, result := range results {
+		statusByAddress[result.Email] = result.Status
+	}
+
+	cost := len(pending) + cachedCount*h.cachedHitCost
+	checked, changed := 0, 0
+	transitions := make(map[string]int)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// 开始时读取的额度可能已被并发的验证或同一 Key 的其他计划用掉，扣减前在行锁下重新检查
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, key.ID).Error; err != nil {
+			return err
+		}
+		if key.Status != "active" || key.QuotaTotal-key.QuotaUsed < cost {
+			return errReverifyQuota
+		}
+		metadata := fmt.Sprintf(`{"schedule_id":%d,"fresh":%d,"cached":%d}`, schedule.ID, len(pending), cachedCount)
+		if err := chargeQuota(tx, key, cost, "email_reverify", metadata); err != nil {
+			return err
+		}
+
+		for _, email := range emails {
+			status, ok := statusByAddress[email.Main]
+			if !ok {
+				continue
+			}
+			checked++
+			if status != email.Status {
+				changed++
+				transitions[email.Status+"->"+status]++
+			}
+			// 即使状态未变化也刷新 updated_at，便于下次按最久未验证排序
+			if err := tx.Model(&models.Email{}).Where("id = ?", email.ID).Update("status", status).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == errReverifyQuota {
+		run.Status = "skipped"
+		run.Error = "insufficient quota"
+		return run
+	}
+	if err != nil {
+		run.Status = "failed"
+		run.Error = "failed to record results: " + err.Error()
+		return run
+	}
+	run.Checked = checked
+	run.Changed = changed
+	run.QuotaUsed = cost
+
+	if summary, err := json.Marshal(transitions); err == nil {
+		run.Summary = string(summary)
+	}
+	return run
+}
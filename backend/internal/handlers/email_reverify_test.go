@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// setupReverify 创建一个计划和两个邮箱，两个地址都已有 smtp 缓存结果，执行时不会发起实时验证
+func setupReverify(t *testing.T, db *gorm.DB, quota int) (*EmailHandler, models.ReverifySchedule, models.LicenseKey) {
+	t.Helper()
+	h := NewEmailHandler(db, &config.Config{
+		DataEncryptionKey:   "test-data-encryption-key-0123456789",
+		VerifyCacheMaxAge:   time.Hour,
+		VerifyCachedHitCost: 1,
+	})
+	user := createTestUser(t, db)
+	key := createTestLicenseKey(t, db, user.ID, quota)
+
+	batch := models.EmailImport{UserID: user.ID, Name: "reverify test"}
+	if err := db.Create(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+	suffix := uniqueSuffix()
+	emails := []models.Email{
+		{UserID: user.ID, ImportID: batch.ID, Main: "live-" + suffix + "@example.com", Password: "x", Status: "unknown"},
+		{UserID: user.ID, ImportID: batch.ID, Main: "dead-" + suffix + "@example.com", Password: "x", Status: "live"},
+	}
+	if err := db.Create(&emails).Error; err != nil {
+		t.Fatal(err)
+	}
+	h.storeVerificationResults([]VerifyEmailResponse{
+		{Email: emails[0].Main, Status: "live"},
+		{Email: emails[1].Main, Status: "dead"},
+	}, "smtp")
+
+	schedule := models.ReverifySchedule{
+		UserID: user.ID, Name: "test", ImportID: &batch.ID, IntervalHours: 24,
+		Method: "smtp", LicenseKeyID: key.ID, Enabled: true, NextRunAt: time.Now(),
+	}
+	if err := db.Create(&schedule).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("schedule_id = ?", schedule.ID).Delete(&models.ReverifyRun{})
+		db.Unscoped().Delete(&schedule)
+		db.Where("address IN ?", []string{emails[0].Main, emails[1].Main}).Delete(&models.VerificationResult{})
+		db.Unscoped().Where("import_id = ?", batch.ID).Delete(&models.Email{})
+		db.Delete(&batch)
+	})
+	return h, schedule, key
+}
+
+func emailStatuses(t *testing.T, db *gorm.DB, schedule models.ReverifySchedule) map[string]int {
+	t.Helper()
+	var emails []models.Email
+	if err := db.Where("import_id = ?", *schedule.ImportID).Find(&emails).Error; err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, email := range emails {
+		counts[email.Status]++
+	}
+	return counts
+}
+
+func TestReverifyRunChargesQuota(t *testing.T) {
+	db := openTestDB(t)
+	h, schedule, key := setupReverify(t, db, 10)
+
+	run := h.runReverifySchedule(context.Background(), schedule)
+	if run.Status != "success" || run.Checked != 2 || run.Changed != 2 || run.QuotaUsed != 2 {
+		t.Fatalf("run = %+v", run)
+	}
+
+	if err := db.First(&key, key.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if key.QuotaUsed != 2 {
+		t.Errorf("quota_used = %d, want 2", key.QuotaUsed)
+	}
+	if got := emailStatuses(t, db, schedule); got["live"] != 1 || got["dead"] != 1 {
+		t.Errorf("email statuses = %v", got)
+	}
+
+	var runs, ledger int64
+	db.Model(&models.ReverifyRun{}).Where("schedule_id = ? AND status = ?", schedule.ID, "success").Count(&runs)
+	db.Model(&models.QuotaLedger{}).Where("license_key_id = ? AND reason = ?", key.ID, "email_reverify").Count(&ledger)
+	if runs != 1 || ledger != 1 {
+		t.Errorf("runs = %d, ledger entries = %d, want 1 and 1", runs, ledger)
+	}
+}
+
+func TestReverifyRunRechecksQuotaUnderLock(t *testing.T) {
+	db := openTestDB(t)
+	h, schedule, key := setupReverify(t, db, 10)
+
+	// 持有 Key 的行锁时另一个请求用光了额度，计划在扣减前重新检查，不会超额扣减
+	tx := db.Begin()
+	if err := tx.Exec("SELECT id FROM license_keys WHERE id = ? FOR UPDATE", key.ID).Error; err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	done := make(chan models.ReverifyRun)
+	go func() { done <- h.runReverifySchedule(context.Background(), schedule) }()
+	time.Sleep(200 * time.Millisecond)
+	if err := tx.Model(&models.LicenseKey{}).Where("id = ?", key.ID).Update("quota_used", 10).Error; err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+
+	run := <-done
+	if run.Status != "skipped" || run.QuotaUsed != 0 {
+		t.Fatalf("run = %+v, want skipped without charge", run)
+	}
+	if err := db.First(&key, key.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if key.QuotaUsed != 10 {
+		t.Errorf("quota_used = %d, want 10", key.QuotaUsed)
+	}
+	if got := emailStatuses(t, db, schedule); got["unknown"] != 1 || got["live"] != 1 {
+		t.Errorf("email statuses changed without a charge: %v", got)
+	}
+}
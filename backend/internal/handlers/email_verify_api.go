@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"fullstack-backend/internal/config"
+)
+
+// maxAPIResponseSize 第三方 API 响应体的最大字节数
+const maxAPIResponseSize = 1 << 20
+
+// ErrCircuitOpen 第三方 API 连续失败后熔断，直接快速失败
+var ErrCircuitOpen = errors.New("verification provider is unavailable, please retry later")
+
+// APIVerifier 调用第三方验证 API 的客户端，带超时、重试和熔断
+type APIVerifier struct {
+	url        string
+	client     *http.Client
+	maxRetries int
+	baseDelay  time.Duration
+	breaker    *circuitBreaker
+}
+
+// NewAPIVerifier 根据配置创建第三方 API 验证器
+func NewAPIVerifier(cfg *config.Config) *APIVerifier {
+	return &APIVerifier{
+		url:        cfg.VerifyAPIURL,
+		client:     &http.Client{Timeout: cfg.VerifyAPITimeout},
+		maxRetries: cfg.VerifyAPIMaxRetries,
+		baseDelay:  500 * time.Millisecond,
+		breaker:    newCircuitBreaker(cfg.VerifyAPIBreakerThreshold, cfg.VerifyAPIBreakerCooldown),
+	}
+}
+
+// retryableError 表示可以重试的临时错误（网络错误、5xx、429）
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Verify 批量验证邮箱，返回规范化后的状态
+func (v *APIVerifier) Verify(ctx context.Context, emails []string, key string) ([]VerifyEmailResponse, error) {
+	if !v.breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"mail":      emails,
+		"key":       key,
+		"fastCheck": false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request")
+	}
+
+	var data map[string]interface{}
+	for attempt := 0; ; attempt++ {
+		data, err = v.post(ctx, payloadBytes)
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= v.maxRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			v.breaker.Cancel()
+			return nil, ctx.Err()
+		case <-time.After(v.backoff(attempt)):
+		}
+	}
+
+	var retryable *retryableError
+	// 只有上游不可用才计入熔断，业务错误（如 key 无效）不算
+	v.breaker.Record(err == nil || !errors.As(err, &retryable))
+	if err != nil {
+		return nil, err
+	}
+
+	// 从 data 字段中提取邮箱状态，没有返回结果的地址标记为 unknown
+	statuses := make(map[string]string, len(data))
+	for email, statusInterface := range data {
+		status, _ := statusInterface.(string)
+		statuses[normalizeEmailAddress(email)] = mapProviderStatus(status)
+	}
+
+	results := make([]VerifyEmailResponse, 0, len(emails))
+	for _, email := range emails {
+		status, ok := statuses[normalizeEmailAddress(email)]
+		result := VerifyEmailResponse{Email: email, Status: status}
+		if !ok {
+			result.Status = "unknown"
+			result.Error = "no result returned by provider"
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// post 发送一次请求并解析响应
+func (v *APIVerifier) post(ctx context.Context, payload []byte) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("failed to verify emails: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	// 限制读取的响应大小，防止异常响应占满内存
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseSize+1))
+	if err != nil {
+		return nil, &retryableError{fmt.Errorf("failed to read response")}
+	}
+	if len(body) > maxAPIResponseSize {
+		return nil, fmt.Errorf("third-party API response too large")
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, &retryableError{fmt.Errorf("third-party API returned HTTP %d", resp.StatusCode)}
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("third-party API returned HTTP %d", resp.StatusCode)
+	}
+
+	// 解析响应 - 第三方 API 返回格式：{"data": {"email": "status", ...}}
+	var apiResponse struct {
+		Message      string                 `json:"message"`
+		Data         map[string]interface{} `json:"data"`
+		ResponseTime string                 `json:"responseTime"`
+		Status       string                 `json:"status"`
+	}
+	if err := json.Unmarshal(body, &apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %s", truncate(string(body), 200))
+	}
+
+	// 检查 API 是否返回错误
+	if apiResponse.Status == "error" || apiResponse.Data == nil {
+		return nil, fmt.Errorf("third-party API returned error: %s", apiResponse.Message)
+	}
+
+	return apiResponse.Data, nil
+}
+
+// backoff 指数退避加随机抖动
+func (v *APIVerifier) backoff(attempt int) time.Duration {
+	delay := v.baseDelay << attempt
+	return delay + time.Duration(rand.Int63n(int64(v.baseDelay)))
+}
+
+// mapProviderStatus 将第三方状态映射为系统统一状态：live, verify, dead, unknown
+func mapProviderStatus(status string) string {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "live", "valid", "ok", "active":
+		return "live"
+	case "verify", "verification", "need_verify", "needverify", "phone", "locked":
+		return "verify"
+	case "dead", "die", "disabled", "invalid", "not_exist", "notexist", "banned":
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// circuitBreaker 简单的熔断器：连续失败达到阈值后打开，冷却后放行一次探测请求
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow 判断当前是否允许发起请求
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	// 熔断打开：冷却期内拒绝，冷却后只放行一个探测请求
+	if time.Since(b.openedAt) < b.cooldown || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Cancel 请求被调用方取消，不计入成功或失败
+func (b *circuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Record 记录一次请求结果
+func (b *circuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
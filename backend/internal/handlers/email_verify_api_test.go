@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+	b := newCircuitBreaker(2, cooldown)
+
+	b.Record(false)
+	if !b.Allow() {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	b.Record(false)
+	if b.Allow() {
+		t.Fatal("breaker still closed after reaching the threshold")
+	}
+
+	// 冷却后只放行一个探测请求
+	time.Sleep(cooldown)
+	if !b.Allow() {
+		t.Fatal("probe not allowed after cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("second request allowed while probing")
+	}
+
+	// 探测失败重新开始冷却
+	b.Record(false)
+	if b.Allow() {
+		t.Fatal("breaker closed after a failed probe")
+	}
+
+	// 探测被取消不计入结果，冷却后可以再次探测
+	time.Sleep(cooldown)
+	if !b.Allow() {
+		t.Fatal("probe not allowed after second cooldown")
+	}
+	b.Cancel()
+	if !b.Allow() {
+		t.Fatal("probe not allowed after a canceled probe")
+	}
+
+	// 探测成功后关闭
+	b.Record(true)
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("breaker not closed after a successful probe")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(0, time.Hour)
+	for i := 0; i < 10; i++ {
+		b.Record(false)
+	}
+	if !b.Allow() {
+		t.Fatal("breaker with threshold 0 must never open")
+	}
+}
+
+func TestMapProviderStatus(t *testing.T) {
+	tests := map[string]string{
+		"live": "live", " Valid ": "live", "OK": "live",
+		"verify": "verify", "phone": "verify", "locked": "verify",
+		"dead": "dead", "die": "dead", "not_exist": "dead", "banned": "dead",
+		"": "unknown", "pending": "unknown",
+	}
+	for status, want := range tests {
+		if got := mapProviderStatus(status); got != want {
+			t.Errorf("mapProviderStatus(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+// newTestAPIVerifier 指向测试服务器，重试间隔缩短到毫秒级
+func newTestAPIVerifier(url string, maxRetries, threshold int) *APIVerifier {
+	return &APIVerifier{
+		url:        url,
+		client:     &http.Client{Timeout: time.Second},
+		maxRetries: maxRetries,
+		baseDelay:  time.Millisecond,
+		breaker:    newCircuitBreaker(threshold, time.Hour),
+	}
+}
+
+// scriptedServer 依次返回给定的状态码，之后一直返回最后一个；200 时返回 body
+func scriptedServer(t *testing.T, codes []int, body string) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(codes) {
+			n = len(codes) - 1
+		}
+		if codes[n] != http.StatusOK {
+			w.WriteHeader(codes[n])
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestAPIVerifierRetries(t *testing.T) {
+	const body = `{"status":"success","data":{"A@x.com":"valid","b@x.com":"die"}}`
+
+	tests := []struct {
+		name       string
+		codes      []int
+		maxRetries int
+		wantErr    bool
+		wantCalls  int32
+	}{
+		{"success", []int{200}, 2, false, 1},
+		{"retry 5xx then succeed", []int{503, 500, 200}, 2, false, 3},
+		{"retry 429", []int{429, 200}, 2, false, 2},
+		{"give up after max retries", []int{503}, 2, true, 3},
+		{"client errors are not retried", []int{401}, 2, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := scriptedServer(t, tt.codes, body)
+			v := newTestAPIVerifier(server.URL, tt.maxRetries, 5)
+
+			results, err := v.Verify(context.Background(), []string{"a@x.com", "b@x.com", "c@x.com"}, "key")
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 按请求顺序返回，地址匹配不区分大小写，缺失的地址为 unknown
+			want := []string{"live", "dead", "unknown"}
+			for i, result := range results {
+				if result.Status != want[i] {
+					t.Errorf("results[%d] = %+v, want status %s", i, result, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAPIVerifierOpensBreaker(t *testing.T) {
+	server, calls := scriptedServer(t, []int{500}, "")
+	v := newTestAPIVerifier(server.URL, 0, 2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := v.Verify(context.Background(), []string{"a@x.com"}, "key"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("attempt %d: err = %v, want upstream error", i, err)
+		}
+	}
+	if _, err := v.Verify(context.Background(), []string{"a@x.com"}, "key"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Fatalf("calls = %d, want 2: an open breaker must not reach the provider", got)
+	}
+}
+
+func TestAPIVerifierBusinessErrorsDoNotOpenBreaker(t *testing.T) {
+	server, _ := scriptedServer(t, []int{200}, `{"status":"error","message":"invalid key"}`)
+	v := newTestAPIVerifier(server.URL, 0, 1)
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), []string{"a@x.com"}, "key"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("attempt %d: err = %v, want provider error", i, err)
+		}
+	}
+}
+
+func TestAPIVerifierCanceledDuringBackoff(t *testing.T) {
+	server, _ := scriptedServer(t, []int{503}, "")
+	v := newTestAPIVerifier(server.URL, 5, 1)
+	v.baseDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := v.Verify(ctx, []string{"a@x.com"}, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context deadline", err)
+	}
+	// 取消的请求不计入熔断
+	if !v.breaker.Allow() {
+		t.Fatal("canceled request opened the breaker")
+	}
+}
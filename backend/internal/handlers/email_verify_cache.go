@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// cacheableStatuses 只有确定性的验证结果才写入共享缓存
+var cacheableStatuses = map[string]bool{
+	"live":   true,
+	"verify": true,
+	"dead":   true,
+}
+
+// normalizeEmailAddress 规范化邮箱地址，作为缓存键
+func normalizeEmailAddress(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// lookupCachedResults 查询在 maxAge 内用同一验证方式验证过的地址，返回以规范化地址为键的结果。
+// SMTP 和第三方 API 的判定标准不同，结果不互相复用
+func (h *EmailHandler) lookupCachedResults(emails []string, method string, maxAge time.Duration) map[string]models.VerificationResult {
+	cached := make(map[string]models.VerificationResult)
+	if maxAge <= 0 || len(emails) == 0 {
+		return cached
+	}
+
+	addresses := make([]string, 0, len(emails))
+	for _, email := range emails {
+		addresses = append(addresses, normalizeEmailAddress(email))
+	}
+
+	var rows []models.VerificationResult
+	if err := h.db.Where("address IN ? AND method = ? AND checked_at > ?", addresses, method, time.Now().Add(-maxAge)).
+		Find(&rows).Error; err != nil {
+		// 缓存不可用时退化为实时验证
+		log.Printf("verify cache: failed to look up %d addresses: %v", len(addresses), err)
+		return cached
+	}
+
+	for _, row := range rows {
+		cached[row.Address] = row
+	}
+	return cached
+}
+
+// splitCachedResults 将地址分为命中缓存的结果和需要实时验证的地址
+func (h *EmailHandler) splitCachedResults(emails []string, method string, maxAge time.Duration) ([]VerifyEmailResponse, []string) {
+	cached := h.lookupCachedResults(emails, method, maxAge)
+	results := make([]VerifyEmailResponse, 0, len(emails))
+	pending := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if hit, ok := cached[normalizeEmailAddress(email)]; ok {
+			checkedAt := hit.CheckedAt
+			results = append(results, VerifyEmailResponse{
+				Email:     email,
+				Status:    hit.Status,
+				Error:     hit.Error,
+				Cached:    true,
+				CheckedAt: &checkedAt,
+			})
+			continue
+		}
+		pending = append(pending, email)
+	}
+	return results, pending
+}
+
+// storeVerificationResults 将新的验证结果写入共享缓存
+func (h *EmailHandler) storeVerificationResults(results []VerifyEmailResponse, method string) {
+	now := time.Now()
+	for _, result := range results {
+		if !cacheableStatuses[result.Status] {
+			continue
+		}
+
+		entry := models.VerificationResult{
+			Address:   normalizeEmailAddress(result.Email),
+			Status:    result.Status,
+			Method:    method,
+			Error:     result.Error,
+			CheckedAt: now,
+		}
+		// 缓存写入失败不影响本次结果，只是下次需要重新验证
+		if err := h.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "address"}, {Name: "method"}},
+			DoUpdates: clause.AssignmentColumns([]string{"status", "error", "checked_at", "updated_at"}),
+		}).Create(&entry).Error; err != nil {
+			log.Printf("verify cache: failed to store result for %s: %v", entry.Address, err)
+		}
+	}
+}
+
+// orderResults 按请求中的地址顺序合并缓存结果和实时结果，重复的地址按出现次序依次对应
+func orderResults(emails []string, cached, fresh []VerifyEmailResponse) []VerifyEmailResponse {
+	byAddress := make(map[string][]VerifyEmailResponse, len(cached)+len(fresh))
+	for _, group := range [][]VerifyEmailResponse{cached, fresh} {
+		for _, result := range group {
+			address := normalizeEmailAddress(result.Email)
+			byAddress[address] = append(byAddress[address], result)
+		}
+	}
+
+	ordered := make([]VerifyEmailResponse, 0, len(cached)+len(fresh))
+	for _, email := range emails {
+		address := normalizeEmailAddress(email)
+		if queued := byAddress[address]; len(queued) > 0 {
+			ordered = append(ordered, queued[0])
+			byAddress[address] = queued[1:]
+		}
+	}
+	// 验证服务返回了请求之外的地址时保留在末尾
+	for _, group := range [][]VerifyEmailResponse{cached, fresh} {
+		for _, result := range group {
+			address := normalizeEmailAddress(result.Email)
+			if queued := byAddress[address]; len(queued) > 0 {
+				ordered = append(ordered, queued[0])
+				byAddress[address] = queued[1:]
+			}
+		}
+	}
+	return ordered
+}
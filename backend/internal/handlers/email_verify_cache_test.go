@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/models"
+)
+
+func TestOrderResults(t *testing.T) {
+	result := func(email, status string, cached bool) VerifyEmailResponse {
+		return VerifyEmailResponse{Email: email, Status: status, Cached: cached}
+	}
+
+	tests := []struct {
+		name          string
+		emails        []string
+		cached, fresh []VerifyEmailResponse
+		want          []VerifyEmailResponse
+	}{
+		{
+			name:   "interleaved cache hits",
+			emails: []string{"a@x.com", "b@x.com", "c@x.com"},
+			cached: []VerifyEmailResponse{result("b@x.com", "live", true)},
+			fresh:  []VerifyEmailResponse{result("a@x.com", "dead", false), result("c@x.com", "verify", false)},
+			want: []VerifyEmailResponse{
+				result("a@x.com", "dead", false), result("b@x.com", "live", true), result("c@x.com", "verify", false),
+			},
+		},
+		{
+			// 验证服务可能返回规范化后的地址
+			name:   "matched by normalized address",
+			emails: []string{" A@X.com "},
+			fresh:  []VerifyEmailResponse{result("a@x.com", "live", false)},
+			want:   []VerifyEmailResponse{result("a@x.com", "live", false)},
+		},
+		{
+			name:   "duplicates in order of appearance",
+			emails: []string{"a@x.com", "b@x.com", "a@x.com"},
+			cached: []VerifyEmailResponse{result("a@x.com", "live", true)},
+			fresh:  []VerifyEmailResponse{result("b@x.com", "dead", false), result("a@x.com", "verify", false)},
+			want: []VerifyEmailResponse{
+				result("a@x.com", "live", true), result("b@x.com", "dead", false), result("a@x.com", "verify", false),
+			},
+		},
+		{
+			name:   "unrequested results kept at the end",
+			emails: []string{"a@x.com"},
+			fresh:  []VerifyEmailResponse{result("z@x.com", "dead", false), result("a@x.com", "live", false)},
+			want:   []VerifyEmailResponse{result("a@x.com", "live", false), result("z@x.com", "dead", false)},
+		},
+		{
+			name:   "missing result skipped",
+			emails: []string{"a@x.com", "b@x.com"},
+			fresh:  []VerifyEmailResponse{result("b@x.com", "live", false)},
+			want:   []VerifyEmailResponse{result("b@x.com", "live", false)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderResults(tt.emails, tt.cached, tt.fresh); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderResults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerificationCacheIsPerMethod(t *testing.T) {
+	db := openTestDB(t)
+	h := NewEmailHandler(db, &config.Config{DataEncryptionKey: "test-data-encryption-key-0123456789"})
+
+	address := "cache-" + uniqueSuffix() + "@example.com"
+	t.Cleanup(func() { db.Where("address = ?", address).Delete(&models.VerificationResult{}) })
+
+	h.storeVerificationResults([]VerifyEmailResponse{{Email: address, Status: "live"}}, "api")
+	// 不确定的结果不写入缓存
+	h.storeVerificationResults([]VerifyEmailResponse{{Email: address, Status: "unknown"}}, "smtp")
+
+	emails := []string{" " + address}
+	hits, pending := h.splitCachedResults(emails, "api", time.Hour)
+	if len(hits) != 1 || hits[0].Status != "live" || !hits[0].Cached || len(pending) != 0 {
+		t.Fatalf("api lookup: hits %+v, pending %v", hits, pending)
+	}
+	if hits, pending := h.splitCachedResults(emails, "smtp", time.Hour); len(hits) != 0 || len(pending) != 1 {
+		t.Fatalf("smtp lookup reused the api result: hits %+v, pending %v", hits, pending)
+	}
+	if hits, _ := h.splitCachedResults(emails, "api", 0); len(hits) != 0 {
+		t.Fatalf("max age 0 must bypass the cache, got %+v", hits)
+	}
+
+	// 两种方式的结果分别保存，重复写入只更新对应的一条
+	h.storeVerificationResults([]VerifyEmailResponse{{Email: address, Status: "dead"}}, "smtp")
+	h.storeVerificationResults([]VerifyEmailResponse{{Email: address, Status: "verify"}}, "smtp")
+
+	var rows []models.VerificationResult
+	if err := db.Where("address = ?", address).Order("method").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Method != "api" || rows[0].Status != "live" || rows[1].Method != "smtp" || rows[1].Status != "verify" {
+		t.Fatalf("cache rows = %+v", rows)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type JobHandler struct {
+	db *gorm.DB
+}
+
+func NewJobHandler(db *gorm.DB) *JobHandler {
+	return &JobHandler{db: db}
+}
+
+// ListJobs 获取所有后台任务的调度状态
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	var jobs []models.ScheduledJob
+	if err := h.db.Order("name asc").Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询任务失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// ListJobRuns 获取后台任务执行记录，可按任务名和状态筛选
+func (h *JobHandler) ListJobRuns(c *gin.Context) {
+	limit := 50
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须在 1-500 之间"})
+			return
+		}
+		limit = parsed
+	}
+
+	query := h.db.Model(&models.JobRun{}).Order("started_at desc").Limit(limit)
+	if name := c.Query("job"); name != "" {
+		query = query.Where("job_name = ?", name)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var runs []models.JobRun
+	if err := query.Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询执行记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
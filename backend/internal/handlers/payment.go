@@ -1,69 +1,69 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/config"
 	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/payment"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type PaymentHandler struct {
-	db *gorm.DB
-}
-
-func NewPaymentHandler(db *gorm.DB) *PaymentHandler {
-	return &PaymentHandler{db: db}
-}
+// orderTTL 订单创建后等待支付的时长
+const orderTTL = 15 * time.Minute
 
-// ProductConfig 产品配置
-type ProductConfig struct {
-	Type        string `json:"type"`
-	Name        string `json:"name"`
-	Price       int    `json:"price"`        // 价格（分）
-	QuotaAmount int    `json:"quota_amount"` // 次数额度
-	Features    []string `json:"features"`
+type PaymentHandler struct {
+	db            *gorm.DB
+	providers     *payment.Registry
+	invoiceSeller invoiceSeller
 }
 
-var ProductConfigs = []ProductConfig{
-	{
-		Type:        "basic",
-		Name:        "基础版",
-		Price:       1000, // 10元
-		QuotaAmount: 100,
-		Features:    []string{"邮箱验证 100 次", "基础功能"},
-	},
-	{
-		Type:        "pro",
-		Name:        "专业版",
-		Price:       3000, // 30元
-		QuotaAmount: 500,
-		Features:    []string{"邮箱验证 500 次", "所有功能", "优先支持"},
-	},
-	{
-		Type:        "enterprise",
-		Name:        "企业版",
-		Price:       5000, // 50元
-		QuotaAmount: 1000,
-		Features:    []string{"邮箱验证 1000 次", "所有功能", "专属客服", "API 访问"},
-	},
+func NewPaymentHandler(db *gorm.DB, providers *payment.Registry, cfg *config.Config) *PaymentHandler {
+	return &PaymentHandler{
+		db:        db,
+		providers: providers,
+		invoiceSeller: invoiceSeller{
+			Name:    cfg.InvoiceSellerName,
+			TaxID:   cfg.InvoiceSellerTaxID,
+			Address: cfg.InvoiceSellerAddress,
+			TaxRate: cfg.InvoiceTaxRate,
+		},
+	}
 }
 
-// GetProducts 获取产品列表
+// GetProducts 获取产品列表（商品目录中上架的 License 产品的当前版本，订阅套餐见 /subscriptions/plans）
 func (h *PaymentHandler) GetProducts(c *gin.Context) {
+	offers, err := catalog.ActiveOffers(h.db, catalog.KindLicense)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"products": ProductConfigs,
+		"products":        offers,
+		"payment_methods": h.providers.GatewayNames(),
 	})
 }
 
 // CreateOrderRequest 创建订单请求
 type CreateOrderRequest struct {
 	ProductType string `json:"product_type" binding:"required"`
+	AccountID   uint   `json:"account_id"` // 购买独享账号（product_type=exclusive_account）时必填
+	// 可选：同时发起支付，响应中返回支付链接或二维码
+	PaymentMethod string `json:"payment_method"`
+	// 可选：优惠码，折扣在下单时计算并保存在订单上
+	CouponCode string `json:"coupon_code"`
 }
 
 // CreateOrder 创建支付订单
@@ -80,42 +80,140 @@ func (h *PaymentHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	// 查找产品配置
-	var productConfig *ProductConfig
-	for _, p := range ProductConfigs {
-		if p.Type == req.ProductType {
-			productConfig = &p
-			break
+	if req.PaymentMethod != "" {
+		if _, ok := h.providers.Gateway(req.PaymentMethod); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的支付方式"})
+			return
 		}
 	}
-	if productConfig == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的产品类型"})
+
+	if req.ProductType == accountOrderProductType {
+		h.createExclusiveOrder(c, userID.(uint), req.AccountID, req.PaymentMethod, req.CouponCode)
+		return
+	}
+
+	// 查找产品当前版本
+	offer, err := catalog.FindOffer(h.db, req.ProductType)
+	if err != nil {
+		if err == catalog.ErrProductNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的产品类型"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+		}
 		return
 	}
 
+	h.createProductOrder(c, userID.(uint), offer, req.PaymentMethod, req.CouponCode)
+}
+
+// createProductOrder 按商品目录中的产品版本创建订单，License 产品和订阅套餐共用
+func (h *PaymentHandler) createProductOrder(c *gin.Context, userID uint, offer *catalog.Offer, method, couponCode string) {
 	// 生成订单号
 	orderNo := generateOrderNo()
 
 	// 创建订单
 	payment := models.Payment{
-		UserID:      userID.(uint),
-		OrderNo:     orderNo,
-		Amount:      productConfig.Price,
-		ProductType: productConfig.Type,
-		QuotaAmount: productConfig.QuotaAmount,
-		Status:      "pending",
-		ExpiredAt:   time.Now().Add(15 * time.Minute), // 15分钟过期
+		UserID:           userID,
+		OrderNo:          orderNo,
+		Amount:           offer.Price,
+		ProductType:      offer.Type,
+		QuotaAmount:      offer.QuotaAmount,
+		ProductVersionID: &offer.VersionID,
+		Status:           "pending",
+		ExpiredAt:        time.Now().Add(orderTTL),
 	}
 
-	if err := h.db.Create(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建订单失败"})
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		if couponCode != "" {
+			return applyCoupon(tx, &payment, couponCode)
+		}
+		return nil
+	})
+	if err != nil {
+		if !writeCouponError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建订单失败"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"order": payment,
-		"product": productConfig,
+		"product": offer,
+	}
+	h.attachCheckout(c, response, &payment, method)
+	c.JSON(http.StatusOK, response)
+}
+
+// attachCheckout 下单时指定了支付方式则一并发起支付。发起失败不影响订单，
+// 客户端可稍后调用 /payments/orders/:order_no/checkout 重试
+func (h *PaymentHandler) attachCheckout(c *gin.Context, response gin.H, order *models.Payment, method string) {
+	if method == "" {
+		return
+	}
+	checkout, err := h.createCheckout(c.Request.Context(), order, method)
+	if err != nil {
+		log.Printf("payment checkout: order %s: %v", order.OrderNo, err)
+		response["checkout_error"] = "发起支付失败，请稍后重试"
+		return
+	}
+	response["order"] = order
+	response["checkout"] = checkout
+}
+
+// createExclusiveOrder 为独享账号下单，账号在订单有效期内被预留，支付成功后才转移所有权。
+// 这是购买单个独享账号的唯一入口
+func (h *PaymentHandler) createExclusiveOrder(c *gin.Context, userID, accountID uint, method, couponCode string) {
+	if accountID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少账号 ID"})
+		return
+	}
+
+	var (
+		payment *models.Payment
+		items   []models.PaymentItem
+	)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		payment, items, err = createAccountOrder(tx, userID, []uint{accountID})
+		if err != nil || couponCode == "" {
+			return err
+		}
+		return applyCoupon(tx, payment, couponCode)
 	})
+	if err != nil {
+		if !writeCouponError(c, err) {
+			writeAccountOrderError(c, err)
+		}
+		return
+	}
+
+	response := gin.H{
+		"order": payment,
+		"items": items,
+	}
+	h.attachCheckout(c, response, payment, method)
+	c.JSON(http.StatusOK, response)
+}
+
+// writeAccountOrderError 将下单失败原因转换为响应
+func writeAccountOrderError(c *gin.Context, err error) {
+	orderErr, ok := err.(*accountOrderError)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建订单失败"})
+		return
+	}
+
+	switch orderErr.Reason {
+	case "not_found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "账号不存在", "account_id": orderErr.AccountID})
+	case "unpriced":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "账号暂未定价", "account_id": orderErr.AccountID})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "账号不可购买", "account_id": orderErr.AccountID})
+	}
 }
 
 // GetOrder 获取订单详情
@@ -141,99 +239,216 @@ func (h *PaymentHandler) GetOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"order": payment})
 }
 
-// PaymentNotifyRequest 支付回调请求（模拟）
-type PaymentNotifyRequest struct {
-	OrderNo       string `json:"order_no" binding:"required"`
-	TransactionID string `json:"transaction_id" binding:"required"`
-	PaymentMethod string `json:"payment_method" binding:"required"` // alipay, wechat
-}
+var (
+	errOrderNotFound  = errors.New("order not found")
+	errAmountMismatch = errors.New("paid amount does not match order amount")
+	errOrderNotPaid   = errors.New("order not paid")
+)
 
-// PaymentNotify 支付回调（实际应该是支付平台回调）
+// PaymentNotify 支付平台异步通知，路由为 /payments/notify/:provider。
+// 接口不经过用户认证，回调的真实性由支付平台签名保证；校验签名和金额后
+// 才将订单标记为已支付并发放权益。支付平台会重试回调，同一流水号的重复通知
+// 直接返回成功应答，每次回调的原始内容都会记录到 payment_notifications。
 func (h *PaymentHandler) PaymentNotify(c *gin.Context) {
-	var req PaymentNotifyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+	provider, ok := h.providers.Get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "不支持的支付方式"})
+		return
+	}
+
+	record := models.PaymentNotification{Provider: provider.Name(), RemoteIP: c.ClientIP()}
+	respond := func(status int, success bool, result string, err error) {
+		h.saveNotification(&record, result, err)
+		contentType, body := provider.Acknowledge(success)
+		c.Data(status, contentType, body)
+	}
+
+	body, err := payment.ReadBody(c.Request)
+	if err != nil {
+		respond(http.StatusBadRequest, false, "rejected", err)
+		return
+	}
+	record.Raw = string(body)
+
+	notification, err := provider.ParseNotification(c.Request.Header, body)
+	if err != nil {
+		respond(http.StatusBadRequest, false, "rejected", err)
 		return
 	}
 
-	// 开启事务
-	tx := h.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	result, err := h.handleNotification(provider.Name(), notification, &record)
+	switch {
+	case err == nil:
+		respond(http.StatusOK, true, result, nil)
+	case errors.Is(err, errOrderNotFound), errors.Is(err, errAmountMismatch), errors.Is(err, gorm.ErrDuplicatedKey):
+		respond(http.StatusBadRequest, false, "rejected", err)
+	default:
+		respond(http.StatusInternalServerError, false, "failed", err)
+	}
+}
+
+// handleNotification 对已验签或主动查询得到的支付结果去重后应用到订单，返回处理结果
+func (h *PaymentHandler) handleNotification(method string, notification *payment.Notification, record *models.PaymentNotification) (string, error) {
+	record.OrderNo = notification.OrderNo
+	record.TransactionID = notification.TransactionID
+	record.Amount = notification.Amount
+
+	// 等待付款、交易关闭等非成功状态无需处理
+	if !notification.Paid {
+		return "ignored", nil
+	}
+
+	// 已成功处理过的流水号直接确认，无需再锁订单
+	var processed int64
+	if err := h.db.Model(&models.PaymentNotification{}).
+		Where("provider = ? AND transaction_id = ? AND result = ?", method, notification.TransactionID, "processed").
+		Count(&processed).Error; err != nil {
+		return "", err
+	}
+	if processed > 0 {
+		return "duplicate", nil
+	}
+
+	var result string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = applyPaymentNotification(tx, method, notification, record)
+		if err != nil || result != "processed" {
+			return err
 		}
-	}()
 
-	// 查找订单
-	var payment models.Payment
-	if err := tx.Where("order_no = ?", req.OrderNo).First(&payment).Error; err != nil {
-		tx.Rollback()
+		// 发票与支付状态在同一事务内开具，开票失败时整笔通知回滚，由支付平台重试
+		var order models.Payment
+		if err := tx.First(&order, *record.PaymentID).Error; err != nil {
+			return err
+		}
+		_, err = issueInvoice(tx, h.invoiceSeller, &order)
+		return err
+	})
+	return result, err
+}
+
+// saveNotification 记录一次支付结果通知，保存失败只记日志
+func (h *PaymentHandler) saveNotification(record *models.PaymentNotification, result string, err error) {
+	record.Result = result
+	if err != nil {
+		record.Error = err.Error()
+		log.Printf("payment notify (%s): order %q: %s: %v", record.Provider, record.OrderNo, result, err)
+	}
+	if saveErr := h.db.Create(record).Error; saveErr != nil {
+		log.Printf("payment notify (%s): failed to save notification: %v", record.Provider, saveErr)
+	}
+}
+
+// applyPaymentNotification 在订单行锁内应用已验签的支付成功通知，返回处理结果：
+// processed 本次完成支付；duplicate 同一流水号已处理；review 订单已支付、过期或取消后
+// 又收到付款，记录为 OrphanPayment 等待管理员退款。并发的重复通知会在行锁上排队，只有第一条会发放权益。
+func applyPaymentNotification(tx *gorm.DB, method string, notification *payment.Notification, record *models.PaymentNotification) (string, error) {
+	var order models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_no = ?", notification.OrderNo).
+		First(&order).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+			return "", errOrderNotFound
 		}
-		return
+		return "", err
 	}
+	record.PaymentID = &order.ID
 
-	// 检查订单状态
-	if payment.Status != "pending" {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "订单状态异常"})
-		return
+	// 金额必须与下单金额一致，防止篡改金额或错配订单
+	if notification.Amount != order.Amount {
+		return "", fmt.Errorf("%w: paid %d, expected %d", errAmountMismatch, notification.Amount, order.Amount)
 	}
 
-	// 检查订单是否过期
-	if time.Now().After(payment.ExpiredAt) {
-		payment.Status = "expired"
-		tx.Save(&payment)
-		tx.Commit()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "订单已过期"})
-		return
+	if order.Status == "paid" && order.PaymentMethod == method && order.TransactionID == notification.TransactionID {
+		return "duplicate", nil
+	}
+
+	if order.Status != "pending" {
+		log.Printf("payment notify (%s): order %s is %s, payment %s needs manual review",
+			method, order.OrderNo, order.Status, notification.TransactionID)
+		return "review", recordOrphanPayment(tx, method, notification, &order)
 	}
 
-	// 更新订单状态
 	now := time.Now()
-	payment.Status = "paid"
-	payment.PaymentMethod = req.PaymentMethod
-	payment.TransactionID = req.TransactionID
-	payment.PaidAt = &now
-
-	if err := tx.Save(&payment).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新订单失败"})
-		return
+	if now.After(order.ExpiredAt) {
+		// 订单过期后才到账，预留已释放，需人工退款
+		log.Printf("payment notify (%s): order %s paid after expiry, payment %s needs refund",
+			method, order.OrderNo, notification.TransactionID)
+		if err := expirePayment(tx, &order); err != nil {
+			return "", err
+		}
+		return "review", recordOrphanPayment(tx, method, notification, &order)
 	}
 
-	// 生成 License Key
-	keyCode := generateLicenseKey()
+	if err := transitionPayment(tx, &order, "paid", map[string]interface{}{
+		"payment_method": method,
+		"transaction_id": notification.TransactionID,
+		"paid_at":        now,
+	}); err != nil {
+		return "", err
+	}
+
+	if err := fulfillPayment(tx, &order); err != nil {
+		return "", err
+	}
+	return "processed", nil
+}
+
+// fulfillPayment 根据订单类型发放权益：独享账号转移所有权，订阅套餐开通或续期订阅，
+// 其余产品生成 License Key
+func fulfillPayment(tx *gorm.DB, order *models.Payment) error {
+	if order.ProductType == accountOrderProductType {
+		_, err := fulfillAccountOrder(tx, order)
+		return err
+	}
+
+	if order.ProductVersionID != nil {
+		offer, err := catalog.VersionOffer(tx, *order.ProductVersionID)
+		if err != nil {
+			return err
+		}
+		if offer.Kind == catalog.KindSubscription {
+			_, err := grantSubscription(tx, order.UserID, offer.Type, offer.DurationDays, &order.ID)
+			return err
+		}
+	}
+
+	now := time.Now()
 	licenseKey := models.LicenseKey{
-		UserID:      payment.UserID,
-		PaymentID:   payment.ID,
-		KeyCode:     keyCode,
-		ProductType: payment.ProductType,
-		QuotaTotal:  payment.QuotaAmount,
-		QuotaUsed:   0,
-		Status:      "active",
-		ActivatedAt: &now,
-	}
-
-	if err := tx.Create(&licenseKey).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成密钥失败"})
-		return
+		UserID:           order.UserID,
+		PaymentID:        order.ID,
+		KeyCode:          generateLicenseKey(),
+		ProductType:      order.ProductType,
+		ProductVersionID: order.ProductVersionID,
+		QuotaTotal:       order.QuotaAmount,
+		QuotaUsed:        0,
+		Status:           "active",
+		ActivatedAt:      &now,
 	}
+	return tx.Create(&licenseKey).Error
+}
 
-	// 提交事务
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
-		return
+// expirePayment 将订单标记为过期，归还优惠码用量并释放其预留的账号
+func expirePayment(tx *gorm.DB, payment *models.Payment) error {
+	if err := transitionPayment(tx, payment, "expired", nil); err != nil {
+		return err
+	}
+	if err := releaseCoupon(tx, payment); err != nil {
+		return err
 	}
+	return releaseAccountReservations(tx, payment.ID)
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "支付成功",
-		"license_key": licenseKey,
-	})
+// cancelPayment 用户取消待支付订单，归还优惠码用量并释放其预留的账号
+func cancelPayment(tx *gorm.DB, payment *models.Payment) error {
+	if err := transitionPayment(tx, payment, "canceled", map[string]interface{}{"canceled_at": time.Now()}); err != nil {
+		return err
+	}
+	if err := releaseCoupon(tx, payment); err != nil {
+		return err
+	}
+	return releaseAccountReservations(tx, payment.ID)
 }
 
 // GetMyKeys 获取我的密钥列表
@@ -373,3 +588,54 @@ func generateLicenseKey() string {
 		keyStr[12:16],
 	)
 }
+
+// ExpirePendingOrders 将超过 ExpiredAt 仍未支付的订单标记为过期并释放预留账号（由后台任务调用）。
+// 每个订单在独立的事务中处理，单个订单失败不影响其他订单；期间已被支付或取消的订单直接跳过
+func (h *PaymentHandler) ExpirePendingOrders(ctx context.Context) error {
+	db := h.db.WithContext(ctx)
+
+	var ids []uint
+	if err := db.Model(&models.Payment{}).
+		Where("status = ? AND expired_at < ?", "pending", time.Now()).
+		Order("id").
+		Limit(200).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	var failed int
+	var firstErr error
+	for _, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var order models.Payment
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("id = ? AND status = ?", id, "pending").
+				First(&order).Error; err != nil {
+				return err
+			}
+			return expirePayment(tx, &order)
+		})
+
+		// 订单被其他请求锁定或状态已变更（回调支付、用户取消），下次运行时再看
+		var transitionErr *paymentTransitionError
+		if err == nil || errors.Is(err, gorm.ErrRecordNotFound) || errors.As(err, &transitionErr) {
+			continue
+		}
+		log.Printf("payment expiry: order %d: %v", id, err)
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d orders failed to expire: %w", failed, len(ids), firstErr)
+	}
+	return nil
+}
+
+// MarkExhaustedKeys 将额度已用尽的密钥标记为 exhausted（由后台任务调用）
+func (h *PaymentHandler) MarkExhaustedKeys(ctx context.Context) error {
+	return h.db.WithContext(ctx).Model(&models.LicenseKey{}).
+		Where("status = ? AND quota_used >= quota_total", "active").
+		Update("status", "exhausted").Error
+}
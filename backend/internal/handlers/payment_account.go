@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// accountOrderProductType 购买独享账号的订单类型
+const accountOrderProductType = "exclusive_account"
+
+// accountOrderError 某个账号无法下单的原因
+type accountOrderError struct {
+	AccountID uint
+	Reason    string // not_found, unavailable, unpriced
+}
+
+func (e *accountOrderError) Error() string {
+	return fmt.Sprintf("account %d: %s", e.AccountID, e.Reason)
+}
+
+// createAccountOrder 为指定独享账号创建待支付订单并预留账号，tx 需为事务。
+// 账号在订单支付或过期前保持 reserved，不能被其他用户下单。
+func createAccountOrder(tx *gorm.DB, userID uint, accountIDs []uint) (*models.Payment, []models.PaymentItem, error) {
+	var accounts []models.Account
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ? AND type = ?", accountIDs, "exclusive").
+		Order("id asc").
+		Find(&accounts).Error; err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[uint]models.Account, len(accounts))
+	for _, account := range accounts {
+		found[account.ID] = account
+	}
+
+	amount := 0
+	for _, id := range accountIDs {
+		account, ok := found[id]
+		switch {
+		case !ok:
+			return nil, nil, &accountOrderError{AccountID: id, Reason: "not_found"}
+		case account.Status != "available":
+			return nil, nil, &accountOrderError{AccountID: id, Reason: "unavailable"}
+		case account.Price <= 0:
+			return nil, nil, &accountOrderError{AccountID: id, Reason: "unpriced"}
+		}
+		amount += account.Price
+	}
+
+	payment := models.Payment{
+		UserID:      userID,
+		OrderNo:     generateOrderNo(),
+		Amount:      amount,
+		ProductType: accountOrderProductType,
+		Status:      "pending",
+		ExpiredAt:   time.Now().Add(orderTTL),
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]models.PaymentItem, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		items = append(items, models.PaymentItem{
+			PaymentID: payment.ID,
+			AccountID: id,
+			Price:     found[id].Price,
+		})
+	}
+	if err := tx.Create(&items).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Model(&models.Account{}).
+		Where("id IN ?", accountIDs).
+		Update("status", "reserved").Error; err != nil {
+		return nil, nil, err
+	}
+
+	if err := recordAudit(tx, userID, "exclusive.reserve", "payment", payment.ID, map[string]interface{}{
+		"order_no":    payment.OrderNo,
+		"account_ids": accountIDs,
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return &payment, items, nil
+}
+
+// fulfillAccountOrder 订单支付成功后将预留的账号转移给买家
+func fulfillAccountOrder(tx *gorm.DB, payment *models.Payment) ([]models.ExclusivePurchase, error) {
+	var items []models.PaymentItem
+	if err := tx.Where("payment_id = ?", payment.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	purchases := make([]models.ExclusivePurchase, 0, len(items))
+	for _, item := range items {
+		var account models.Account
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND type = ?", item.AccountID, "exclusive").
+			First(&account).Error; err != nil {
+			return nil, err
+		}
+		if account.Status != "reserved" {
+			return nil, &accountOrderError{AccountID: account.ID, Reason: "unavailable"}
+		}
+
+		purchase, err := sellExclusive(tx, &account, payment.UserID, &payment.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := recordAudit(tx, payment.UserID, "exclusive.purchase", "account", account.ID, map[string]interface{}{
+			"payment_id": payment.ID,
+			"price":      item.Price,
+		}); err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, *purchase)
+	}
+	return purchases, nil
+}
+
+// releaseAccountReservations 订单未支付时释放其预留的账号
+func releaseAccountReservations(tx *gorm.DB, paymentID uint) error {
+	return tx.Model(&models.Account{}).
+		Where("status = ? AND id IN (?)", "reserved",
+			tx.Model(&models.PaymentItem{}).Select("account_id").Where("payment_id = ?", paymentID)).
+		Update("status", "available").Error
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// orderExclusive 通过 CreateOrder 为独享账号下单
+func orderExclusive(t *testing.T, h *PaymentHandler, userID, accountID uint) (int, models.Payment) {
+	t.Helper()
+	w := performAs(t, userID, nil, h.CreateOrder, http.MethodPost, "/payments/orders", "/payments/orders",
+		CreateOrderRequest{ProductType: accountOrderProductType, AccountID: accountID})
+	var response struct {
+		Order models.Payment `json:"order"`
+	}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, response.Order
+}
+
+func accountStatus(t *testing.T, db *gorm.DB, accountID uint) string {
+	t.Helper()
+	var account models.Account
+	if err := db.First(&account, accountID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return account.Status
+}
+
+func TestExclusiveOrderReservesUntilPaid(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	router := newNotifyRouter(t, db)
+	buyer := createTestUser(t, db)
+	rival := createTestUser(t, db)
+	account := createTestAccount(t, db, "exclusive", "available", 1990)
+
+	code, order := orderExclusive(t, h, buyer.ID, account.ID)
+	if code != http.StatusOK || order.Amount != 1990 || order.Status != "pending" {
+		t.Fatalf("order: status = %d, order = %+v", code, order)
+	}
+	if status := accountStatus(t, db, account.ID); status != "reserved" {
+		t.Fatalf("account status = %s, want reserved", status)
+	}
+
+	// 预留期间其他用户不能下单，账号也没有转移
+	if code, _ := orderExclusive(t, h, rival.ID, account.ID); code != http.StatusConflict {
+		t.Errorf("ordering a reserved account: status = %d, want 409", code)
+	}
+	var purchases int64
+	db.Model(&models.ExclusivePurchase{}).Where("account_id = ?", account.ID).Count(&purchases)
+	if purchases != 0 {
+		t.Fatalf("account transferred before payment")
+	}
+
+	// 只有支付回调会转移所有权
+	paySuccess(t, router, order)
+	if status := accountStatus(t, db, account.ID); status != "sold" {
+		t.Errorf("account status after payment = %s, want sold", status)
+	}
+	var purchase models.ExclusivePurchase
+	if err := db.Where("account_id = ?", account.ID).First(&purchase).Error; err != nil {
+		t.Fatal(err)
+	}
+	if purchase.UserID != buyer.ID || purchase.PaymentID == nil || *purchase.PaymentID != order.ID {
+		t.Errorf("purchase = %+v", purchase)
+	}
+}
+
+func TestExpiredExclusiveOrderReleasesReservation(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	user := createTestUser(t, db)
+	account := createTestAccount(t, db, "exclusive", "available", 1990)
+
+	code, order := orderExclusive(t, h, user.ID, account.ID)
+	if code != http.StatusOK {
+		t.Fatalf("order: status = %d", code)
+	}
+	if err := db.Model(&models.Payment{}).Where("id = ?", order.ID).Update("expired_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.ExpirePendingOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "expired" {
+		t.Errorf("order status = %s, want expired", order.Status)
+	}
+	if status := accountStatus(t, db, account.ID); status != "available" {
+		t.Errorf("account status after expiry = %s, want available", status)
+	}
+}
+
+func TestExclusiveOrderRejectsUnpricedAccount(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	user := createTestUser(t, db)
+	account := createTestAccount(t, db, "exclusive", "available", 0)
+
+	if code, _ := orderExclusive(t, h, user.ID, account.ID); code == http.StatusOK {
+		t.Fatal("ordered an unpriced account")
+	}
+	if status := accountStatus(t, db, account.ID); status != "available" {
+		t.Errorf("account status = %s, want available", status)
+	}
+}
+
+func TestExpirePendingOrdersSkipsLockedOrder(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	user := createTestUser(t, db)
+	locked := createTestOrder(t, db, user.ID, 1000)
+	free := createTestOrder(t, db, user.ID, 1000)
+	if err := db.Model(&models.Payment{}).Where("id IN ?", []uint{locked.ID, free.ID}).
+		Update("expired_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟正在处理支付回调的请求持有订单行锁
+	tx := db.Begin()
+	defer tx.Rollback()
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Payment{}, locked.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 被锁定的订单跳过，不影响其他订单过期
+	if err := h.ExpirePendingOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	statuses := func() (string, string) {
+		var l, f models.Payment
+		db.First(&l, locked.ID)
+		db.First(&f, free.ID)
+		return l.Status, f.Status
+	}
+	if l, f := statuses(); l != "pending" || f != "expired" {
+		t.Errorf("after first run: locked = %s, free = %s, want pending/expired", l, f)
+	}
+
+	tx.Rollback()
+	if err := h.ExpirePendingOrders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := statuses(); l != "expired" {
+		t.Errorf("after lock released: locked = %s, want expired", l)
+	}
+}
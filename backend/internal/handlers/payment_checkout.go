@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errUnsupportedMethod = errors.New("unsupported payment method")
+
+// CheckoutRequest 发起支付请求
+type CheckoutRequest struct {
+	PaymentMethod string `json:"payment_method" binding:"required"`
+}
+
+// createCheckout 向支付网关发起支付并记录订单选择的支付方式
+func (h *PaymentHandler) createCheckout(ctx context.Context, order *models.Payment, method string) (*payment.Checkout, error) {
+	gateway, ok := h.providers.Gateway(method)
+	if !ok {
+		return nil, errUnsupportedMethod
+	}
+
+	checkout, err := gateway.CreateCheckout(ctx, payment.Order{
+		OrderNo:   order.OrderNo,
+		Amount:    order.Amount,
+		Subject:   orderSubject(h.db, order),
+		ExpiresAt: order.ExpiredAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.db.Model(order).Update("payment_method", method).Error; err != nil {
+		return nil, err
+	}
+	return checkout, nil
+}
+
+// orderSubject 支付页面展示的商品名称
+func orderSubject(db *gorm.DB, order *models.Payment) string {
+	if order.ProductType == accountOrderProductType {
+		return "独享账号"
+	}
+	return catalog.ProductName(db, order.ProductType)
+}
+
+// writeCheckoutError 将发起支付失败原因转换为响应
+func writeCheckoutError(c *gin.Context, err error) {
+	if errors.Is(err, errUnsupportedMethod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的支付方式"})
+		return
+	}
+	log.Printf("payment checkout: %v", err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "发起支付失败，请稍后重试"})
+}
+
+// CheckoutOrder 为待支付订单生成支付链接或二维码，可重复调用以切换支付方式
+func (h *PaymentHandler) CheckoutOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var req CheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	var order models.Payment
+	if err := h.db.Where("order_no = ? AND user_id = ?", c.Param("order_no"), userID).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+		}
+		return
+	}
+	if order.Status != "pending" || time.Now().After(order.ExpiredAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "订单已失效", "status": order.Status})
+		return
+	}
+
+	checkout, err := h.createCheckout(c.Request.Context(), &order, req.PaymentMethod)
+	if err != nil {
+		writeCheckoutError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order":    order,
+		"checkout": checkout,
+	})
+}
+
+// GetOrderStatus 供前端轮询订单状态。refresh=true 且订单仍待支付时主动向支付平台查询，
+// 用于补偿丢失或延迟的回调
+func (h *PaymentHandler) GetOrderStatus(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var order models.Payment
+	if err := h.db.Where("order_no = ? AND user_id = ?", c.Param("order_no"), userID).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+		}
+		return
+	}
+
+	if order.Status == "pending" && c.Query("refresh") == "true" {
+		if err := h.syncOrderFromGateway(c.Request.Context(), &order); err != nil {
+			log.Printf("payment sync: order %s: %v", order.OrderNo, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_no":   order.OrderNo,
+		"status":     order.Status,
+		"paid_at":    order.PaidAt,
+		"expired_at": order.ExpiredAt,
+	})
+}
+
+// syncOrderFromGateway 向订单所选的支付平台查询交易，已支付时按回调同样的流程处理，
+// 查询结果同样记录到 payment_notifications
+func (h *PaymentHandler) syncOrderFromGateway(ctx context.Context, order *models.Payment) error {
+	gateway, ok := h.providers.Gateway(order.PaymentMethod)
+	if !ok {
+		return nil
+	}
+
+	notification, err := gateway.QueryTrade(ctx, order.OrderNo)
+	if errors.Is(err, payment.ErrTradeNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	record := models.PaymentNotification{Provider: gateway.Name(), Raw: "query"}
+	result, err := h.handleNotification(gateway.Name(), notification, &record)
+	if err != nil {
+		h.saveNotification(&record, "failed", err)
+		return err
+	}
+	h.saveNotification(&record, result, nil)
+
+	return h.db.First(order, order.ID).Error
+}
+
+// formatYuan 将金额（分）格式化为元
+func formatYuan(fen int) string {
+	return fmt.Sprintf("%d.%02d", fen/100, fen%100)
+}
+
+// MockPayRequest 模拟支付结果
+type MockPayRequest struct {
+	Outcome    string `json:"outcome" form:"outcome" binding:"required,oneof=success failure"`
+	DelayMs    int    `json:"delay_ms" form:"delay_ms" binding:"min=0,max=60000"`
+	Duplicates int    `json:"duplicates" form:"duplicates" binding:"min=0,max=10"`
+	Amount     *int   `json:"amount" form:"amount"` // 覆盖回调金额，用于测试金额校验
+}
+
+var mockPayPage = template.Must(template.New("mock").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head><meta charset="utf-8"><title>模拟支付</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 40px auto;">
+<h2>模拟支付</h2>
+<p>订单号：<code>{{.OrderNo}}</code></p>
+<p>商品：{{.Subject}}</p>
+<p>金额：¥{{.Amount}}</p>
+<form method="post"><input type="hidden" name="outcome" value="success"><button>支付成功</button></form>
+<form method="post"><input type="hidden" name="outcome" value="failure"><button>支付失败</button></form>
+<form method="post"><input type="hidden" name="outcome" value="success"><input type="hidden" name="delay_ms" value="5000"><button>5 秒后支付成功</button></form>
+<form method="post"><input type="hidden" name="outcome" value="success"><input type="hidden" name="duplicates" value="3"><button>支付成功（重复回调 3 次）</button></form>
+</body>
+</html>`))
+
+// MockPayPage 模拟网关的支付页面（即支付链接和二维码指向的地址），仅在启用本地支付时注册
+func (h *PaymentHandler) MockPayPage(c *gin.Context) {
+	var order models.Payment
+	if err := h.db.Where("order_no = ?", c.Param("order_no")).First(&order).Error; err != nil {
+		c.String(http.StatusNotFound, "订单不存在")
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	mockPayPage.Execute(c.Writer, gin.H{
+		"OrderNo": order.OrderNo,
+		"Subject": orderSubject(h.db, &order),
+		"Amount":  formatYuan(order.Amount),
+	})
+}
+
+// MockPay 模拟用户在支付平台完成支付：按指定结果、延迟和重复次数发送签名回调
+func (h *PaymentHandler) MockPay(c *gin.Context) {
+	mock := h.providers.Mock()
+	if mock == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未启用模拟支付"})
+		return
+	}
+
+	var req MockPayRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Payment
+	if err := h.db.Where("order_no = ?", c.Param("order_no")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		return
+	}
+
+	// 模拟网关的交易只保存在内存中，服务重启后按订单重新登记
+	if _, err := mock.CreateCheckout(c.Request.Context(), payment.Order{
+		OrderNo:   order.OrderNo,
+		Amount:    order.Amount,
+		Subject:   orderSubject(h.db, &order),
+		ExpiresAt: order.ExpiredAt,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := mock.Simulate(payment.Simulation{
+		OrderNo:    order.OrderNo,
+		Outcome:    req.Outcome,
+		Delay:      time.Duration(req.DelayMs) * time.Millisecond,
+		Duplicates: req.Duplicates,
+		Amount:     req.Amount,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "已提交模拟支付，回调将异步发送"})
+}
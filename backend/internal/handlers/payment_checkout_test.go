@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// newGatewayTestHandler 模拟网关的支付链接和回调地址都指向 baseURL
+func newGatewayTestHandler(t *testing.T, db *gorm.DB, baseURL string) *PaymentHandler {
+	t.Helper()
+	cfg := &config.Config{LocalPaymentSecret: testLocalSecret, PaymentBaseURL: baseURL, InvoiceSellerName: "Test"}
+	providers, err := payment.NewRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPaymentHandler(db, providers, cfg)
+}
+
+// newCallbackServer 启动接收模拟网关回调的服务，返回使用该地址的处理器
+func newCallbackServer(t *testing.T, db *gorm.DB) *PaymentHandler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	h := newGatewayTestHandler(t, db, server.URL)
+	router.POST("/api/v1/payments/notify/:provider", h.PaymentNotify)
+	return h
+}
+
+func checkoutOrder(t *testing.T, h *PaymentHandler, order models.Payment, method string) (int, *payment.Checkout) {
+	t.Helper()
+	w := performAs(t, order.UserID, nil, h.CheckoutOrder, http.MethodPost, "/payments/orders/:order_no/checkout",
+		"/payments/orders/"+order.OrderNo+"/checkout", CheckoutRequest{PaymentMethod: method})
+	var response struct {
+		Checkout *payment.Checkout `json:"checkout"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return w.Code, response.Checkout
+}
+
+func orderStatus(t *testing.T, h *PaymentHandler, order models.Payment, refresh bool) string {
+	t.Helper()
+	target := "/payments/orders/" + order.OrderNo + "/status"
+	if refresh {
+		target += "?refresh=true"
+	}
+	w := performAs(t, order.UserID, nil, h.GetOrderStatus, http.MethodGet, "/payments/orders/:order_no/status", target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: %d", w.Code)
+	}
+	var response struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Status
+}
+
+func mockPay(t *testing.T, h *PaymentHandler, order models.Payment, req MockPayRequest) {
+	t.Helper()
+	w := performAs(t, 0, nil, h.MockPay, http.MethodPost, "/payments/mock/:order_no", "/payments/mock/"+order.OrderNo, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("mock pay: status = %d, body = %s", w.Code, w.Body)
+	}
+}
+
+func TestCheckoutAndPollWithMockGateway(t *testing.T) {
+	db := openTestDB(t)
+	h := newCallbackServer(t, db)
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+
+	code, checkout := checkoutOrder(t, h, order, "local")
+	if code != http.StatusOK || checkout == nil {
+		t.Fatalf("checkout: status = %d", code)
+	}
+	if checkout.Method != "local" || !strings.HasSuffix(checkout.PayURL, "/api/v1/payments/mock/"+order.OrderNo) {
+		t.Errorf("checkout = %+v", checkout)
+	}
+	if err := db.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.PaymentMethod != "local" {
+		t.Errorf("payment_method = %q, want local", order.PaymentMethod)
+	}
+
+	// 支付前主动查询不改变订单
+	if status := orderStatus(t, h, order, true); status != "pending" {
+		t.Fatalf("status before payment = %s, want pending", status)
+	}
+
+	// 网关异步发送回调（含重复回调），轮询直到订单完成
+	mockPay(t, h, order, MockPayRequest{Outcome: "success", Duplicates: 2})
+	deadline := time.Now().Add(5 * time.Second)
+	status := orderStatus(t, h, order, false)
+	for status == "pending" && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		status = orderStatus(t, h, order, false)
+	}
+	if status != "paid" {
+		t.Fatalf("status after payment = %s, want paid", status)
+	}
+
+	// 等待重复回调处理完，只发放一个密钥
+	time.Sleep(200 * time.Millisecond)
+	var keys int64
+	db.Model(&models.LicenseKey{}).Where("payment_id = ?", order.ID).Count(&keys)
+	if keys != 1 {
+		t.Errorf("license keys = %d, want 1", keys)
+	}
+}
+
+func TestOrderStatusRefreshRecoversLostCallback(t *testing.T) {
+	db := openTestDB(t)
+	// 回调地址不可达，模拟回调丢失
+	h := newGatewayTestHandler(t, db, "http://127.0.0.1:1")
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+
+	if code, _ := checkoutOrder(t, h, order, "local"); code != http.StatusOK {
+		t.Fatalf("checkout: status = %d", code)
+	}
+	mockPay(t, h, order, MockPayRequest{Outcome: "success"})
+
+	if status := orderStatus(t, h, order, false); status != "pending" {
+		t.Errorf("status without refresh = %s, want pending", status)
+	}
+	if status := orderStatus(t, h, order, true); status != "paid" {
+		t.Fatalf("status with refresh = %s, want paid", status)
+	}
+
+	var record models.PaymentNotification
+	if err := db.Where("order_no = ? AND raw = ?", order.OrderNo, "query").First(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.Result != "processed" {
+		t.Errorf("query result = %s, want processed", record.Result)
+	}
+}
+
+func TestCheckoutOrderRejectsInvalidRequests(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+
+	if code, _ := checkoutOrder(t, h, order, "nope"); code != http.StatusBadRequest {
+		t.Errorf("unsupported method: status = %d, want 400", code)
+	}
+
+	if err := db.Model(&order).Update("expired_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := checkoutOrder(t, h, order, "local"); code != http.StatusConflict {
+		t.Errorf("expired order: status = %d, want 409", code)
+	}
+
+	// 其他用户的订单不可见
+	other := createTestUser(t, db)
+	order.UserID = other.ID
+	if code, _ := checkoutOrder(t, h, order, "local"); code != http.StatusNotFound {
+		t.Errorf("other user's order: status = %d, want 404", code)
+	}
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// couponError 优惠码不能用于当前订单的原因
+type couponError struct {
+	Code   string
+	Reason string // not_found, inactive, not_started, ended, product, exhausted, user_limit
+}
+
+func (e *couponError) Error() string {
+	return fmt.Sprintf("coupon %s: %s", e.Code, e.Reason)
+}
+
+var couponErrorMessages = map[string]string{
+	"not_found":   "优惠码不存在",
+	"inactive":    "优惠码已停用",
+	"not_started": "优惠活动尚未开始",
+	"ended":       "优惠码已过期",
+	"product":     "优惠码不适用于该产品",
+	"exhausted":   "优惠码已被领完",
+	"user_limit":  "已达到该优惠码的使用次数上限",
+}
+
+// writeCouponError 将优惠码校验失败原因转换为响应，返回是否已处理
+func writeCouponError(c *gin.Context, err error) bool {
+	couponErr, ok := err.(*couponError)
+	if !ok {
+		return false
+	}
+	status := http.StatusBadRequest
+	if couponErr.Reason == "exhausted" || couponErr.Reason == "user_limit" {
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": couponErrorMessages[couponErr.Reason], "reason": couponErr.Reason})
+	return true
+}
+
+// normalizeCouponCode 优惠码不区分大小写，统一保存为大写
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// couponUsageStatuses 占用优惠码用量的订单状态，过期和取消的订单已归还用量
+var couponUsageStatuses = []string{"pending", "paid", "refunded"}
+
+// checkCoupon 检查优惠码当前能否被该用户用于指定产品
+func checkCoupon(db *gorm.DB, coupon *models.Coupon, userID uint, productType string, now time.Time) error {
+	fail := func(reason string) error { return &couponError{Code: coupon.Code, Reason: reason} }
+
+	switch {
+	case !coupon.Active:
+		return fail("inactive")
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return fail("not_started")
+	case coupon.EndsAt != nil && !now.Before(*coupon.EndsAt):
+		return fail("ended")
+	case !couponAppliesTo(coupon, productType):
+		return fail("product")
+	case coupon.MaxUses > 0 && coupon.UsedCount >= coupon.MaxUses:
+		return fail("exhausted")
+	}
+
+	if coupon.PerUserLimit > 0 {
+		var used int64
+		if err := db.Model(&models.Payment{}).
+			Where("coupon_id = ? AND user_id = ? AND status IN ?", coupon.ID, userID, couponUsageStatuses).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if int(used) >= coupon.PerUserLimit {
+			return fail("user_limit")
+		}
+	}
+	return nil
+}
+
+func couponAppliesTo(coupon *models.Coupon, productType string) bool {
+	if len(coupon.ProductTypes) == 0 {
+		return true
+	}
+	for _, t := range coupon.ProductTypes {
+		if t == productType {
+			return true
+		}
+	}
+	return false
+}
+
+// couponDiscount 计算优惠金额。支付平台不接受 0 元订单，优惠后实付金额至少保留 1 分
+func couponDiscount(coupon *models.Coupon, amount int) int {
+	discount := coupon.DiscountValue
+	if coupon.DiscountType == "percent" {
+		discount = amount * coupon.DiscountValue / 100
+	}
+	if discount > amount-1 {
+		discount = amount - 1
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// applyCoupon 在优惠码行锁内校验并占用一次用量，同时更新订单的实付金额。
+// tx 需为事务，order 需已创建；并发下单时只有拿到行锁的请求能占用最后一次用量。
+func applyCoupon(tx *gorm.DB, order *models.Payment, code string) error {
+	code = normalizeCouponCode(code)
+
+	var coupon models.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code = ?", code).
+		First(&coupon).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return &couponError{Code: code, Reason: "not_found"}
+		}
+		return err
+	}
+
+	if err := checkCoupon(tx, &coupon, order.UserID, order.ProductType, time.Now()); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&coupon).Update("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return err
+	}
+
+	discount := couponDiscount(&coupon, order.Amount)
+	return tx.Model(order).Updates(map[string]interface{}{
+		"amount":          order.Amount - discount,
+		"discount_amount": discount,
+		"coupon_id":       coupon.ID,
+		"coupon_code":     coupon.Code,
+	}).Error
+}
+
+// releaseCoupon 订单过期或取消后归还其占用的优惠码用量
+func releaseCoupon(tx *gorm.DB, payment *models.Payment) error {
+	if payment.CouponID == nil {
+		return nil
+	}
+	return tx.Model(&models.Coupon{}).
+		Where("id = ? AND used_count > 0", *payment.CouponID).
+		Update("used_count", gorm.Expr("used_count - 1")).Error
+}
+
+// ValidateCouponRequest 下单前查询优惠码
+type ValidateCouponRequest struct {
+	Code        string `json:"code" binding:"required"`
+	ProductType string `json:"product_type" binding:"required"`
+	AccountID   uint   `json:"account_id"` // product_type=exclusive_account 时必填
+}
+
+// ValidateCoupon 下单前预览优惠码的优惠金额，不占用用量
+func (h *PaymentHandler) ValidateCoupon(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var req ValidateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	var amount int
+	if req.ProductType == accountOrderProductType {
+		var account models.Account
+		if err := h.db.Where("id = ? AND type = ?", req.AccountID, "exclusive").First(&account).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "账号不存在"})
+			return
+		}
+		amount = account.Price
+	} else {
+		offer, err := catalog.FindOffer(h.db, req.ProductType)
+		if err != nil {
+			if err == catalog.ErrProductNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的产品类型"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+			}
+			return
+		}
+		amount = offer.Price
+	}
+
+	code := normalizeCouponCode(req.Code)
+	var coupon models.Coupon
+	if err := h.db.Where("code = ?", code).First(&coupon).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			writeCouponError(c, &couponError{Code: code, Reason: "not_found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询优惠码失败"})
+		}
+		return
+	}
+	if err := checkCoupon(h.db, &coupon, userID.(uint), req.ProductType, time.Now()); err != nil {
+		if !writeCouponError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询优惠码失败"})
+		}
+		return
+	}
+
+	discount := couponDiscount(&coupon, amount)
+	c.JSON(http.StatusOK, gin.H{
+		"code":            coupon.Code,
+		"description":     coupon.Description,
+		"discount_type":   coupon.DiscountType,
+		"discount_value":  coupon.DiscountValue,
+		"original_amount": amount,
+		"discount_amount": discount,
+		"amount":          amount - discount,
+	})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	tests := []struct {
+		name          string
+		discountType  string
+		value, amount int
+		want          int
+	}{
+		{"percent", "percent", 20, 1000, 200},
+		{"percent rounds down", "percent", 15, 999, 149},
+		{"percent keeps one fen", "percent", 100, 1000, 999},
+		{"fixed", "fixed", 300, 1000, 300},
+		{"fixed keeps one fen", "fixed", 5000, 1000, 999},
+		{"one fen order", "fixed", 300, 1, 0},
+		{"negative value", "fixed", -100, 1000, 0},
+	}
+	for _, tt := range tests {
+		coupon := &models.Coupon{DiscountType: tt.discountType, DiscountValue: tt.value}
+		if got := couponDiscount(coupon, tt.amount); got != tt.want {
+			t.Errorf("%s: couponDiscount(%d) = %d, want %d", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCoupon(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	// PerUserLimit 为 0 时不访问数据库
+	tests := []struct {
+		name   string
+		coupon models.Coupon
+		want   string
+	}{
+		{"ok", models.Coupon{Active: true}, ""},
+		{"inactive", models.Coupon{Active: false}, "inactive"},
+		{"not started", models.Coupon{Active: true, StartsAt: &after}, "not_started"},
+		{"started", models.Coupon{Active: true, StartsAt: &before}, ""},
+		{"ended", models.Coupon{Active: true, EndsAt: &before}, "ended"},
+		{"ends exactly now", models.Coupon{Active: true, EndsAt: &now}, "ended"},
+		{"other product", models.Coupon{Active: true, ProductTypes: []string{"pro"}}, "product"},
+		{"listed product", models.Coupon{Active: true, ProductTypes: []string{"pro", "basic"}}, ""},
+		{"exhausted", models.Coupon{Active: true, MaxUses: 3, UsedCount: 3}, "exhausted"},
+		{"last use", models.Coupon{Active: true, MaxUses: 3, UsedCount: 2}, ""},
+	}
+	for _, tt := range tests {
+		err := checkCoupon(nil, &tt.coupon, 1, "basic", now)
+		if got := couponErrorReason(err); got != tt.want {
+			t.Errorf("%s: reason = %q, want %q (err %v)", tt.name, got, tt.want, err)
+		}
+	}
+}
+
+func couponErrorReason(err error) string {
+	var couponErr *couponError
+	if errors.As(err, &couponErr) {
+		return couponErr.Reason
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// createTestCoupon 创建测试优惠码，需在使用它的订单之前创建，保证清理时先删订单
+func createTestCoupon(t *testing.T, db *gorm.DB, coupon models.Coupon) models.Coupon {
+	t.Helper()
+	coupon.Code = normalizeCouponCode("test" + uniqueSuffix())
+	coupon.DiscountType = "fixed"
+	coupon.DiscountValue = 100
+	coupon.Active = true
+	if err := db.Create(&coupon).Error; err != nil {
+		t.Fatalf("create coupon: %v", err)
+	}
+	t.Cleanup(func() { db.Delete(&coupon) })
+	return coupon
+}
+
+func applyTestCoupon(db *gorm.DB, order *models.Payment, code string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return applyCoupon(tx, order, code)
+	})
+}
+
+func TestApplyCouponMaxUsesUnderConcurrency(t *testing.T) {
+	db := openTestDB(t)
+	user := createTestUser(t, db)
+	coupon := createTestCoupon(t, db, models.Coupon{MaxUses: 3})
+
+	const orders = 10
+	errs := make([]error, orders)
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		order := createTestOrder(t, db, user.ID, 1000)
+		wg.Add(1)
+		go func(i int, order models.Payment) {
+			defer wg.Done()
+			// 用户输入的优惠码大小写不敏感
+			errs[i] = applyTestCoupon(db, &order, " "+strings.ToLower(coupon.Code)+" ")
+		}(i, order)
+	}
+	wg.Wait()
+
+	applied := 0
+	for i, err := range errs {
+		switch reason := couponErrorReason(err); reason {
+		case "":
+			applied++
+		case "exhausted":
+		default:
+			t.Errorf("order %d: unexpected error %v", i, err)
+		}
+	}
+	if applied != coupon.MaxUses {
+		t.Errorf("applied %d times, want %d", applied, coupon.MaxUses)
+	}
+
+	var current models.Coupon
+	if err := db.First(&current, coupon.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if current.UsedCount != coupon.MaxUses {
+		t.Errorf("used_count = %d, want %d", current.UsedCount, coupon.MaxUses)
+	}
+
+	var discounted int64
+	if err := db.Model(&models.Payment{}).
+		Where("coupon_id = ? AND amount = ? AND discount_amount = ?", coupon.ID, 900, 100).
+		Count(&discounted).Error; err != nil {
+		t.Fatal(err)
+	}
+	if int(discounted) != coupon.MaxUses {
+		t.Errorf("discounted orders = %d, want %d", discounted, coupon.MaxUses)
+	}
+}
+
+func TestApplyCouponPerUserLimit(t *testing.T) {
+	db := openTestDB(t)
+	alice := createTestUser(t, db)
+	bob := createTestUser(t, db)
+	coupon := createTestCoupon(t, db, models.Coupon{PerUserLimit: 1})
+
+	first := createTestOrder(t, db, alice.ID, 1000)
+	if err := applyTestCoupon(db, &first, coupon.Code); err != nil {
+		t.Fatalf("first order: %v", err)
+	}
+
+	second := createTestOrder(t, db, alice.ID, 1000)
+	if reason := couponErrorReason(applyTestCoupon(db, &second, coupon.Code)); reason != "user_limit" {
+		t.Fatalf("second order: reason = %q, want user_limit", reason)
+	}
+
+	other := createTestOrder(t, db, bob.ID, 1000)
+	if err := applyTestCoupon(db, &other, coupon.Code); err != nil {
+		t.Fatalf("other user: %v", err)
+	}
+
+	// 订单过期后归还用量，同一用户可以再次使用
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&first, first.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&first).Update("status", "expired").Error; err != nil {
+			return err
+		}
+		return releaseCoupon(tx, &first)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	third := createTestOrder(t, db, alice.ID, 1000)
+	if err := applyTestCoupon(db, &third, coupon.Code); err != nil {
+		t.Fatalf("order after expiry: %v", err)
+	}
+
+	var current models.Coupon
+	if err := db.First(&current, coupon.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if current.UsedCount != 2 {
+		t.Errorf("used_count = %d, want 2", current.UsedCount)
+	}
+}
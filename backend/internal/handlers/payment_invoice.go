@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/invoice"
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// invoiceSeller 开票方信息，开票时复制到发票上
+type invoiceSeller struct {
+	Name    string
+	TaxID   string
+	Address string
+	TaxRate int // 基点
+}
+
+// nextInvoiceNo 在事务内领取当年的下一个发票号。序号行在事务提交前保持锁定，
+// 事务回滚时序号一并回滚，因此编号连续不跳号
+func nextInvoiceNo(tx *gorm.DB, issuedAt time.Time) (string, error) {
+	year := issuedAt.Year()
+	var number int
+	if err := tx.Raw(`INSERT INTO invoice_sequences (year, last_number) VALUES (?, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number`, year).Scan(&number).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("INV%d%06d", year, number), nil
+}
+
+// invoiceLines 订单的发票明细：独享账号订单每个账号一行，其余订单按下单时的产品版本一行，
+// 单价为优惠前价格
+func invoiceLines(tx *gorm.DB, order *models.Payment) ([]models.InvoiceLine, error) {
+	if order.ProductType == accountOrderProductType {
+		var items []models.PaymentItem
+		if err := tx.Where("payment_id = ?", order.ID).Order("id").Find(&items).Error; err != nil {
+			return nil, err
+		}
+		lines := make([]models.InvoiceLine, len(items))
+		for i, item := range items {
+			lines[i] = models.InvoiceLine{
+				Description: fmt.Sprintf("独享账号 #%d", item.AccountID),
+				Quantity:    1,
+				UnitPrice:   item.Price,
+				Amount:      item.Price,
+			}
+		}
+		return lines, nil
+	}
+
+	description := order.ProductType
+	if order.ProductVersionID != nil {
+		offer, err := catalog.VersionOffer(tx, *order.ProductVersionID)
+		if err != nil && err != catalog.ErrProductNotFound {
+			return nil, err
+		}
+		if err == nil {
+			description = offer.Name
+			if offer.Kind == catalog.KindSubscription {
+				description += fmt.Sprintf("（%d 天）", offer.DurationDays)
+			}
+		}
+	}
+	if order.QuotaAmount > 0 {
+		description += fmt.Sprintf("（额度 %d 次）", order.QuotaAmount)
+	}
+
+	price := order.Amount + order.DiscountAmount
+	return []models.InvoiceLine{{Description: description, Quantity: 1, UnitPrice: price, Amount: price}}, nil
+}
+
+// issueInvoice 为已支付订单开具发票，已开具时直接返回。调用方需持有订单行锁，
+// 同一订单只会开具一张发票
+func issueInvoice(tx *gorm.DB, seller invoiceSeller, order *models.Payment) (*models.Invoice, error) {
+	var existing models.Invoice
+	err := tx.Where("payment_id = ?", order.ID).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var buyer models.User
+	if err := tx.Unscoped().First(&buyer, order.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	lines, err := invoiceLines(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	subtotal := 0
+	for _, line := range lines {
+		subtotal += line.Amount
+	}
+
+	now := time.Now()
+	invoiceNo, err := nextInvoiceNo(tx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	paidAt := now
+	if order.PaidAt != nil {
+		paidAt = *order.PaidAt
+	}
+	inv := models.Invoice{
+		InvoiceNo:     invoiceNo,
+		PaymentID:     order.ID,
+		UserID:        order.UserID,
+		OrderNo:       order.OrderNo,
+		BuyerName:     buyer.Username,
+		BuyerEmail:    buyer.Email,
+		SellerName:    seller.Name,
+		SellerTaxID:   seller.TaxID,
+		SellerAddress: seller.Address,
+		Currency:      "CNY",
+		Lines:         lines,
+		Subtotal:      subtotal,
+		Discount:      order.DiscountAmount,
+		Total:         order.Amount,
+		TaxRate:       seller.TaxRate,
+		TaxAmount:     invoice.TaxIncluded(order.Amount, seller.TaxRate),
+		PaidAt:        paidAt,
+		IssuedAt:      now,
+	}
+	if err := tx.Create(&inv).Error; err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// GetInvoice 下载订单发票，format=html（默认）或 pdf。发票在订单支付成功时开具，
+// 开票功能上线前已支付的订单在首次下载时补开
+func (h *PaymentHandler) GetInvoice(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "html")
+	if format != "html" && format != "pdf" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 只支持 html 或 pdf"})
+		return
+	}
+
+	var inv *models.Invoice
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var order models.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_no = ? AND user_id = ?", c.Param("order_no"), userID).
+			First(&order).Error; err != nil {
+			return err
+		}
+		// 退款后发票仍可下载，退款金额见退款记录
+		if order.Status != "paid" && order.Status != "refunded" {
+			return errOrderNotPaid
+		}
+
+		var err error
+		inv, err = issueInvoice(tx, h.invoiceSeller, &order)
+		return err
+	})
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		case errOrderNotPaid:
+			c.JSON(http.StatusConflict, gin.H{"error": "订单未支付，无法开具发票"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "开具发票失败"})
+		}
+		return
+	}
+
+	if format == "pdf" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.InvoiceNo))
+		c.Data(http.StatusOK, "application/pdf", invoice.PDF(inv))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.WriteHTML(&buf, inv); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成发票失败"})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const testLocalSecret = "test-local-payment-secret"
+
+// newTestPaymentHandler 只启用本地替身支付平台（网关名为 local）
+func newTestPaymentHandler(t *testing.T, db *gorm.DB) *PaymentHandler {
+	t.Helper()
+	cfg := &config.Config{LocalPaymentSecret: testLocalSecret, PaymentBaseURL: "http://localhost", InvoiceSellerName: "Test"}
+	providers, err := payment.NewRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPaymentHandler(db, providers, cfg)
+}
+
+// newNotifyRouter 只挂载回调路由，使用本地替身支付平台签名
+func newNotifyRouter(t *testing.T, db *gorm.DB) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/payments/notify/:provider", newTestPaymentHandler(t, db).PaymentNotify)
+	return router
+}
+
+// paySuccess 发送订单全额支付成功的回调，返回交易号
+func paySuccess(t *testing.T, router *gin.Engine, order models.Payment) string {
+	t.Helper()
+	transactionID := "TXN" + uniqueSuffix()
+	body := []byte(fmt.Sprintf(`{"order_no":%q,"transaction_id":%q,"amount":%d,"status":"success"}`, order.OrderNo, transactionID, order.Amount))
+	if code := sendLocalNotification(router, body); code != http.StatusOK {
+		t.Fatalf("payment notification for %s: status %d", order.OrderNo, code)
+	}
+	return transactionID
+}
+
+func sendLocalNotification(router *gin.Engine, body []byte) int {
+	req := httptest.NewRequest(http.MethodPost, "/payments/notify/local", bytes.NewReader(body))
+	req.Header.Set(payment.LocalSignatureHeader, payment.NewLocalProvider(testLocalSecret).Sign(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPaymentNotifyIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+	router := newNotifyRouter(t, db)
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+
+	transactionID := "TXN" + uniqueSuffix()
+	body := []byte(fmt.Sprintf(`{"order_no":%q,"transaction_id":%q,"amount":1000,"status":"success"}`, order.OrderNo, transactionID))
+
+	// 支付平台可能并发重发同一条通知，之后还会继续重试
+	const concurrent = 5
+	codes := make([]int, concurrent)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = sendLocalNotification(router, body)
+		}(i)
+	}
+	wg.Wait()
+	codes = append(codes, sendLocalNotification(router, body))
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("notification %d: status %d, want 200", i, code)
+		}
+	}
+
+	var paid models.Payment
+	if err := db.First(&paid, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if paid.Status != "paid" || paid.TransactionID != transactionID || paid.PaymentMethod != "local" {
+		t.Fatalf("order = %s/%s/%s, want paid/%s/local", paid.Status, paid.TransactionID, paid.PaymentMethod, transactionID)
+	}
+
+	counts := map[string]struct {
+		query *gorm.DB
+		want  int64
+	}{
+		"license keys":            {db.Model(&models.LicenseKey{}).Where("payment_id = ?", order.ID), 1},
+		"invoices":                {db.Model(&models.Invoice{}).Where("payment_id = ?", order.ID), 1},
+		"processed notifications": {db.Model(&models.PaymentNotification{}).Where("transaction_id = ? AND result = ?", transactionID, "processed"), 1},
+		"duplicate notifications": {db.Model(&models.PaymentNotification{}).Where("transaction_id = ? AND result = ?", transactionID, "duplicate"), concurrent},
+	}
+	for name, c := range counts {
+		var got int64
+		if err := c.query.Count(&got).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s = %d, want %d", name, got, c.want)
+		}
+	}
+}
+
+func TestPaymentNotifyRejectsAmountMismatch(t *testing.T) {
+	db := openTestDB(t)
+	router := newNotifyRouter(t, db)
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+
+	body := []byte(fmt.Sprintf(`{"order_no":%q,"transaction_id":%q,"amount":1,"status":"success"}`, order.OrderNo, "TXN"+uniqueSuffix()))
+	if code := sendLocalNotification(router, body); code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", code)
+	}
+
+	var current models.Payment
+	if err := db.First(&current, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if current.Status != "pending" {
+		t.Fatalf("order status = %s, want pending", current.Status)
+	}
+
+	var record models.PaymentNotification
+	if err := db.Where("order_no = ?", order.OrderNo).First(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.Result != "rejected" {
+		t.Fatalf("notification result = %s, want rejected", record.Result)
+	}
+}
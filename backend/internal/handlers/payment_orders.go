@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ListOrders 查询当前用户的订单，支持按状态（逗号分隔多个）、产品类型和下单时间过滤
+func (h *PaymentHandler) ListOrders(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := h.db.Model(&models.Payment{}).Where("user_id = ?", userID)
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		statuses := strings.Split(status, ",")
+		for _, s := range statuses {
+			if !isPaymentStatus(s) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的订单状态: " + s})
+				return
+			}
+		}
+		query = query.Where("status IN ?", statuses)
+	}
+	if productType := c.Query("product_type"); productType != "" {
+		query = query.Where("product_type = ?", productType)
+	}
+	for param, cond := range map[string]string{"from": "created_at >= ?", "to": "created_at < ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseOrderTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的时间参数: " + param})
+			return
+		}
+		query = query.Where(cond, t)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+		return
+	}
+
+	var orders []models.Payment
+	if err := query.Order("created_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders":    orders,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// parseOrderTime 支持 RFC3339 时间和 2006-01-02 日期
+func parseOrderTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// CancelOrder 用户取消待支付订单，预留的独享账号立即释放
+func (h *PaymentHandler) CancelOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var order models.Payment
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_no = ? AND user_id = ?", c.Param("order_no"), userID).
+			First(&order).Error; err != nil {
+			return err
+		}
+		if err := cancelPayment(tx, &order); err != nil {
+			return err
+		}
+		return recordAudit(tx, order.UserID, "payment.cancel", "payment", order.ID, map[string]interface{}{
+			"order_no": order.OrderNo,
+		})
+	})
+
+	var transitionErr *paymentTransitionError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "订单已取消", "order": order})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "订单当前状态不可取消", "status": transitionErr.From})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "取消订单失败"})
+	}
+}
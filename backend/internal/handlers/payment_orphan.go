@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// recordOrphanPayment 记录订单无法接收的付款，等待管理员退款。
+// 支付平台重发同一交易的通知时只保留第一条记录
+func recordOrphanPayment(tx *gorm.DB, method string, notification *payment.Notification, order *models.Payment) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider"}, {Name: "transaction_id"}},
+		DoNothing: true,
+	}).Create(&models.OrphanPayment{
+		PaymentID:     order.ID,
+		OrderNo:       order.OrderNo,
+		Provider:      method,
+		TransactionID: notification.TransactionID,
+		Amount:        notification.Amount,
+		OrderStatus:   order.Status,
+		Status:        "pending",
+	}).Error
+}
+
+// ListOrphanPayments 查询需要退款的异常付款，status 为空时返回全部
+func (h *PaymentAdminHandler) ListOrphanPayments(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := h.db.Model(&models.OrphanPayment{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if orderNo := c.Query("order_no"); orderNo != "" {
+		query = query.Where("order_no = ?", orderNo)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询异常付款失败"})
+		return
+	}
+
+	var orphans []models.OrphanPayment
+	if err := query.Order("id desc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&orphans).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询异常付款失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orphan_payments": orphans,
+		"total":           total,
+		"page":            page,
+		"page_size":       pageSize,
+	})
+}
+
+// OrphanRefundRequest 异常付款退款请求
+type OrphanRefundRequest struct {
+	Manual bool   `json:"manual"` // 已线下退款，只记录结果不调用支付平台
+	Note   string `json:"note" binding:"max=500"`
+}
+
+// RefundOrphanPayment 退还异常付款：支持原路退款的平台直接调用退款接口，失败后可再次调用重试；
+// 其余平台需线下退款后以 manual 标记处理完成
+func (h *PaymentAdminHandler) RefundOrphanPayment(c *gin.Context) {
+	var req OrphanRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	operatorID := c.GetUint("user_id")
+
+	var orphan models.OrphanPayment
+	var refunder payment.Refunder
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&orphan, c.Param("id")).Error; err != nil {
+			return err
+		}
+		if orphan.Status != "pending" && orphan.Status != "failed" {
+			return &refundError{Status: http.StatusConflict, Message: "该付款已处理"}
+		}
+
+		updates := map[string]interface{}{"operator_id": operatorID, "note": req.Note}
+		if req.Manual {
+			updates["status"] = "manual"
+			updates["resolved_at"] = time.Now()
+		} else {
+			var ok bool
+			if refunder, ok = h.refunder(orphan.Provider); !ok {
+				return &refundError{Status: http.StatusConflict, Message: "该支付方式不支持原路退款，请线下退款后标记为已处理"}
+			}
+			// 退款单号只生成一次，重试时支付平台按单号去重
+			if orphan.RefundNo == "" {
+				updates["refund_no"] = generateRefundNo()
+			}
+		}
+		if err := tx.Model(&orphan).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&orphan, orphan.ID).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, operatorID, "payment.orphan_refund", "payment", orphan.PaymentID, map[string]interface{}{
+			"orphan_id":      orphan.ID,
+			"transaction_id": orphan.TransactionID,
+			"manual":         req.Manual,
+		})
+	})
+
+	var refundErr *refundError
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "异常付款记录不存在"})
+		return
+	case errors.As(err, &refundErr):
+		c.JSON(refundErr.Status, gin.H{"error": refundErr.Message, "orphan_payment": orphan})
+		return
+	default:
+		log.Printf("refund: orphan payment %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退款失败"})
+		return
+	}
+
+	if req.Manual {
+		c.JSON(http.StatusOK, gin.H{"message": "已标记为线下退款", "orphan_payment": orphan})
+		return
+	}
+
+	// 网络调用在事务外进行，结果以 pending/failed 为条件写入，并发重试只会记录一次
+	updates := map[string]interface{}{}
+	providerRefundID, callErr := refunder.Refund(c.Request.Context(), orphan.OrderNo, orphan.RefundNo, orphan.Amount)
+	if callErr != nil {
+		log.Printf("refund: orphan payment %d: refund %s: gateway %s: %v", orphan.ID, orphan.RefundNo, orphan.Provider, callErr)
+		updates["status"] = "failed"
+	} else {
+		updates["status"] = "refunded"
+		updates["provider_refund_id"] = providerRefundID
+		updates["resolved_at"] = time.Now()
+	}
+	if err := h.db.Model(&models.OrphanPayment{}).
+		Where("id = ? AND status IN ?", orphan.ID, []string{"pending", "failed"}).
+		Updates(updates).Error; err != nil {
+		log.Printf("refund: orphan payment %d: failed to save gateway result %v: %v", orphan.ID, updates, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存退款结果失败"})
+		return
+	}
+	if err := h.db.First(&orphan, orphan.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询异常付款失败"})
+		return
+	}
+
+	if callErr != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "支付平台退款失败，请稍后重试", "orphan_payment": orphan})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "退款成功", "orphan_payment": orphan})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func sendPayment(t *testing.T, router *gin.Engine, order models.Payment, transactionID string) {
+	t.Helper()
+	body := []byte(fmt.Sprintf(`{"order_no":%q,"transaction_id":%q,"amount":%d,"status":"success"}`, order.OrderNo, transactionID, order.Amount))
+	if code := sendLocalNotification(router, body); code != http.StatusOK {
+		t.Fatalf("payment notification %s: status %d, want 200", transactionID, code)
+	}
+}
+
+func orphanPayments(t *testing.T, db *gorm.DB, paymentID uint) []models.OrphanPayment {
+	t.Helper()
+	var orphans []models.OrphanPayment
+	if err := db.Where("payment_id = ?", paymentID).Order("id").Find(&orphans).Error; err != nil {
+		t.Fatal(err)
+	}
+	return orphans
+}
+
+func refundOrphan(t *testing.T, h *PaymentAdminHandler, id uint, req OrphanRefundRequest) (int, models.OrphanPayment) {
+	t.Helper()
+	target := fmt.Sprintf("/admin/payments/orphans/%d/refund", id)
+	w := performAs(t, 0, nil, h.RefundOrphanPayment, http.MethodPost, "/admin/payments/orphans/:id/refund", target, req)
+	var response struct {
+		OrphanPayment models.OrphanPayment `json:"orphan_payment"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return w.Code, response.OrphanPayment
+}
+
+func TestLatePaymentIsRecordedForRefund(t *testing.T) {
+	db := openTestDB(t)
+	router := newNotifyRouter(t, db)
+	admin := NewPaymentAdminHandler(db, newTestPaymentHandler(t, db).providers)
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+	if err := db.Model(&order).Update("expired_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 过期后才到账，支付平台重发的通知不会重复记录
+	transactionID := "TXN" + uniqueSuffix()
+	sendPayment(t, router, order, transactionID)
+	sendPayment(t, router, order, transactionID)
+
+	if err := db.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "expired" {
+		t.Errorf("order status = %s, want expired", order.Status)
+	}
+	var keys int64
+	db.Model(&models.LicenseKey{}).Where("payment_id = ?", order.ID).Count(&keys)
+	if keys != 0 {
+		t.Errorf("license keys = %d, want 0", keys)
+	}
+
+	orphans := orphanPayments(t, db, order.ID)
+	if len(orphans) != 1 {
+		t.Fatalf("orphan payments = %d, want 1", len(orphans))
+	}
+	orphan := orphans[0]
+	if orphan.TransactionID != transactionID || orphan.Provider != "local" || orphan.Amount != 1000 ||
+		orphan.OrderStatus != "expired" || orphan.Status != "pending" {
+		t.Errorf("orphan payment = %+v", orphan)
+	}
+
+	w := performAs(t, 0, nil, admin.ListOrphanPayments, http.MethodGet, "/admin/payments/orphans",
+		"/admin/payments/orphans?"+url.Values{"status": {"pending"}, "order_no": {order.OrderNo}}.Encode(), nil)
+	var list struct {
+		OrphanPayments []models.OrphanPayment `json:"orphan_payments"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(list.OrphanPayments) != 1 || list.OrphanPayments[0].ID != orphan.ID {
+		t.Errorf("list: status = %d, orphans = %+v", w.Code, list.OrphanPayments)
+	}
+
+	// 本地网关支持原路退款
+	code, refunded := refundOrphan(t, admin, orphan.ID, OrphanRefundRequest{})
+	if code != http.StatusOK {
+		t.Fatalf("refund: status = %d", code)
+	}
+	if refunded.Status != "refunded" || refunded.RefundNo == "" || refunded.ProviderRefundID == "" || refunded.ResolvedAt == nil {
+		t.Errorf("refunded orphan payment = %+v", refunded)
+	}
+	if code, _ := refundOrphan(t, admin, orphan.ID, OrphanRefundRequest{}); code != http.StatusConflict {
+		t.Errorf("second refund: status = %d, want 409", code)
+	}
+}
+
+func TestSecondPaymentOnPaidOrderIsRecordedForRefund(t *testing.T) {
+	db := openTestDB(t)
+	router := newNotifyRouter(t, db)
+	admin := NewPaymentAdminHandler(db, newTestPaymentHandler(t, db).providers)
+	user := createTestUser(t, db)
+	order := createTestOrder(t, db, user.ID, 1000)
+
+	first := paySuccess(t, router, order)
+	second := "TXN" + uniqueSuffix()
+	sendPayment(t, router, order, second)
+
+	if err := db.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "paid" || order.TransactionID != first {
+		t.Errorf("order = %s/%s, want paid/%s", order.Status, order.TransactionID, first)
+	}
+	var keys int64
+	db.Model(&models.LicenseKey{}).Where("payment_id = ?", order.ID).Count(&keys)
+	if keys != 1 {
+		t.Errorf("license keys = %d, want 1", keys)
+	}
+
+	orphans := orphanPayments(t, db, order.ID)
+	if len(orphans) != 1 || orphans[0].TransactionID != second || orphans[0].OrderStatus != "paid" {
+		t.Fatalf("orphan payments = %+v", orphans)
+	}
+
+	// 线下退款后标记为已处理
+	code, resolved := refundOrphan(t, admin, orphans[0].ID, OrphanRefundRequest{Manual: true, Note: "bank transfer"})
+	if code != http.StatusOK {
+		t.Fatalf("manual refund: status = %d", code)
+	}
+	if resolved.Status != "manual" || resolved.Note != "bank transfer" || resolved.ResolvedAt == nil {
+		t.Errorf("resolved orphan payment = %+v", resolved)
+	}
+}
@@ -0,0 +1,616 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentAdminHandler 管理员订单操作
+type PaymentAdminHandler struct {
+	db        *gorm.DB
+	providers *payment.Registry
+}
+
+func NewPaymentAdminHandler(db *gorm.DB, providers *payment.Registry) *PaymentAdminHandler {
+	return &PaymentAdminHandler{db: db, providers: providers}
+}
+
+// RefundRequest 退款请求
+type RefundRequest struct {
+	Amount     int    `json:"amount" binding:"min=0"` // 分，0 表示按账号价格或剩余可退金额
+	Reason     string `json:"reason" binding:"required,max=500"`
+	AccountIDs []uint `json:"account_ids"` // 独享账号订单中退回的账号，不填时全额退款退回全部账号
+}
+
+// refundError 退款请求不合法
+type refundError struct {
+	Status  int
+	Message string
+}
+
+func (e *refundError) Error() string { return e.Message }
+
+// RefundClawback 退款时收回的权益
+type RefundClawback struct {
+	LicenseKeys   []RefundedKey          `json:"license_keys"`
+	AccountIDs    []uint                 `json:"account_ids"`
+	Subscriptions []RefundedSubscription `json:"subscriptions"`
+}
+
+// RefundedSubscription 退款后订阅的变化
+type RefundedSubscription struct {
+	ID                uint      `json:"id"`
+	Status            string    `json:"status"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	PreviousExpiresAt time.Time `json:"previous_expires_at"`
+	RemovedSeconds    int64     `json:"removed_seconds"`
+}
+
+// RefundedKey 退款后密钥的变化
+type RefundedKey struct {
+	ID            uint   `json:"id"`
+	Status        string `json:"status"`
+	QuotaRemoved  int    `json:"quota_removed"`
+	QuotaTotal    int    `json:"quota_total"`
+	QuotaUsed     int    `json:"quota_used"`
+	PreviousTotal int    `json:"previous_total"`
+}
+
+// RefundOrder 管理员对已支付订单全额或部分退款，并收回对应权益：
+// 全额退款撤销 License Key、订阅和全部独享账号；部分退款按比例扣减 License Key 未使用的额度和
+// 订阅未使用的时长，独享账号订单按 account_ids 退回指定账号
+func (h *PaymentAdminHandler) RefundOrder(c *gin.Context) {
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	operatorID := c.GetUint("user_id")
+
+	var (
+		order    models.Payment
+		refund   *models.Refund
+		clawback *RefundClawback
+	)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_no = ?", c.Param("order_no")).
+			First(&order).Error; err != nil {
+			return err
+		}
+
+		// 支持原路退款的订单先记为 pending，事务提交后再调用支付平台
+		status := "manual"
+		if _, ok := h.refunder(order.PaymentMethod); ok {
+			status = "pending"
+		}
+
+		var err error
+		refund, clawback, err = refundPayment(tx, &order, operatorID, req, status)
+		return err
+	})
+
+	var refundErr *refundError
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		return
+	case errors.As(err, &refundErr):
+		c.JSON(refundErr.Status, gin.H{"error": refundErr.Message})
+		return
+	default:
+		log.Printf("refund: order %s: %v", c.Param("order_no"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "退款失败"})
+		return
+	}
+
+	// 网络调用不占用订单行锁；退款记录已提交，平台退款失败时可通过重试接口再次发起
+	if refund.Status == "pending" {
+		if err := h.submitRefund(c.Request.Context(), &order, refund); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":    "支付平台退款失败，请稍后重试",
+				"order":    order,
+				"refund":   refund,
+				"clawback": clawback,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "退款成功",
+		"order":    order,
+		"refund":   refund,
+		"clawback": clawback,
+	})
+}
+
+// RetryRefund 重新向支付平台提交 pending 或 failed 的退款。商户退款单号不变，平台按单号去重，
+// 不会重复退款
+func (h *PaymentAdminHandler) RetryRefund(c *gin.Context) {
+	var refund models.Refund
+	if err := h.db.Where("refund_no = ?", c.Param("refund_no")).First(&refund).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "退款记录不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询退款记录失败"})
+		}
+		return
+	}
+	if refund.Status != "pending" && refund.Status != "failed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "退款已完成或需线下处理，无需重试", "refund": refund})
+		return
+	}
+
+	var order models.Payment
+	if err := h.db.First(&order, refund.PaymentID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+		return
+	}
+
+	err := h.submitRefund(c.Request.Context(), &order, &refund)
+	if auditErr := recordAudit(h.db, c.GetUint("user_id"), "payment.refund_retry", "payment", order.ID, map[string]interface{}{
+		"refund_no": refund.RefundNo,
+		"status":    refund.Status,
+	}); auditErr != nil {
+		log.Printf("refund: retry %s: failed to record audit: %v", refund.RefundNo, auditErr)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "支付平台退款失败，请稍后重试", "refund": refund})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "退款成功", "refund": refund})
+}
+
+// refunder 订单支付方式对应的网关支持原路退款时返回该网关
+func (h *PaymentAdminHandler) refunder(method string) (payment.Refunder, bool) {
+	gateway, ok := h.providers.Gateway(method)
+	if !ok {
+		return nil, false
+	}
+	refunder, ok := gateway.(payment.Refunder)
+	return refunder, ok
+}
+
+// submitRefund 在事务外调用支付平台退款，成功记为 completed，失败记为 failed。
+// 状态更新以 pending/failed 为条件，并发重试只会记录一次结果
+func (h *PaymentAdminHandler) submitRefund(ctx context.Context, order *models.Payment, refund *models.Refund) error {
+	refunder, ok := h.refunder(order.PaymentMethod)
+	if !ok {
+		return fmt.Errorf("payment method %q does not support refunds", order.PaymentMethod)
+	}
+
+	updates := map[string]interface{}{}
+	providerRefundID, callErr := refunder.Refund(ctx, order.OrderNo, refund.RefundNo, refund.Amount)
+	if callErr != nil {
+		log.Printf("refund: order %s: refund %s: gateway %s: %v", order.OrderNo, refund.RefundNo, order.PaymentMethod, callErr)
+		updates["status"] = "failed"
+	} else {
+		updates["status"] = "completed"
+		updates["provider_refund_id"] = providerRefundID
+	}
+
+	if err := h.db.Model(&models.Refund{}).
+		Where("id = ? AND status IN ?", refund.ID, []string{"pending", "failed"}).
+		Updates(updates).Error; err != nil {
+		log.Printf("refund: order %s: refund %s: failed to save gateway result %v: %v", order.OrderNo, refund.RefundNo, updates, err)
+		return err
+	}
+	if err := h.db.First(refund, refund.ID).Error; err != nil {
+		return err
+	}
+	return callErr
+}
+
+// refundPayment 在订单行锁内记录退款、更新订单并收回权益，退款记录的初始状态为 status
+func refundPayment(tx *gorm.DB, order *models.Payment, operatorID uint, req RefundRequest, status string) (*models.Refund, *RefundClawback, error) {
+	if order.Status != "paid" {
+		return nil, nil, &refundError{Status: http.StatusConflict, Message: "订单当前状态不可退款"}
+	}
+	remaining := order.Amount - order.RefundedAmount
+
+	var all []models.PaymentItem
+	if order.ProductType == accountOrderProductType {
+		if err := tx.Where("payment_id = ?", order.ID).Order("id").Find(&all).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	amount, items, full, err := planRefund(order, all, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refund := models.Refund{
+		RefundNo:   generateRefundNo(),
+		PaymentID:  order.ID,
+		Amount:     amount,
+		Reason:     req.Reason,
+		OperatorID: operatorID,
+		Method:     order.PaymentMethod,
+		Status:     status,
+	}
+	if err := tx.Create(&refund).Error; err != nil {
+		return nil, nil, err
+	}
+
+	refundedAmount := order.RefundedAmount + amount
+	if full {
+		if err := transitionPayment(tx, order, "refunded", map[string]interface{}{"refunded_amount": refundedAmount}); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if err := tx.Model(order).Update("refunded_amount", refundedAmount).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	clawback := &RefundClawback{LicenseKeys: []RefundedKey{}, AccountIDs: []uint{}, Subscriptions: []RefundedSubscription{}}
+	if clawback.LicenseKeys, err = clawbackLicenseKeys(tx, order.ID, amount, remaining); err != nil {
+		return nil, nil, err
+	}
+	if clawback.AccountIDs, err = revertExclusiveItems(tx, order, items, refund.ID); err != nil {
+		return nil, nil, err
+	}
+	if clawback.Subscriptions, err = clawbackSubscriptions(tx, order, amount, remaining); err != nil {
+		return nil, nil, err
+	}
+
+	if err := recordAudit(tx, operatorID, "payment.refund", "payment", order.ID, map[string]interface{}{
+		"order_no":  order.OrderNo,
+		"refund_no": refund.RefundNo,
+		"amount":    amount,
+		"full":      full,
+		"reason":    req.Reason,
+		"clawback":  clawback,
+	}); err != nil {
+		return nil, nil, err
+	}
+	if err := notifyUser(tx, order.UserID, "payment.refund", "订单已退款",
+		fmt.Sprintf("订单 %s 已退款 %s 元，相关权益已按退款金额收回。", order.OrderNo, formatYuan(amount))); err != nil {
+		return nil, nil, err
+	}
+
+	return &refund, clawback, nil
+}
+
+// planRefund 确定退款金额和退回的账号，full 表示退还剩余全部金额（订单变为 refunded）。
+// all 为独享账号订单的全部明细，按 id 排序。只选了部分未退款账号时，其余账号仍归买家所有，
+// 订单必须保持 paid，因此退款金额不能达到剩余可退金额
+func planRefund(order *models.Payment, all []models.PaymentItem, req RefundRequest) (int, []models.PaymentItem, bool, error) {
+	remaining := order.Amount - order.RefundedAmount
+
+	// 独享账号订单：确定退回的账号，未指定金额时按账号分摊优惠后的实付金额退款
+	var items []models.PaymentItem
+	partial := false
+	if order.ProductType == accountOrderProductType {
+		for _, item := range all {
+			if item.RefundID == nil {
+				items = append(items, item)
+			}
+		}
+		if len(req.AccountIDs) > 0 {
+			selected, err := selectRefundItems(items, req.AccountIDs)
+			if err != nil {
+				return 0, nil, false, err
+			}
+			partial = len(selected) < len(items)
+			items = selected
+			if req.Amount == 0 {
+				shares := itemPaidShares(all, order.Amount)
+				for _, item := range items {
+					req.Amount += shares[item.ID]
+				}
+				// 之前有过只退钱的部分退款时，剩余可退金额可能小于分摊金额
+				if req.Amount > remaining {
+					req.Amount = remaining
+				}
+			}
+		}
+	} else if len(req.AccountIDs) > 0 {
+		return 0, nil, false, &refundError{Status: http.StatusBadRequest, Message: "该订单不是独享账号订单"}
+	}
+
+	amount := req.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return 0, nil, false, &refundError{Status: http.StatusBadRequest, Message: fmt.Sprintf("退款金额必须在 0.01 到 %s 元之间", formatYuan(remaining))}
+	}
+	if partial && amount == remaining {
+		return 0, nil, false, &refundError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("未选择订单中全部未退款的账号，退款金额必须小于剩余可退金额 %s 元", formatYuan(remaining)),
+		}
+	}
+	full := amount == remaining
+	if order.ProductType == accountOrderProductType && !full && len(req.AccountIDs) == 0 {
+		// 未指定账号的部分退款只退钱，不收回账号
+		items = nil
+	}
+	return amount, items, full, nil
+}
+
+// selectRefundItems 从未退款的订单明细中选出指定账号
+func selectRefundItems(items []models.PaymentItem, accountIDs []uint) ([]models.PaymentItem, error) {
+	byAccount := make(map[uint]models.PaymentItem, len(items))
+	for _, item := range items {
+		byAccount[item.AccountID] = item
+	}
+
+	selected := make([]models.PaymentItem, 0, len(accountIDs))
+	seen := make(map[uint]bool, len(accountIDs))
+	for _, id := range accountIDs {
+		item, ok := byAccount[id]
+		if !ok {
+			return nil, &refundError{Status: http.StatusBadRequest, Message: fmt.Sprintf("账号 %d 不在订单中或已退款", id)}
+		}
+		if !seen[id] {
+			seen[id] = true
+			selected = append(selected, item)
+		}
+	}
+	return selected, nil
+}
+
+// itemPaidShares 将订单实付金额按售价比例分摊到每个账号（向下取整），余数计入最后一个账号，
+// 分摊金额之和等于实付金额。items 需按固定顺序排列，保证多次退款的分摊结果一致
+func itemPaidShares(items []models.PaymentItem, paid int) map[uint]int {
+	shares := make(map[uint]int, len(items))
+	subtotal := 0
+	for _, item := range items {
+		subtotal += item.Price
+	}
+	if subtotal <= 0 {
+		return shares
+	}
+
+	allocated := 0
+	for i, item := range items {
+		share := item.Price * paid / subtotal
+		if i == len(items)-1 {
+			share = paid - allocated
+		}
+		shares[item.ID] = share
+		allocated += share
+	}
+	return shares
+}
+
+// clawbackLicenseKeys 收回订单生成的 License Key：退还剩余全部金额时撤销密钥，
+// 否则按 退款金额/剩余可退金额 的比例扣减未使用的额度（向上取整）
+func clawbackLicenseKeys(tx *gorm.DB, paymentID uint, amount, remaining int) ([]RefundedKey, error) {
+	var keys []models.LicenseKey
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("payment_id = ? AND status <> ?", paymentID, "revoked").
+		Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]RefundedKey, 0, len(keys))
+	for _, key := range keys {
+		previous := key.QuotaTotal
+		unused := key.QuotaTotal - key.QuotaUsed
+		if unused < 0 {
+			unused = 0
+		}
+
+		if amount >= remaining {
+			key.Status = "revoked"
+			key.QuotaTotal = key.QuotaUsed
+		} else {
+			key.QuotaTotal -= prorateQuota(unused, amount, remaining)
+			if key.QuotaUsed >= key.QuotaTotal {
+				key.Status = "exhausted"
+			}
+		}
+
+		if err := tx.Model(&key).Updates(map[string]interface{}{
+			"quota_total": key.QuotaTotal,
+			"status":      key.Status,
+		}).Error; err != nil {
+			return nil, err
+		}
+		result = append(result, RefundedKey{
+			ID:            key.ID,
+			Status:        key.Status,
+			QuotaRemoved:  previous - key.QuotaTotal,
+			QuotaTotal:    key.QuotaTotal,
+			QuotaUsed:     key.QuotaUsed,
+			PreviousTotal: previous,
+		})
+	}
+	return result, nil
+}
+
+// revertExclusiveItems 撤销独享账号的所有权，购买记录标记为已退款后保留。买家已经看过账号凭据，账号进入 needs_rotation，
+// 管理员修改密码和 2FA 密钥后才重新上架
+func revertExclusiveItems(tx *gorm.DB, order *models.Payment, items []models.PaymentItem, refundID uint) ([]uint, error) {
+	accountIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		var account models.Account
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, item.AccountID).Error; err != nil {
+			return nil, err
+		}
+
+		if err := tx.Model(&models.ExclusivePurchase{}).
+			Where("account_id = ? AND payment_id = ? AND refunded_at IS NULL", account.ID, order.ID).
+			Updates(map[string]interface{}{"refunded_at": time.Now(), "refund_id": refundID}).Error; err != nil {
+			return nil, err
+		}
+		if account.Status == "sold" {
+			if err := tx.Model(&account).Update("status", "needs_rotation").Error; err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Model(&models.PaymentItem{}).Where("id = ?", item.ID).Update("refund_id", refundID).Error; err != nil {
+			return nil, err
+		}
+		if err := recordAudit(tx, order.UserID, "exclusive.refund", "account", account.ID, map[string]interface{}{
+			"payment_id": order.ID,
+			"refund_id":  refundID,
+		}); err != nil {
+			return nil, err
+		}
+		accountIDs = append(accountIDs, account.ID)
+	}
+	return accountIDs, nil
+}
+
+// clawbackSubscriptions 收回订单开通的订阅：退还剩余全部金额时立即终止（包括宽限期中的一期），
+// 否则按 退款金额/剩余可退金额 的比例缩短尚未使用的时长。之后各期整体提前，保持订阅连续
+func clawbackSubscriptions(tx *gorm.DB, order *models.Payment, amount, remaining int) ([]RefundedSubscription, error) {
+	var subs []models.Subscription
+	if err := tx.Where("payment_id = ? AND status IN ?", order.ID, liveSubscriptionStatuses).Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	result := make([]RefundedSubscription, 0, len(subs))
+	if len(subs) == 0 {
+		return result, nil
+	}
+
+	// 与开通订阅相同，锁定用户后再调整各期时间
+	if err := lockUser(tx, order.UserID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, sub := range subs {
+		begin := sub.StartsAt
+		if begin.Before(now) {
+			begin = now
+		}
+		unused := sub.ExpiresAt.Sub(begin)
+		if unused < 0 {
+			unused = 0
+		}
+
+		previous := sub.ExpiresAt
+		updates := map[string]interface{}{}
+		var removed time.Duration
+		if amount >= remaining {
+			removed = unused
+			sub.Status = "canceled"
+			sub.ExpiresAt = begin
+			updates["status"] = sub.Status
+			updates["grace_ends_at"] = nil
+			if sub.RenewalPaymentID != nil {
+				if err := cancelRenewalOrder(tx, *sub.RenewalPaymentID); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			removed = prorateDuration(unused, amount, remaining)
+			sub.ExpiresAt = sub.ExpiresAt.Add(-removed)
+			if sub.GraceEndsAt != nil {
+				updates["grace_ends_at"] = sub.GraceEndsAt.Add(-removed)
+			}
+		}
+		updates["expires_at"] = sub.ExpiresAt
+		if err := tx.Model(&sub).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+
+		if removed > 0 {
+			if err := shiftLaterSubscriptions(tx, sub.UserID, sub.ID, previous, removed); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, RefundedSubscription{
+			ID:                sub.ID,
+			Status:            sub.Status,
+			ExpiresAt:         sub.ExpiresAt,
+			PreviousExpiresAt: previous,
+			RemovedSeconds:    int64(removed / time.Second),
+		})
+	}
+	return result, nil
+}
+
+// prorateQuota 部分退款应收回的未使用额度：unused * amount / remaining，向上取整
+func prorateQuota(unused, amount, remaining int) int {
+	if unused <= 0 || amount <= 0 || remaining <= 0 {
+		return 0
+	}
+	if amount >= remaining {
+		return unused
+	}
+	return (unused*amount + remaining - 1) / remaining
+}
+
+// prorateDuration 按 amount/remaining 的比例计算要扣除的时长，向上取整到秒
+func prorateDuration(unused time.Duration, amount, remaining int) time.Duration {
+	if unused <= 0 || amount <= 0 || remaining <= 0 {
+		return 0
+	}
+	if amount >= remaining {
+		return unused
+	}
+	seconds := int64(unused / time.Second)
+	removed := (seconds*int64(amount) + int64(remaining) - 1) / int64(remaining)
+	return time.Duration(removed) * time.Second
+}
+
+// shiftLaterSubscriptions 将某一期之后开始的各期提前 removed，避免订阅中间出现空档
+func shiftLaterSubscriptions(tx *gorm.DB, userID, subID uint, after time.Time, removed time.Duration) error {
+	var later []models.Subscription
+	if err := tx.Where("user_id = ? AND id <> ? AND status = ? AND starts_at >= ?", userID, subID, "active", after).
+		Find(&later).Error; err != nil {
+		return err
+	}
+	for _, sub := range later {
+		if err := tx.Model(&sub).Updates(map[string]interface{}{
+			"starts_at":  sub.StartsAt.Add(-removed),
+			"expires_at": sub.ExpiresAt.Add(-removed),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRefunds 查询订单的退款记录
+func (h *PaymentAdminHandler) ListRefunds(c *gin.Context) {
+	var order models.Payment
+	if err := h.db.Where("order_no = ?", c.Param("order_no")).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订单失败"})
+		}
+		return
+	}
+
+	var refunds []models.Refund
+	if err := h.db.Where("payment_id = ?", order.ID).Order("created_at asc").Find(&refunds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询退款记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order":   order,
+		"refunds": refunds,
+	})
+}
+
+// generateRefundNo 生成商户退款单号
+func generateRefundNo() string {
+	randomBytes := make([]byte, 4)
+	rand.Read(randomBytes)
+	return fmt.Sprintf("RFD%s%s", time.Now().Format("20060102150405"), hex.EncodeToString(randomBytes))
+}
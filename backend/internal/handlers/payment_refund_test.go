@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+)
+
+func TestItemPaidShares(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []models.PaymentItem
+		paid  int
+		want  map[uint]int
+	}{
+		{
+			name:  "no discount",
+			items: []models.PaymentItem{{ID: 1, Price: 1000}, {ID: 2, Price: 3000}},
+			paid:  4000,
+			want:  map[uint]int{1: 1000, 2: 3000},
+		},
+		{
+			name:  "discount split by price",
+			items: []models.PaymentItem{{ID: 1, Price: 1000}, {ID: 2, Price: 3000}},
+			paid:  2000,
+			want:  map[uint]int{1: 500, 2: 1500},
+		},
+		{
+			// 向下取整的零头由最后一项承担，合计等于实付金额
+			name:  "rounding remainder on last item",
+			items: []models.PaymentItem{{ID: 1, Price: 1000}, {ID: 2, Price: 1000}, {ID: 3, Price: 1000}},
+			paid:  1000,
+			want:  map[uint]int{1: 333, 2: 333, 3: 334},
+		},
+		{
+			name:  "free order",
+			items: []models.PaymentItem{{ID: 1, Price: 1000}},
+			paid:  0,
+			want:  map[uint]int{1: 0},
+		},
+		{
+			name:  "unpriced items",
+			items: []models.PaymentItem{{ID: 1, Price: 0}},
+			paid:  0,
+			want:  map[uint]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := itemPaidShares(tt.items, tt.paid)
+			if len(got) != len(tt.want) {
+				t.Fatalf("shares = %v, want %v", got, tt.want)
+			}
+			total := 0
+			for id, share := range tt.want {
+				if got[id] != share {
+					t.Errorf("share[%d] = %d, want %d", id, got[id], share)
+				}
+				total += got[id]
+			}
+			if len(tt.want) > 0 && total != tt.paid {
+				t.Errorf("shares sum to %d, want %d", total, tt.paid)
+			}
+		})
+	}
+}
+
+func TestPlanRefund(t *testing.T) {
+	refundID := uint(9)
+	items := []models.PaymentItem{{ID: 1, AccountID: 11, Price: 1000}, {ID: 2, AccountID: 12, Price: 1000}}
+	accountOrder := func(amount, refunded int) *models.Payment {
+		return &models.Payment{ProductType: accountOrderProductType, Amount: amount, RefundedAmount: refunded}
+	}
+
+	tests := []struct {
+		name     string
+		order    *models.Payment
+		items    []models.PaymentItem
+		req      RefundRequest
+		amount   int
+		accounts []uint
+		full     bool
+		wantErr  bool
+	}{
+		{
+			name:     "all accounts by default",
+			order:    accountOrder(2000, 0),
+			items:    items,
+			amount:   2000,
+			accounts: []uint{11, 12},
+			full:     true,
+		},
+		{
+			name:     "selected account share",
+			order:    accountOrder(2000, 0),
+			items:    items,
+			req:      RefundRequest{AccountIDs: []uint{12}},
+			amount:   1000,
+			accounts: []uint{12},
+		},
+		{
+			name:     "money only partial refund keeps accounts",
+			order:    accountOrder(2000, 0),
+			items:    items,
+			req:      RefundRequest{Amount: 500},
+			amount:   500,
+			accounts: nil,
+		},
+		{
+			name:     "last remaining account is a full refund",
+			order:    accountOrder(2000, 1000),
+			items:    []models.PaymentItem{items[0], {ID: 2, AccountID: 12, Price: 1000, RefundID: &refundID}},
+			req:      RefundRequest{AccountIDs: []uint{11}},
+			amount:   1000,
+			accounts: []uint{11},
+			full:     true,
+		},
+		{
+			// 之前只退过钱，所选账号的分摊金额被截断到剩余金额，但另一个账号仍未退款，不能按全额退款处理
+			name:    "capped share with other accounts left",
+			order:   accountOrder(2000, 1500),
+			items:   items,
+			req:     RefundRequest{AccountIDs: []uint{11}},
+			wantErr: true,
+		},
+		{
+			name:    "explicit remaining amount with other accounts left",
+			order:   accountOrder(2000, 0),
+			items:   items,
+			req:     RefundRequest{Amount: 2000, AccountIDs: []uint{11}},
+			wantErr: true,
+		},
+		{
+			name:     "capped share covering every remaining account",
+			order:    accountOrder(2000, 1500),
+			items:    items,
+			req:      RefundRequest{AccountIDs: []uint{11, 12}},
+			amount:   500,
+			accounts: []uint{11, 12},
+			full:     true,
+		},
+		{
+			name:    "account already refunded",
+			order:   accountOrder(2000, 1000),
+			items:   []models.PaymentItem{items[0], {ID: 2, AccountID: 12, Price: 1000, RefundID: &refundID}},
+			req:     RefundRequest{AccountIDs: []uint{12}},
+			wantErr: true,
+		},
+		{
+			name:    "accounts on a license order",
+			order:   &models.Payment{ProductType: "basic", Amount: 1000},
+			req:     RefundRequest{AccountIDs: []uint{11}},
+			wantErr: true,
+		},
+		{
+			name:    "amount over remaining",
+			order:   &models.Payment{ProductType: "basic", Amount: 1000, RefundedAmount: 600},
+			req:     RefundRequest{Amount: 500},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, selected, full, err := planRefund(tt.order, tt.items, tt.req)
+			if tt.wantErr {
+				var refundErr *refundError
+				if !errors.As(err, &refundErr) {
+					t.Fatalf("err = %v, want refundError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if amount != tt.amount || full != tt.full {
+				t.Errorf("amount = %d, full = %v, want %d, %v", amount, full, tt.amount, tt.full)
+			}
+			accounts := make([]uint, 0, len(selected))
+			for _, item := range selected {
+				accounts = append(accounts, item.AccountID)
+			}
+			if len(accounts) != len(tt.accounts) {
+				t.Fatalf("accounts = %v, want %v", accounts, tt.accounts)
+			}
+			for i := range accounts {
+				if accounts[i] != tt.accounts[i] {
+					t.Errorf("accounts = %v, want %v", accounts, tt.accounts)
+				}
+			}
+		})
+	}
+}
+
+func TestProrateQuota(t *testing.T) {
+	tests := []struct {
+		unused, amount, remaining int
+		want                      int
+	}{
+		{unused: 100, amount: 1000, remaining: 1000, want: 100},
+		{unused: 100, amount: 1500, remaining: 1000, want: 100},
+		{unused: 100, amount: 500, remaining: 1000, want: 50},
+		// 向上取整，部分退款不会少收回额度
+		{unused: 100, amount: 1, remaining: 1000, want: 1},
+		{unused: 99, amount: 500, remaining: 1000, want: 50},
+		{unused: 0, amount: 500, remaining: 1000, want: 0},
+		{unused: 100, amount: 0, remaining: 1000, want: 0},
+		{unused: 100, amount: 500, remaining: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := prorateQuota(tt.unused, tt.amount, tt.remaining); got != tt.want {
+			t.Errorf("prorateQuota(%d, %d, %d) = %d, want %d", tt.unused, tt.amount, tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestProrateDuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		unused            time.Duration
+		amount, remaining int
+		want              time.Duration
+	}{
+		{unused: 30 * day, amount: 2900, remaining: 2900, want: 30 * day},
+		{unused: 30 * day, amount: 5000, remaining: 2900, want: 30 * day},
+		{unused: 30 * day, amount: 1450, remaining: 2900, want: 15 * day},
+		{unused: 10 * time.Second, amount: 1, remaining: 3, want: 4 * time.Second},
+		// 不足一秒的部分先截断再按比例向上取整
+		{unused: 10*time.Second + 500*time.Millisecond, amount: 1, remaining: 2, want: 5 * time.Second},
+		{unused: 0, amount: 1000, remaining: 2900, want: 0},
+		{unused: -day, amount: 1000, remaining: 2900, want: 0},
+		{unused: 30 * day, amount: 0, remaining: 2900, want: 0},
+		{unused: 30 * day, amount: 1000, remaining: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := prorateDuration(tt.unused, tt.amount, tt.remaining); got != tt.want {
+			t.Errorf("prorateDuration(%s, %d, %d) = %s, want %s", tt.unused, tt.amount, tt.remaining, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// paymentTransitions 订单状态机，所有订单状态变更都必须经过 transitionPayment：
+//
+//	pending -> paid | expired | canceled
+//	paid    -> refunded
+var paymentTransitions = map[string][]string{
+	"pending": {"paid", "expired", "canceled"},
+	"paid":    {"refunded"},
+}
+
+// paymentStatuses 订单的全部状态
+var paymentStatuses = []string{"pending", "paid", "expired", "canceled", "refunded"}
+
+func isPaymentStatus(status string) bool {
+	for _, s := range paymentStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// paymentTransitionError 订单当前状态不允许变更为目标状态
+type paymentTransitionError struct {
+	OrderNo string
+	From    string
+	To      string
+}
+
+func (e *paymentTransitionError) Error() string {
+	return fmt.Sprintf("order %s cannot transition from %s to %s", e.OrderNo, e.From, e.To)
+}
+
+func canTransitionPayment(from, to string) bool {
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionPayment 将订单从当前状态变更为 to，并同时写入 updates 中的其他字段。
+// 更新条件包含原状态，即使调用方没有锁定订单，并发的状态变更也只有一个会成功。
+func transitionPayment(tx *gorm.DB, order *models.Payment, to string, updates map[string]interface{}) error {
+	from := order.Status
+	if !canTransitionPayment(from, to) {
+		return &paymentTransitionError{OrderNo: order.OrderNo, From: from, To: to}
+	}
+
+	values := map[string]interface{}{"status": to}
+	for column, value := range updates {
+		values[column] = value
+	}
+
+	result := tx.Model(&models.Payment{}).
+		Where("id = ? AND status = ?", order.ID, from).
+		Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 状态已被其他请求修改
+		var current models.Payment
+		if err := tx.Select("status").First(&current, order.ID).Error; err != nil {
+			return err
+		}
+		return &paymentTransitionError{OrderNo: order.OrderNo, From: current.Status, To: to}
+	}
+
+	return tx.First(order, order.ID).Error
+}
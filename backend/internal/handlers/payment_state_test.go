@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"fullstack-backend/internal/models"
+)
+
+func TestCanTransitionPayment(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{"pending", "paid"}:     true,
+		{"pending", "expired"}:  true,
+		{"pending", "canceled"}: true,
+		{"paid", "refunded"}:    true,
+	}
+
+	// 枚举全部状态组合，表外的组合都必须被拒绝
+	for _, from := range paymentStatuses {
+		for _, to := range paymentStatuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransitionPayment(from, to); got != want {
+				t.Errorf("canTransitionPayment(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	for _, tt := range [][2]string{{"", "paid"}, {"unknown", "paid"}, {"pending", "unknown"}, {"pending", ""}} {
+		if canTransitionPayment(tt[0], tt[1]) {
+			t.Errorf("canTransitionPayment(%q, %q) = true, want false", tt[0], tt[1])
+		}
+	}
+}
+
+func TestPaymentTransitionsUseKnownStatuses(t *testing.T) {
+	for from, targets := range paymentTransitions {
+		if !isPaymentStatus(from) {
+			t.Errorf("transition source %q is not a payment status", from)
+		}
+		for _, to := range targets {
+			if !isPaymentStatus(to) {
+				t.Errorf("transition target %q is not a payment status", to)
+			}
+		}
+	}
+}
+
+func TestTransitionPaymentRejectsInvalidTransition(t *testing.T) {
+	// 非法变更在访问数据库前就被拒绝
+	order := &models.Payment{ID: 1, OrderNo: "ORD1", Status: "expired"}
+	err := transitionPayment(nil, order, "paid", nil)
+
+	var transitionErr *paymentTransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("err = %v, want *paymentTransitionError", err)
+	}
+	if transitionErr.From != "expired" || transitionErr.To != "paid" || transitionErr.OrderNo != "ORD1" {
+		t.Fatalf("err = %+v", transitionErr)
+	}
+	if order.Status != "expired" {
+		t.Fatalf("order status changed to %s", order.Status)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"fullstack-backend/internal/catalog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSubscriptionPlans 可购买的订阅套餐（商品目录中上架的订阅产品）
+func (h *PaymentHandler) ListSubscriptionPlans(c *gin.Context) {
+	plans, err := catalog.ActiveOffers(h.db, catalog.KindSubscription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询套餐失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans":           plans,
+		"payment_methods": h.providers.GatewayNames(),
+	})
+}
+
+// CreateSubscriptionOrderRequest 购买订阅请求
+type CreateSubscriptionOrderRequest struct {
+	Plan          string `json:"plan" binding:"required"`
+	PaymentMethod string `json:"payment_method"`
+	CouponCode    string `json:"coupon_code"`
+}
+
+// CreateSubscriptionOrder 为订阅套餐创建待支付订单。订阅只在订单支付成功后开通，
+// 已有未过期订阅时顺延
+func (h *PaymentHandler) CreateSubscriptionOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var req CreateSubscriptionOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	if req.PaymentMethod != "" {
+		if _, ok := h.providers.Gateway(req.PaymentMethod); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的支付方式"})
+			return
+		}
+	}
+
+	offer, err := catalog.FindOffer(h.db, req.Plan)
+	if err != nil && err != catalog.ErrProductNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询套餐失败"})
+		return
+	}
+	if err == catalog.ErrProductNotFound || offer.Kind != catalog.KindSubscription {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的订阅套餐"})
+		return
+	}
+
+	h.createProductOrder(c, userID.(uint), offer, req.PaymentMethod, req.CouponCode)
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProductAdminHandler 管理员维护商品目录
+type ProductAdminHandler struct {
+	db *gorm.DB
+}
+
+func NewProductAdminHandler(db *gorm.DB) *ProductAdminHandler {
+	return &ProductAdminHandler{db: db}
+}
+
+var productTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// ProductInput 创建产品请求
+type ProductInput struct {
+	Type         string   `json:"type" binding:"required"`
+	Kind         string   `json:"kind" binding:"omitempty,oneof=license subscription"` // 默认为 license
+	Name         string   `json:"name" binding:"required"`
+	Description  string   `json:"description"`
+	SortOrder    int      `json:"sort_order"`
+	Active       *bool    `json:"active"`
+	Price        int      `json:"price" binding:"min=1"`
+	QuotaAmount  int      `json:"quota_amount" binding:"min=0"`
+	DurationDays int      `json:"duration_days" binding:"min=0"`
+	Features     []string `json:"features"`
+	Entitlements []string `json:"entitlements"`
+}
+
+// ProductUpdate 修改产品请求。价格、额度、时长、功能说明或权限变化时生成新版本，
+// 已售出的 Key 不受影响。产品种类创建后不能修改
+type ProductUpdate struct {
+	Name         *string   `json:"name"`
+	Description  *string   `json:"description"`
+	SortOrder    *int      `json:"sort_order"`
+	Active       *bool     `json:"active"`
+	Price        *int      `json:"price"`
+	QuotaAmount  *int      `json:"quota_amount"`
+	DurationDays *int      `json:"duration_days"`
+	Features     *[]string `json:"features"`
+	Entitlements *[]string `json:"entitlements"`
+}
+
+// ProductDetail 产品及其全部版本
+type ProductDetail struct {
+	models.Product
+	CurrentVersion *models.ProductVersion  `json:"current_version"`
+	Versions       []models.ProductVersion `json:"versions,omitempty"`
+}
+
+// validateProductTerms License 产品必须有次数额度，订阅套餐必须有时长
+func validateProductTerms(kind string, quotaAmount, durationDays int) string {
+	if kind == catalog.KindSubscription {
+		if durationDays < 1 {
+			return "订阅套餐的时长必须大于 0"
+		}
+		return ""
+	}
+	if quotaAmount < 1 {
+		return "产品的额度必须大于 0"
+	}
+	return ""
+}
+
+// validateEntitlements 权限必须是已知功能，去重后返回
+func validateEntitlements(entitlements []string) ([]string, error) {
+	seen := make(map[string]bool, len(entitlements))
+	result := make([]string, 0, len(entitlements))
+	for _, e := range entitlements {
+		e = strings.TrimSpace(e)
+		if !catalog.IsFeature(e) {
+			return nil, fmt.Errorf("未知的功能权限: %s", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+func cleanFeatures(features []string) []string {
+	result := make([]string, 0, len(features))
+	for _, f := range features {
+		if f = strings.TrimSpace(f); f != "" {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// ListProducts 查询全部产品（包括已下架的）及其当前版本
+func (h *ProductAdminHandler) ListProducts(c *gin.Context) {
+	var products []models.Product
+	if err := h.db.Order("sort_order asc, id asc").Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+		return
+	}
+
+	versionIDs := make([]uint, 0, len(products))
+	for _, product := range products {
+		if product.CurrentVersionID != nil {
+			versionIDs = append(versionIDs, *product.CurrentVersionID)
+		}
+	}
+	versions := map[uint]models.ProductVersion{}
+	if len(versionIDs) > 0 {
+		var rows []models.ProductVersion
+		if err := h.db.Where("id IN ?", versionIDs).Find(&rows).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+			return
+		}
+		for _, version := range rows {
+			versions[version.ID] = version
+		}
+	}
+
+	details := make([]ProductDetail, 0, len(products))
+	for _, product := range products {
+		detail := ProductDetail{Product: product}
+		if product.CurrentVersionID != nil {
+			if version, ok := versions[*product.CurrentVersionID]; ok {
+				detail.CurrentVersion = &version
+			}
+		}
+		details = append(details, detail)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"products": details})
+}
+
+// GetProduct 查询产品详情及全部历史版本
+func (h *ProductAdminHandler) GetProduct(c *gin.Context) {
+	var product models.Product
+	if err := h.db.First(&product, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "产品不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+		}
+		return
+	}
+
+	detail, err := loadProductDetail(h.db, product)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询产品失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"product": detail})
+}
+
+func loadProductDetail(db *gorm.DB, product models.Product) (*ProductDetail, error) {
+	detail := ProductDetail{Product: product}
+	if err := db.Where("product_id = ?", product.ID).Order("version desc").Find(&detail.Versions).Error; err != nil {
+		return nil, err
+	}
+	for i := range detail.Versions {
+		if product.CurrentVersionID != nil && detail.Versions[i].ID == *product.CurrentVersionID {
+			detail.CurrentVersion = &detail.Versions[i]
+		}
+	}
+	return &detail, nil
+}
+
+// CreateProduct 创建产品及其第 1 个版本
+func (h *ProductAdminHandler) CreateProduct(c *gin.Context) {
+	var in ProductInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !productTypePattern.MatchString(in.Type) || in.Type == accountOrderProductType {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "产品类型只能包含小写字母、数字和下划线"})
+		return
+	}
+	if in.Kind == "" {
+		in.Kind = catalog.KindLicense
+	}
+	if msg := validateProductTerms(in.Kind, in.QuotaAmount, in.DurationDays); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	entitlements, err := validateEntitlements(in.Entitlements)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	operatorID := c.GetUint("user_id")
+
+	var detail *ProductDetail
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		product := models.Product{
+			Type:        in.Type,
+			Kind:        in.Kind,
+			Name:        strings.TrimSpace(in.Name),
+			Description: in.Description,
+			SortOrder:   in.SortOrder,
+			Active:      true,
+		}
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		// Active 有数据库默认值，false 需要单独更新
+		if in.Active != nil && !*in.Active {
+			if err := tx.Model(&product).Update("active", false).Error; err != nil {
+				return err
+			}
+		}
+
+		if _, err := publishProductVersion(tx, &product, models.ProductVersion{
+			Price:        in.Price,
+			QuotaAmount:  in.QuotaAmount,
+			DurationDays: in.DurationDays,
+			Features:     cleanFeatures(in.Features),
+			Entitlements: entitlements,
+			CreatedBy:    operatorID,
+		}); err != nil {
+			return err
+		}
+
+		if err := recordAudit(tx, operatorID, "product.create", "product", product.ID, map[string]interface{}{
+			"type":  product.Type,
+			"kind":  product.Kind,
+			"price": in.Price,
+		}); err != nil {
+			return err
+		}
+
+		detail, err = loadProductDetail(tx, product)
+		return err
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "产品类型已存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建产品失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"product": detail})
+}
+
+// UpdateProduct 修改产品。名称、描述、排序和上下架直接修改；
+// 价格、额度、功能说明和权限以当前版本为基础生成新版本
+func (h *ProductAdminHandler) UpdateProduct(c *gin.Context) {
+	var in ProductUpdate
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Name != nil && strings.TrimSpace(*in.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "产品名称不能为空"})
+		return
+	}
+	if in.Price != nil && *in.Price < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "价格必须大于 0"})
+		return
+	}
+	var entitlements []string
+	if in.Entitlements != nil {
+		var err error
+		if entitlements, err = validateEntitlements(*in.Entitlements); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	operatorID := c.GetUint("user_id")
+
+	var (
+		detail  *ProductDetail
+		message string
+	)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, c.Param("id")).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if in.Name != nil {
+			updates["name"] = strings.TrimSpace(*in.Name)
+		}
+		if in.Description != nil {
+			updates["description"] = *in.Description
+		}
+		if in.SortOrder != nil {
+			updates["sort_order"] = *in.SortOrder
+		}
+		if in.Active != nil {
+			updates["active"] = *in.Active
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&product).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		var current models.ProductVersion
+		if product.CurrentVersionID != nil {
+			if err := tx.First(&current, *product.CurrentVersionID).Error; err != nil {
+				return err
+			}
+		}
+		next := models.ProductVersion{
+			Price:        current.Price,
+			QuotaAmount:  current.QuotaAmount,
+			DurationDays: current.DurationDays,
+			Features:     current.Features,
+			Entitlements: current.Entitlements,
+			CreatedBy:    operatorID,
+		}
+		if in.Price != nil {
+			next.Price = *in.Price
+		}
+		if in.QuotaAmount != nil {
+			next.QuotaAmount = *in.QuotaAmount
+		}
+		if in.DurationDays != nil {
+			next.DurationDays = *in.DurationDays
+		}
+		if msg := validateProductTerms(product.Kind, next.QuotaAmount, next.DurationDays); msg != "" {
+			message = msg
+			return errInvalidInput
+		}
+		if in.Features != nil {
+			next.Features = cleanFeatures(*in.Features)
+		}
+		if in.Entitlements != nil {
+			next.Entitlements = entitlements
+		}
+
+		var version *models.ProductVersion
+		if product.CurrentVersionID == nil || versionChanged(current, next) {
+			var err error
+			if version, err = publishProductVersion(tx, &product, next); err != nil {
+				return err
+			}
+		}
+
+		metadata := map[string]interface{}{"fields": updates}
+		if version != nil {
+			metadata["version"] = version.Version
+			metadata["price"] = version.Price
+			metadata["quota_amount"] = version.QuotaAmount
+			metadata["duration_days"] = version.DurationDays
+			metadata["entitlements"] = version.Entitlements
+		}
+		if err := recordAudit(tx, operatorID, "product.update", "product", product.ID, metadata); err != nil {
+			return err
+		}
+
+		var err error
+		detail, err = loadProductDetail(tx, product)
+		return err
+	})
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"product": detail})
+	case errors.Is(err, errInvalidInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "产品不存在"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新产品失败"})
+	}
+}
+
+// versionChanged 判断新版本与当前版本的价格和权益是否不同
+func versionChanged(current, next models.ProductVersion) bool {
+	return current.Price != next.Price ||
+		current.QuotaAmount != next.QuotaAmount ||
+		current.DurationDays != next.DurationDays ||
+		strings.Join(current.Features, "\n") != strings.Join(next.Features, "\n") ||
+		strings.Join(current.Entitlements, ",") != strings.Join(next.Entitlements, ",")
+}
+
+// publishProductVersion 为产品创建下一个版本并设为当前版本，之后的新订单使用该版本
+func publishProductVersion(tx *gorm.DB, product *models.Product, version models.ProductVersion) (*models.ProductVersion, error) {
+	var latest int
+	if err := tx.Model(&models.ProductVersion{}).
+		Where("product_id = ?", product.ID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+
+	version.ProductID = product.ID
+	version.Version = latest + 1
+	if version.Features == nil {
+		version.Features = []string{}
+	}
+	if version.Entitlements == nil {
+		version.Entitlements = []string{}
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(product).Update("current_version_id", version.ID).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// createTestProduct 通过 CreateProduct 创建产品，类型、名称和价格未指定时自动填充，
+// 测试结束时连同全部版本一起删除
+func createTestProduct(t *testing.T, db *gorm.DB, in ProductInput) ProductDetail {
+	t.Helper()
+	if in.Type == "" {
+		in.Type = "test_" + uniqueSuffix()
+	}
+	if in.Name == "" {
+		in.Name = "Test"
+	}
+	if in.Price == 0 {
+		in.Price = 1000
+	}
+	h := NewProductAdminHandler(db)
+	w := performAs(t, 0, nil, h.CreateProduct, http.MethodPost, "/admin/products", "/admin/products", in)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create product: status = %d, body = %s", w.Code, w.Body)
+	}
+	var response struct {
+		Product ProductDetail `json:"product"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	product := response.Product
+	t.Cleanup(func() {
+		db.Model(&models.Product{}).Where("id = ?", product.ID).Update("current_version_id", nil)
+		db.Where("product_id = ?", product.ID).Delete(&models.ProductVersion{})
+		db.Delete(&models.Product{}, product.ID)
+	})
+	return product
+}
+
+// buyTestProduct 下单并支付产品，返回已支付的订单
+func buyTestProduct(t *testing.T, db *gorm.DB, userID uint, productType string) models.Payment {
+	t.Helper()
+	h := newTestPaymentHandler(t, db)
+	w := performAs(t, userID, nil, h.CreateOrder, http.MethodPost, "/payments/orders", "/payments/orders",
+		CreateOrderRequest{ProductType: productType})
+	if w.Code != http.StatusOK {
+		t.Fatalf("order %s: status = %d, body = %s", productType, w.Code, w.Body)
+	}
+	var response struct {
+		Order models.Payment `json:"order"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	paySuccess(t, newNotifyRouter(t, db), response.Order)
+	return response.Order
+}
+
+// buyTestKey 下单并支付 License 产品，返回发放的 License Key
+func buyTestKey(t *testing.T, db *gorm.DB, userID uint, productType string) models.LicenseKey {
+	t.Helper()
+	order := buyTestProduct(t, db, userID, productType)
+	var key models.LicenseKey
+	if err := db.Where("payment_id = ?", order.ID).First(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func keyEntitlements(t *testing.T, db *gorm.DB, key models.LicenseKey) []string {
+	t.Helper()
+	entitlements, err := catalog.KeyEntitlements(db, &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entitlements
+}
+
+func TestKeyEntitlementsPinnedToPurchasedVersion(t *testing.T) {
+	db := openTestDB(t)
+	user := createTestUser(t, db)
+	product := createTestProduct(t, db, ProductInput{QuotaAmount: 100, Entitlements: []string{"email_verify"}})
+
+	oldKey := buyTestKey(t, db, user.ID, product.Type)
+	if oldKey.ProductVersionID == nil || *oldKey.ProductVersionID != product.CurrentVersion.ID {
+		t.Fatalf("key version = %v, want %d", oldKey.ProductVersionID, product.CurrentVersion.ID)
+	}
+
+	// 修改权限生成新版本，已售出的 Key 仍使用购买时的版本
+	admin := NewProductAdminHandler(db)
+	entitlements := []string{"email_verify", "api_access"}
+	w := performAs(t, 0, nil, admin.UpdateProduct, http.MethodPut, "/admin/products/:id",
+		fmt.Sprintf("/admin/products/%d", product.ID), ProductUpdate{Entitlements: &entitlements})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update product: status = %d, body = %s", w.Code, w.Body)
+	}
+	newKey := buyTestKey(t, db, user.ID, product.Type)
+	if newKey.ProductVersionID == nil || *newKey.ProductVersionID == *oldKey.ProductVersionID {
+		t.Fatalf("new key version = %v, want a new version", newKey.ProductVersionID)
+	}
+
+	if got := keyEntitlements(t, db, oldKey); !reflect.DeepEqual(got, []string{"email_verify"}) {
+		t.Errorf("old key entitlements = %v", got)
+	}
+	if got := keyEntitlements(t, db, newKey); !reflect.DeepEqual(got, entitlements) {
+		t.Errorf("new key entitlements = %v, want %v", got, entitlements)
+	}
+	if ok, err := catalog.HasFeature(db, &oldKey, "api_access"); err != nil || ok {
+		t.Errorf("old key has api_access = %v, %v", ok, err)
+	}
+
+	// 商品目录之前发放、没有记录版本的 Key 使用第 1 个版本
+	legacy := createTestLicenseKey(t, db, user.ID, 100)
+	if err := db.Model(&legacy).Update("product_type", product.Type).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := keyEntitlements(t, db, legacy); !reflect.DeepEqual(got, []string{"email_verify"}) {
+		t.Errorf("legacy key entitlements = %v", got)
+	}
+}
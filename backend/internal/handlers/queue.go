@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"fullstack-backend/internal/models"
+	"fullstack-backend/internal/queue"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 队列任务类型
+const (
+	JobTypeEmailReverify = "email.reverify_schedule"
+	JobTypeEmailImport   = "email.import"
+	JobTypeEmailVerify   = "email.verify"
+)
+
+// RegisterQueueHandlers 为工作进程注册所有任务类型，API 进程和独立 worker 共用
+func RegisterQueueHandlers(w *queue.Worker, emailHandler *EmailHandler) {
+	w.Handle(JobTypeEmailReverify, emailHandler.ProcessReverifyJob)
+	w.Handle(JobTypeEmailImport, emailHandler.ProcessEmailJob)
+	w.Handle(JobTypeEmailVerify, emailHandler.ProcessEmailJob)
+}
+
+type QueueHandler struct {
+	db *gorm.DB
+}
+
+func NewQueueHandler(db *gorm.DB) *QueueHandler {
+	return &QueueHandler{db: db}
+}
+
+type queueStat struct {
+	Queue  string `json:"queue"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// GetQueueStats 按队列和状态统计任务数量
+func (h *QueueHandler) GetQueueStats(c *gin.Context) {
+	var stats []queueStat
+	if err := h.db.Model(&models.QueueJob{}).
+		Select("queue, status, COUNT(*) as count").
+		Group("queue, status").
+		Order("queue, status").
+		Scan(&stats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询队列统计失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+// ListQueueJobs 查询队列任务，默认列出死信任务
+func (h *QueueHandler) ListQueueJobs(c *gin.Context) {
+	status := c.DefaultQuery("status", queue.StatusDead)
+
+	query := h.db.Where("status = ?", status).Order("updated_at desc").Limit(100)
+	if name := c.Query("queue"); name != "" {
+		query = query.Where("queue = ?", name)
+	}
+	if jobType := c.Query("type"); jobType != "" {
+		query = query.Where("type = ?", jobType)
+	}
+
+	var jobs []models.QueueJob
+	if err := query.Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询队列任务失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// RetryQueueJob 将死信任务重新入队
+func (h *QueueHandler) RetryQueueJob(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务 ID"})
+		return
+	}
+
+	retried, err := queue.Retry(h.db, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新入队失败"})
+		return
+	}
+	if !retried {
+		c.JSON(http.StatusNotFound, gin.H{"error": "死信任务不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "任务已重新入队"})
+}
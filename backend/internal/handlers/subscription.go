@@ -1,28 +1,46 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/config"
 	"fullstack-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// SubscriptionHandler 订阅查询、试用、取消续费和管理员赠送，以及订阅到期和自动续费的后台任务
 type SubscriptionHandler struct {
-	db *gorm.DB
+	db             *gorm.DB
+	trialDays      int
+	trialPlan      string
+	gracePeriod    time.Duration
+	renewalLead    time.Duration
+	reminderBefore time.Duration
 }
 
-func NewSubscriptionHandler(db *gorm.DB) *SubscriptionHandler {
-	return &SubscriptionHandler{db: db}
+func NewSubscriptionHandler(db *gorm.DB, cfg *config.Config) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		db:             db,
+		trialDays:      cfg.SubscriptionTrialDays,
+		trialPlan:      cfg.SubscriptionTrialPlan,
+		gracePeriod:    cfg.SubscriptionGracePeriod,
+		renewalLead:    cfg.SubscriptionRenewalLead,
+		reminderBefore: cfg.SubscriptionReminderBefore,
+	}
 }
 
-type ActivateSubscriptionRequest struct {
-	Plan         string `json:"plan"`
-	DurationDays int    `json:"duration_days"`
-}
+// liveSubscriptionStatuses 尚未结束的订阅状态：active 包括尚未开始的后续各期，past_due 为宽限期
+var liveSubscriptionStatuses = []string{"active", "past_due"}
 
+// GetMySubscription 返回当前生效的一期订阅（没有时返回最近的一期）、已付费的截止时间以及能否试用
 func (h *SubscriptionHandler) GetMySubscription(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -30,67 +48,378 @@ func (h *SubscriptionHandler) GetMySubscription(c *gin.Context) {
 		return
 	}
 
+	now := time.Now()
 	var sub models.Subscription
-	if err := h.db.Where("user_id = ?", userID).Order("expires_at desc").First(&sub).Error; err != nil {
+	err := h.db.Where("user_id = ? AND status IN ? AND starts_at <= ? AND (expires_at > ? OR grace_ends_at > ?)",
+		userID, liveSubscriptionStatuses, now, now, now).
+		Order("expires_at desc").
+		First(&sub).Error
+	if err == gorm.ErrRecordNotFound {
+		err = h.db.Where("user_id = ?", userID).Order("expires_at desc").First(&sub).Error
+	}
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusOK, gin.H{"subscription": nil})
+			c.JSON(http.StatusOK, gin.H{"subscription": nil, "trial_available": h.trialDays > 0})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订阅失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"subscription": sub})
+	latest, err := latestSubscription(h.db, sub.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询订阅失败"})
+		return
+	}
+	var paidUntil *time.Time
+	if latest != nil {
+		paidUntil = &latest.ExpiresAt
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription": sub, "paid_until": paidUntil, "trial_available": false})
+}
+
+// latestSubscription 用户尚未结束的订阅中最晚到期的一期，没有时返回 nil
+func latestSubscription(db *gorm.DB, userID uint) (*models.Subscription, error) {
+	var latest models.Subscription
+	if err := db.Where("user_id = ? AND status IN ?", userID, liveSubscriptionStatuses).
+		Order("expires_at desc").
+		First(&latest).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &latest, nil
+}
+
+// lockUser 锁定用户行，串行化同一用户的订阅变更
+func lockUser(tx *gorm.DB, userID uint) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		First(&models.User{}, userID).Error
+}
+
+// grantSubscription 为用户开通一期订阅，tx 需为事务。已有未结束的订阅时新一期从其结束时开始
+// （宽限期内续费从原到期时间接续），否则立即生效。paymentID 为空表示管理员赠送或试用。
+func grantSubscription(tx *gorm.DB, userID uint, plan string, durationDays int, paymentID *uint) (*models.Subscription, error) {
+	if durationDays <= 0 {
+		return nil, fmt.Errorf("subscription plan %s has no duration", plan)
+	}
+
+	// 锁定用户，防止同一用户并发开通的两期订阅时间重叠
+	if err := lockUser(tx, userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	start := now
+	latest, err := latestSubscription(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && (latest.ExpiresAt.After(now) || latest.Status == "past_due") {
+		start = latest.ExpiresAt
+	}
+	if err := supersedeSubscriptions(tx, userID, paymentID); err != nil {
+		return nil, err
+	}
+
+	sub := models.Subscription{
+		UserID:    userID,
+		Plan:      plan,
+		StartsAt:  start,
+		ExpiresAt: start.AddDate(0, 0, durationDays),
+		Status:    "active",
+		PaymentID: paymentID,
+	}
+	if err := tx.Create(&sub).Error; err != nil {
+		return nil, err
+	}
+
+	if err := notifyUser(tx, userID, "subscription.activated", "订阅已开通",
+		fmt.Sprintf("%s 已开通，有效期至 %s。", catalog.ProductName(tx, plan), sub.ExpiresAt.Format("2006-01-02 15:04"))); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// supersedeSubscriptions 新的一期开通后，之前各期不再需要宽限期和续费订单：
+// 宽限期中的一期结束，其余尚未支付的续费订单取消
+func supersedeSubscriptions(tx *gorm.DB, userID uint, paymentID *uint) error {
+	var subs []models.Subscription
+	if err := tx.Where("user_id = ? AND status IN ?", userID, liveSubscriptionStatuses).Find(&subs).Error; err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		updates := map[string]interface{}{"grace_ends_at": nil}
+		if sub.Status == "past_due" {
+			updates["status"] = "expired"
+		}
+		if err := tx.Model(&sub).Updates(updates).Error; err != nil {
+			return err
+		}
+		if sub.RenewalPaymentID != nil && (paymentID == nil || *sub.RenewalPaymentID != *paymentID) {
+			if err := cancelRenewalOrder(tx, *sub.RenewalPaymentID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cancelRenewalOrder 取消尚未支付的自动续费订单
+func cancelRenewalOrder(tx *gorm.DB, paymentID uint) error {
+	var order models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, paymentID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if order.Status != "pending" {
+		return nil
+	}
+	return cancelPayment(tx, &order)
+}
+
+// StartTrial 开通免费试用。每个用户只能试用一次，且只限从未订阅过的用户
+func (h *SubscriptionHandler) StartTrial(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+	if h.trialDays <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "暂未开放试用"})
+		return
+	}
+
+	var sub *models.Subscription
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID.(uint)); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Subscription{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errInvalidInput
+		}
+
+		var err error
+		if sub, err = grantSubscription(tx, userID.(uint), h.trialPlan, h.trialDays, nil); err != nil {
+			return err
+		}
+		sub.Trial = true
+		if err := tx.Model(sub).Update("trial", true).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, userID.(uint), "subscription.trial", "subscription", sub.ID, map[string]interface{}{
+			"plan":          h.trialPlan,
+			"duration_days": h.trialDays,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidInput) {
+			c.JSON(http.StatusConflict, gin.H{"error": "已订阅过的用户不能试用"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "开通试用失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"subscription": sub})
 }
 
-func (h *SubscriptionHandler) ActivateSubscription(c *gin.Context) {
+// CancelSubscription 取消自动续费。已付费的各期到期前仍可使用，尚未支付的续费订单被取消，
+// 宽限期中的订阅立即结束
+func (h *SubscriptionHandler) CancelSubscription(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
 		return
 	}
 
-	var req ActivateSubscriptionRequest
+	var latest *models.Subscription
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID.(uint)); err != nil {
+			return err
+		}
+		var subs []models.Subscription
+		if err := tx.Where("user_id = ? AND status IN ? AND auto_renew = ?", userID, liveSubscriptionStatuses, true).
+			Find(&subs).Error; err != nil {
+			return err
+		}
+		if len(subs) == 0 {
+			return errInvalidInput
+		}
+
+		now := time.Now()
+		ids := make([]uint, 0, len(subs))
+		for _, sub := range subs {
+			updates := map[string]interface{}{"auto_renew": false, "canceled_at": now, "grace_ends_at": nil}
+			if sub.Status == "past_due" {
+				updates["status"] = "canceled"
+			}
+			if err := tx.Model(&sub).Updates(updates).Error; err != nil {
+				return err
+			}
+			if sub.RenewalPaymentID != nil {
+				if err := cancelRenewalOrder(tx, *sub.RenewalPaymentID); err != nil {
+					return err
+				}
+			}
+			ids = append(ids, sub.ID)
+		}
+
+		if err := recordAudit(tx, userID.(uint), "subscription.cancel", "user", userID.(uint), map[string]interface{}{
+			"subscription_ids": ids,
+		}); err != nil {
+			return err
+		}
+
+		var err error
+		latest, err = latestSubscription(tx, userID.(uint))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidInput) {
+			c.JSON(http.StatusConflict, gin.H{"error": "没有可取消的订阅"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "取消订阅失败"})
+		}
+		return
+	}
+
+	response := gin.H{"subscription": latest, "access_until": nil}
+	if latest != nil {
+		response["access_until"] = latest.ExpiresAt
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ResumeSubscription 恢复已取消但尚未到期的订阅的自动续费
+func (h *SubscriptionHandler) ResumeSubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		return
+	}
+
+	var latest *models.Subscription
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userID.(uint)); err != nil {
+			return err
+		}
+		result := tx.Model(&models.Subscription{}).
+			Where("user_id = ? AND status = ? AND auto_renew = ? AND expires_at > ?", userID, "active", false, time.Now()).
+			Updates(map[string]interface{}{"auto_renew": true, "canceled_at": nil, "renewal_payment_id": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidInput
+		}
+
+		if err := recordAudit(tx, userID.(uint), "subscription.resume", "user", userID.(uint), nil); err != nil {
+			return err
+		}
+
+		var err error
+		latest, err = latestSubscription(tx, userID.(uint))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidInput) {
+			c.JSON(http.StatusConflict, gin.H{"error": "没有可恢复的订阅"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复订阅失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription": latest})
+}
+
+// GrantSubscriptionRequest 管理员赠送订阅
+type GrantSubscriptionRequest struct {
+	Plan         string `json:"plan" binding:"required"`
+	DurationDays int    `json:"duration_days"` // 为空时使用套餐当前版本的时长
+	Reason       string `json:"reason"`
+}
+
+// GrantSubscription 管理员为用户开通订阅（补偿、试用等），路由为 /admin/users/:id/subscriptions
+func (h *SubscriptionHandler) GrantSubscription(c *gin.Context) {
+	var req GrantSubscriptionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	if req.DurationDays <= 0 {
-		req.DurationDays = 30
+	if req.DurationDays < 0 || req.DurationDays > 3660 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "订阅时长无效"})
+		return
 	}
-	if req.Plan == "" {
-		req.Plan = "monthly"
+	operatorID := c.GetUint("user_id")
+
+	var user models.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
+		}
+		return
 	}
 
-	now := time.Now()
-	var sub models.Subscription
-	if err := h.db.Where("user_id = ? AND status = ?", userID, "active").Order("expires_at desc").First(&sub).Error; err == nil {
-		if sub.ExpiresAt.After(now) {
-			sub.ExpiresAt = sub.ExpiresAt.Add(time.Duration(req.DurationDays) * 24 * time.Hour)
+	// 套餐必须是商品目录中的订阅产品，已下架的套餐也可以赠送
+	var product models.Product
+	if err := h.db.Where("type = ? AND kind = ?", strings.TrimSpace(req.Plan), catalog.KindSubscription).
+		First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "订阅套餐不存在"})
 		} else {
-			sub.ExpiresAt = now.Add(time.Duration(req.DurationDays) * 24 * time.Hour)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询套餐失败"})
 		}
-		sub.Plan = req.Plan
-		sub.Status = "active"
-		if err := h.db.Save(&sub).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新订阅失败"})
+		return
+	}
+	duration := req.DurationDays
+	if duration == 0 && product.CurrentVersionID != nil {
+		offer, err := catalog.VersionOffer(h.db, *product.CurrentVersionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询套餐失败"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"subscription": sub})
+		duration = offer.DurationDays
+	}
+	if duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "订阅时长无效"})
 		return
 	}
 
-	sub = models.Subscription{
-		UserID:    userID.(uint),
-		Plan:      req.Plan,
-		ExpiresAt: now.Add(time.Duration(req.DurationDays) * 24 * time.Hour),
-		Status:    "active",
-	}
-	if err := h.db.Create(&sub).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建订阅失败"})
+	var sub *models.Subscription
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if sub, err = grantSubscription(tx, user.ID, product.Type, duration, nil); err != nil {
+			return err
+		}
+		return recordAudit(tx, operatorID, "subscription.grant", "subscription", sub.ID, map[string]interface{}{
+			"user_id":       user.ID,
+			"plan":          product.Type,
+			"duration_days": duration,
+			"reason":        req.Reason,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "开通订阅失败"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"subscription": sub})
+	c.JSON(http.StatusCreated, gin.H{"subscription": sub})
 }
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// latestPeriodCondition 只处理用户最后一期订阅：之后已有续费的一期时，提醒和续费订单都交给后一期
+const latestPeriodCondition = `NOT EXISTS (
+	SELECT 1 FROM subscriptions later
+	WHERE later.user_id = subscriptions.user_id AND later.status = 'active' AND later.expires_at > subscriptions.expires_at)`
+
+// ExpireSubscriptions 处理到期的订阅（由后台任务调用）：续费订单未支付的进入宽限期（past_due），
+// 宽限期结束或没有宽限期的订阅结束，用户取消续费的记为 canceled，其余记为 expired
+func (h *SubscriptionHandler) ExpireSubscriptions(ctx context.Context) error {
+	return h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var subs []models.Subscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND expires_at <= ?) OR (status = ? AND (grace_ends_at IS NULL OR grace_ends_at <= ?))",
+				"active", now, "past_due", now).
+			Limit(200).
+			Find(&subs).Error; err != nil {
+			return err
+		}
+
+		for i := range subs {
+			if err := expireSubscription(tx, &subs[i], now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func expireSubscription(tx *gorm.DB, sub *models.Subscription, now time.Time) error {
+	// 已续费的一期到期时无需通知，直接衔接下一期
+	var renewed int64
+	if err := tx.Model(&models.Subscription{}).
+		Where("user_id = ? AND status = ? AND expires_at > ?", sub.UserID, "active", sub.ExpiresAt).
+		Count(&renewed).Error; err != nil {
+		return err
+	}
+
+	var status, title, body string
+	switch {
+	case sub.Status == "active" && sub.GraceEndsAt != nil && sub.GraceEndsAt.After(now):
+		status, title = "past_due", "订阅已到期"
+		body = fmt.Sprintf("续费订单尚未支付，订阅将保留至 %s，请在此之前完成支付。", sub.GraceEndsAt.Format("2006-01-02 15:04"))
+	case sub.CanceledAt != nil:
+		status, title = "canceled", "订阅已结束"
+		body = "你已取消自动续费，订阅已于 " + sub.ExpiresAt.Format("2006-01-02 15:04") + " 结束。"
+	default:
+		status, title = "expired", "订阅已过期"
+		body = "订阅已过期，续费后即可恢复访问。"
+	}
+
+	if err := tx.Model(sub).Update("status", status).Error; err != nil {
+		return err
+	}
+	if renewed > 0 {
+		return nil
+	}
+	return notifyUser(tx, sub.UserID, "subscription."+status, title, body)
+}
+
+// RenewSubscriptions 发送到期提醒并为即将到期、开启自动续费的订阅生成续费订单（由后台任务调用）
+func (h *SubscriptionHandler) RenewSubscriptions(ctx context.Context) error {
+	if err := h.sendRenewalReminders(ctx); err != nil {
+		return err
+	}
+	return h.createRenewalOrders(ctx)
+}
+
+// sendRenewalReminders 到期前 reminderBefore 提醒一次。试用期较短，由续费订单通知代替
+func (h *SubscriptionHandler) sendRenewalReminders(ctx context.Context) error {
+	return h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var subs []models.Subscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND trial = ? AND reminder_sent_at IS NULL AND expires_at > ? AND expires_at <= ?",
+				"active", false, now, now.Add(h.reminderBefore)).
+			Where(latestPeriodCondition).
+			Limit(200).
+			Find(&subs).Error; err != nil {
+			return err
+		}
+
+		for _, sub := range subs {
+			expiresAt := sub.ExpiresAt.Format("2006-01-02 15:04")
+			body := fmt.Sprintf("订阅将于 %s 到期，到期前会自动生成续费订单。", expiresAt)
+			if !sub.AutoRenew {
+				body = fmt.Sprintf("订阅将于 %s 到期。你已取消自动续费，到期后将停止访问。", expiresAt)
+			}
+			if err := notifyUser(tx, sub.UserID, "subscription.reminder", "订阅即将到期", body); err != nil {
+				return err
+			}
+			if err := tx.Model(&sub).Update("reminder_sent_at", now).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createRenewalOrders 到期前 renewalLead 为最后一期订阅生成续费订单，订单有效期到宽限期结束
+func (h *SubscriptionHandler) createRenewalOrders(ctx context.Context) error {
+	return h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var subs []models.Subscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND auto_renew = ? AND renewal_payment_id IS NULL AND expires_at > ? AND expires_at <= ?",
+				"active", true, now, now.Add(h.renewalLead)).
+			Where(latestPeriodCondition).
+			Limit(100).
+			Find(&subs).Error; err != nil {
+			return err
+		}
+
+		for i := range subs {
+			if err := h.createRenewalOrder(tx, &subs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (h *SubscriptionHandler) createRenewalOrder(tx *gorm.DB, sub *models.Subscription) error {
+	offer, err := catalog.FindOffer(tx, sub.Plan)
+	if err != nil && err != catalog.ErrProductNotFound {
+		return err
+	}
+	if err == catalog.ErrProductNotFound || offer.Kind != catalog.KindSubscription {
+		// 套餐已下架，不再自动续费
+		if err := tx.Model(sub).Update("auto_renew", false).Error; err != nil {
+			return err
+		}
+		return notifyUser(tx, sub.UserID, "subscription.reminder", "订阅无法自动续费",
+			fmt.Sprintf("当前订阅套餐已下架，订阅将于 %s 到期，请选择其他套餐续费。", sub.ExpiresAt.Format("2006-01-02 15:04")))
+	}
+
+	// 试用没有宽限期，到期前未支付即结束
+	deadline := sub.ExpiresAt
+	updates := map[string]interface{}{}
+	if !sub.Trial && h.gracePeriod > 0 {
+		deadline = sub.ExpiresAt.Add(h.gracePeriod)
+		updates["grace_ends_at"] = deadline
+	}
+
+	order := models.Payment{
+		UserID:           sub.UserID,
+		OrderNo:          generateOrderNo(),
+		Amount:           offer.Price,
+		ProductType:      offer.Type,
+		QuotaAmount:      offer.QuotaAmount,
+		ProductVersionID: &offer.VersionID,
+		Status:           "pending",
+		ExpiredAt:        deadline,
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return err
+	}
+
+	updates["renewal_payment_id"] = order.ID
+	if err := tx.Model(sub).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	return notifyUser(tx, sub.UserID, "subscription.renewal", "续费订单已生成",
+		fmt.Sprintf("%s 将于 %s 到期，续费订单 %s（%s 元）已生成，请在 %s 前完成支付。",
+			offer.Name, sub.ExpiresAt.Format("2006-01-02 15:04"), order.OrderNo, formatYuan(order.Amount),
+			deadline.Format("2006-01-02 15:04")))
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/catalog"
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const testGracePeriod = 72 * time.Hour
+
+// newTestSubscriptionHandler 宽限期 3 天，到期前 2 天生成续费订单，不开放试用
+func newTestSubscriptionHandler(db *gorm.DB) *SubscriptionHandler {
+	return NewSubscriptionHandler(db, &config.Config{
+		SubscriptionGracePeriod:    testGracePeriod,
+		SubscriptionRenewalLead:    48 * time.Hour,
+		SubscriptionReminderBefore: 7 * 24 * time.Hour,
+	})
+}
+
+// createTestPlan 创建 30 天的订阅套餐
+func createTestPlan(t *testing.T, db *gorm.DB) ProductDetail {
+	t.Helper()
+	return createTestProduct(t, db, ProductInput{Kind: catalog.KindSubscription, Price: 2900, DurationDays: 30})
+}
+
+// buyTestSubscription 通过 CreateSubscriptionOrder 下单并支付，返回开通的一期订阅
+func buyTestSubscription(t *testing.T, db *gorm.DB, userID uint, plan string) models.Subscription {
+	t.Helper()
+	h := newTestPaymentHandler(t, db)
+	w := performAs(t, userID, nil, h.CreateSubscriptionOrder, http.MethodPost, "/subscriptions/orders", "/subscriptions/orders",
+		CreateSubscriptionOrderRequest{Plan: plan})
+	if w.Code != http.StatusOK {
+		t.Fatalf("subscription order: status = %d, body = %s", w.Code, w.Body)
+	}
+	var response struct {
+		Order models.Payment `json:"order"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	paySuccess(t, newNotifyRouter(t, db), response.Order)
+	return subscriptionFor(t, db, response.Order.ID)
+}
+
+func subscriptionFor(t *testing.T, db *gorm.DB, paymentID uint) models.Subscription {
+	t.Helper()
+	var sub models.Subscription
+	if err := db.Where("payment_id = ?", paymentID).First(&sub).Error; err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func reloadSubscription(t *testing.T, db *gorm.DB, sub *models.Subscription) {
+	t.Helper()
+	if err := db.First(sub, sub.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expireIn 将订阅的到期时间移到 d 之后（d 为负数时已到期）
+func expireIn(t *testing.T, db *gorm.DB, sub *models.Subscription, d time.Duration) {
+	t.Helper()
+	if err := db.Model(sub).Update("expires_at", time.Now().Add(d)).Error; err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, sub)
+}
+
+// renewalOrder 续费任务为订阅生成的订单
+func renewalOrder(t *testing.T, db *gorm.DB, sub models.Subscription) models.Payment {
+	t.Helper()
+	if sub.RenewalPaymentID == nil {
+		t.Fatalf("subscription %d has no renewal order", sub.ID)
+	}
+	var order models.Payment
+	if err := db.First(&order, *sub.RenewalPaymentID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestBuySubscriptionPlan(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestPaymentHandler(t, db)
+	plan := createTestPlan(t, db)
+	license := createTestProduct(t, db, ProductInput{QuotaAmount: 100})
+	user := createTestUser(t, db)
+
+	// 只有订阅套餐可以通过订阅接口下单
+	w := performAs(t, user.ID, nil, h.CreateSubscriptionOrder, http.MethodPost, "/subscriptions/orders", "/subscriptions/orders",
+		CreateSubscriptionOrderRequest{Plan: license.Type})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("license product as plan: status = %d, want 400", w.Code)
+	}
+
+	// 支付后开通订阅而不是发放 License Key
+	first := buyTestSubscription(t, db, user.ID, plan.Type)
+	if first.Status != "active" || first.Plan != plan.Type || first.PaymentID == nil {
+		t.Fatalf("subscription = %+v", first)
+	}
+	if days := first.ExpiresAt.Sub(first.StartsAt).Hours() / 24; days < 29 || days > 31 {
+		t.Errorf("period = %.1f days, want 30", days)
+	}
+	var keys int64
+	db.Model(&models.LicenseKey{}).Where("payment_id = ?", *first.PaymentID).Count(&keys)
+	if keys != 0 {
+		t.Errorf("license keys = %d, want 0", keys)
+	}
+
+	// 再次购买时新一期接在上一期之后
+	second := buyTestSubscription(t, db, user.ID, plan.Type)
+	if !second.StartsAt.Equal(first.ExpiresAt) {
+		t.Errorf("second period starts at %s, want %s", second.StartsAt, first.ExpiresAt)
+	}
+}
+
+func TestSubscriptionRenewalOrderExtendsSubscription(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestSubscriptionHandler(db)
+	plan := createTestPlan(t, db)
+	user := createTestUser(t, db)
+
+	sub := buyTestSubscription(t, db, user.ID, plan.Type)
+	if sub.Status != "active" || !sub.AutoRenew || sub.Plan != plan.Type {
+		t.Fatalf("subscription = %+v", sub)
+	}
+
+	// 到期前 2 天内生成续费订单，有效期到宽限期结束
+	expireIn(t, db, &sub, 24*time.Hour)
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	order := renewalOrder(t, db, sub)
+	if order.Status != "pending" || order.Amount != 2900 || order.ProductType != plan.Type {
+		t.Errorf("renewal order = %+v", order)
+	}
+	if sub.GraceEndsAt == nil || !sub.GraceEndsAt.Equal(sub.ExpiresAt.Add(testGracePeriod)) || !order.ExpiredAt.Equal(*sub.GraceEndsAt) {
+		t.Errorf("grace_ends_at = %v, order expired_at = %v, expires_at = %v", sub.GraceEndsAt, order.ExpiredAt, sub.ExpiresAt)
+	}
+
+	// 再次运行不会重复生成
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var orders int64
+	db.Model(&models.Payment{}).Where("user_id = ? AND status = ?", user.ID, "pending").Count(&orders)
+	if orders != 1 {
+		t.Errorf("pending orders = %d, want 1", orders)
+	}
+
+	// 支付后新一期从上一期结束时开始
+	paySuccess(t, newNotifyRouter(t, db), order)
+	next := subscriptionFor(t, db, order.ID)
+	if !next.StartsAt.Equal(sub.ExpiresAt) || !next.ExpiresAt.Equal(sub.ExpiresAt.AddDate(0, 0, 30)) {
+		t.Errorf("next period = %s - %s, previous expires_at = %s", next.StartsAt, next.ExpiresAt, sub.ExpiresAt)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.GraceEndsAt != nil || sub.Status != "active" {
+		t.Errorf("previous period after renewal = %+v", sub)
+	}
+}
+
+func TestSubscriptionGracePeriod(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestSubscriptionHandler(db)
+	plan := createTestPlan(t, db)
+	user := createTestUser(t, db)
+
+	sub := buyTestSubscription(t, db, user.ID, plan.Type)
+	expireIn(t, db, &sub, time.Hour)
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	order := renewalOrder(t, db, sub)
+
+	// 续费订单未支付时到期进入宽限期，仍然可以访问
+	expireIn(t, db, &sub, -time.Minute)
+	if err := h.ExpireSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.Status != "past_due" {
+		t.Fatalf("status after expiry = %s, want past_due", sub.Status)
+	}
+	w := performAs(t, user.ID, nil, h.GetMySubscription, http.MethodGet, "/subscriptions/me", "/subscriptions/me", nil)
+	var me struct {
+		Subscription *models.Subscription `json:"subscription"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &me); err != nil {
+		t.Fatal(err)
+	}
+	if me.Subscription == nil || me.Subscription.ID != sub.ID || me.Subscription.Status != "past_due" {
+		t.Errorf("current subscription during grace = %+v", me.Subscription)
+	}
+
+	// 宽限期内支付，新一期从原到期时间接续，宽限期中的一期结束
+	paySuccess(t, newNotifyRouter(t, db), order)
+	next := subscriptionFor(t, db, order.ID)
+	if !next.StartsAt.Equal(sub.ExpiresAt) || next.Status != "active" {
+		t.Errorf("next period = %+v, previous expires_at = %s", next, sub.ExpiresAt)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.Status != "expired" || sub.GraceEndsAt != nil {
+		t.Errorf("grace period after payment: status = %s, grace_ends_at = %v", sub.Status, sub.GraceEndsAt)
+	}
+}
+
+func TestSubscriptionGracePeriodEnds(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestSubscriptionHandler(db)
+	plan := createTestPlan(t, db)
+	user := createTestUser(t, db)
+
+	sub := buyTestSubscription(t, db, user.ID, plan.Type)
+	expireIn(t, db, &sub, time.Hour)
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expireIn(t, db, &sub, -time.Minute)
+	if err := h.ExpireSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 宽限期结束仍未支付，订阅过期
+	if err := db.Model(&sub).Update("grace_ends_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ExpireSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.Status != "expired" {
+		t.Errorf("status after grace period = %s, want expired", sub.Status)
+	}
+	var notifications int64
+	db.Model(&models.Notification{}).Where("user_id = ? AND type = ?", user.ID, "subscription.expired").Count(&notifications)
+	if notifications != 1 {
+		t.Errorf("expired notifications = %d, want 1", notifications)
+	}
+}
+
+func TestCancelSubscription(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestSubscriptionHandler(db)
+	plan := createTestPlan(t, db)
+	user := createTestUser(t, db)
+
+	sub := buyTestSubscription(t, db, user.ID, plan.Type)
+	expireIn(t, db, &sub, time.Hour)
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	order := renewalOrder(t, db, sub)
+
+	// 取消后已付费的一期仍可使用到期，未支付的续费订单被取消
+	cancel := func() int {
+		return performAs(t, user.ID, nil, h.CancelSubscription, http.MethodPost, "/subscriptions/cancel", "/subscriptions/cancel", nil).Code
+	}
+	if code := cancel(); code != http.StatusOK {
+		t.Fatalf("cancel: status = %d", code)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.Status != "active" || sub.AutoRenew || sub.CanceledAt == nil || sub.GraceEndsAt != nil {
+		t.Errorf("subscription after cancel = %+v", sub)
+	}
+	if err := db.First(&order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "canceled" {
+		t.Errorf("renewal order status = %s, want canceled", order.Status)
+	}
+	if code := cancel(); code != http.StatusConflict {
+		t.Errorf("second cancel: status = %d, want 409", code)
+	}
+
+	// 取消的订阅不再生成续费订单，到期后记为 canceled
+	if err := db.Model(&sub).Update("renewal_payment_id", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.RenewalPaymentID != nil {
+		t.Errorf("renewal order created for a canceled subscription")
+	}
+	expireIn(t, db, &sub, -time.Minute)
+	if err := h.ExpireSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.Status != "canceled" {
+		t.Errorf("status after expiry = %s, want canceled", sub.Status)
+	}
+}
+
+func TestCancelSubscriptionDuringGracePeriod(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestSubscriptionHandler(db)
+	plan := createTestPlan(t, db)
+	user := createTestUser(t, db)
+
+	sub := buyTestSubscription(t, db, user.ID, plan.Type)
+	expireIn(t, db, &sub, time.Hour)
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expireIn(t, db, &sub, -time.Minute)
+	if err := h.ExpireSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 宽限期中取消立即结束，之后不能恢复
+	w := performAs(t, user.ID, nil, h.CancelSubscription, http.MethodPost, "/subscriptions/cancel", "/subscriptions/cancel", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel: status = %d", w.Code)
+	}
+	reloadSubscription(t, db, &sub)
+	if sub.Status != "canceled" {
+		t.Errorf("status = %s, want canceled", sub.Status)
+	}
+	w = performAs(t, user.ID, nil, h.ResumeSubscription, http.MethodPost, "/subscriptions/resume", "/subscriptions/resume", nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("resume after grace cancel: status = %d, want 409", w.Code)
+	}
+}
+
+func TestResumeSubscription(t *testing.T) {
+	db := openTestDB(t)
+	h := newTestSubscriptionHandler(db)
+	plan := createTestPlan(t, db)
+	user := createTestUser(t, db)
+	sub := buyTestSubscription(t, db, user.ID, plan.Type)
+
+	w := performAs(t, user.ID, nil, h.CancelSubscription, http.MethodPost, "/subscriptions/cancel", "/subscriptions/cancel", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel: status = %d", w.Code)
+	}
+	w = performAs(t, user.ID, nil, h.ResumeSubscription, http.MethodPost, "/subscriptions/resume", "/subscriptions/resume", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resume: status = %d", w.Code)
+	}
+	reloadSubscription(t, db, &sub)
+	if !sub.AutoRenew || sub.CanceledAt != nil {
+		t.Errorf("subscription after resume = %+v", sub)
+	}
+
+	// 恢复后到期前重新生成续费订单
+	expireIn(t, db, &sub, time.Hour)
+	if err := h.RenewSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	reloadSubscription(t, db, &sub)
+	if order := renewalOrder(t, db, sub); order.Status != "pending" {
+		t.Errorf("renewal order status = %s, want pending", order.Status)
+	}
+}
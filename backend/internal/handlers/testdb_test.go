@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/config"
+	"fullstack-backend/internal/database"
+	"fullstack-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	migrateOnce sync.Once
+	migrateErr  error
+)
+
+// openTestDB 连接 TEST_DATABASE_URL 指定的 Postgres 并执行迁移，未设置时跳过测试。
+// 测试数据使用唯一后缀并在结束时删除，可以指向开发库，但不要指向生产库
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	migrateOnce.Do(func() { migrateErr = database.Migrate(db) })
+	if migrateErr != nil {
+		t.Fatalf("migrate: %v", migrateErr)
+	}
+	return db
+}
+
+// uniqueSuffix 测试数据的唯一后缀，避免与已有数据或并行测试冲突
+func uniqueSuffix() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
+
+// createTestUser 创建测试用户，测试结束时删除
+func createTestUser(t *testing.T, db *gorm.DB) models.User {
+	t.Helper()
+	suffix := uniqueSuffix()
+	user := models.User{
+		Username:     "test-" + suffix,
+		Email:        "test-" + suffix + "@example.com",
+		PasswordHash: "x",
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, model := range []interface{}{
+			&models.AuditLog{}, &models.Notification{}, &models.AccountWaitlist{}, &models.TemporaryUsage{},
+			&models.Subscription{}, &models.CartItem{}, &models.ExclusivePurchase{},
+		} {
+			db.Where("user_id = ?", user.ID).Delete(model)
+		}
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&models.FamilyBinding{})
+		orders := db.Unscoped().Model(&models.Payment{}).Select("id").Where("user_id = ?", user.ID)
+		orderNos := db.Unscoped().Model(&models.Payment{}).Select("order_no").Where("user_id = ?", user.ID)
+		db.Where("payment_id IN (?)", orders).Delete(&models.PaymentItem{})
+		db.Where("payment_id IN (?)", orders).Delete(&models.Refund{})
+		db.Where("payment_id IN (?)", orders).Delete(&models.OrphanPayment{})
+		db.Where("order_no IN (?)", orderNos).Delete(&models.PaymentNotification{})
+		keys := db.Unscoped().Model(&models.LicenseKey{}).Select("id").Where("user_id = ?", user.ID)
+		db.Where("license_key_id IN (?)", keys).Delete(&models.QuotaLedger{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&models.LicenseKey{})
+		db.Where("user_id = ?", user.ID).Delete(&models.Invoice{})
+		db.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Payment{})
+		db.Unscoped().Delete(&user)
+	})
+	return user
+}
+
+// createTestAccount 创建账号池中的账号，测试结束时删除
+func createTestAccount(t *testing.T, db *gorm.DB, accountType, status string, price int) models.Account {
+	t.Helper()
+	account := models.Account{
+		Type:   accountType,
+		Main:   "account-" + uniqueSuffix() + "@example.com",
+		Status: status,
+		Source: "test",
+		Price:  price,
+	}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Where("account_id = ?", account.ID).Delete(&models.TemporaryUsage{})
+		db.Where("account_id = ?", account.ID).Delete(&models.AccountWaitlist{})
+		db.Where("account_id = ?", account.ID).Delete(&models.FamilyGroup{})
+		db.Unscoped().Delete(&account)
+	})
+	return account
+}
+
+// createTestSubscription 为用户开通有效订阅（排队移交要求有效订阅）
+func createTestSubscription(t *testing.T, db *gorm.DB, userID uint) models.Subscription {
+	t.Helper()
+	now := time.Now()
+	subscription := models.Subscription{
+		UserID:    userID,
+		Plan:      "monthly",
+		StartsAt:  now.Add(-time.Hour),
+		ExpiresAt: now.Add(30 * 24 * time.Hour),
+		Status:    "active",
+	}
+	if err := db.Create(&subscription).Error; err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	return subscription
+}
+
+// newTestAccountHandler 每次占用 1 小时，最多 4 小时，每人同时最多 2 个
+func newTestAccountHandler(db *gorm.DB) *AccountHandler {
+	return NewAccountHandler(db, &config.Config{
+		TemporaryClaimDurations:     map[string]time.Duration{"default": time.Hour},
+		TemporaryClaimMaxTotal:      map[string]time.Duration{"default": 4 * time.Hour},
+		TemporaryClaimMaxConcurrent: 2,
+	})
+}
+
+// createTestOrder 创建待支付订单，测试结束时连同生成的密钥、发票和回调记录一起删除
+func createTestOrder(t *testing.T, db *gorm.DB, userID uint, amount int) models.Payment {
+	t.Helper()
+	order := models.Payment{
+		UserID:      userID,
+		OrderNo:     "TEST" + uniqueSuffix(),
+		Amount:      amount,
+		ProductType: "basic",
+		QuotaAmount: 100,
+		Status:      "pending",
+		ExpiredAt:   time.Now().Add(orderTTL),
+	}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Unscoped().Where("payment_id = ?", order.ID).Delete(&models.LicenseKey{})
+		db.Where("payment_id = ?", order.ID).Delete(&models.Invoice{})
+		db.Where("payment_id = ?", order.ID).Delete(&models.OrphanPayment{})
+		db.Where("order_no = ?", order.OrderNo).Delete(&models.PaymentNotification{})
+		db.Unscoped().Delete(&order)
+	})
+	return order
+}
+
+// createTestLicenseKey 创建可用的 License Key，测试结束时连同额度流水一起删除
+func createTestLicenseKey(t *testing.T, db *gorm.DB, userID uint, quota int) models.LicenseKey {
+	t.Helper()
+	key := models.LicenseKey{
+		UserID:      userID,
+		KeyCode:     "TEST-" + uniqueSuffix(),
+		ProductType: "basic",
+		QuotaTotal:  quota,
+		Status:      "active",
+	}
+	if err := db.Create(&key).Error; err != nil {
+		t.Fatalf("create license key: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Where("license_key_id = ?", key.ID).Delete(&models.QuotaLedger{})
+		db.Unscoped().Delete(&key)
+	})
+	return key
+}
+
+// performAs 以 userID 的身份调用处理函数，values 为认证中间件写入上下文的其他值（例如 subscription）。
+// pattern 为路由模式，target 为实际请求路径，body 不为 nil 时按 JSON 发送
+func performAs(t *testing.T, userID uint, values gin.H, handler gin.HandlerFunc, method, pattern, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, pattern, func(c *gin.Context) {
+		c.Set("user_id", userID)
+		for key, value := range values {
+			c.Set(key, value)
+		}
+	}, handler)
+
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,133 @@
+// Package invoice renders issued invoices (receipts) as HTML and PDF.
+//
+// Both formats are produced in-process: HTML through html/template and PDF
+// through a small writer that uses the STSong-Light CJK font every PDF
+// viewer ships with, so nothing has to be embedded or fetched from an
+// external service. The numbers on an invoice are fixed when it is issued;
+// this package only formats them.
+package invoice
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+
+	"fullstack-backend/internal/models"
+)
+
+// FormatAmount 将金额（分）格式化为元，保留两位小数
+func FormatAmount(fen int) string {
+	sign := ""
+	if fen < 0 {
+		sign, fen = "-", -fen
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, fen/100, fen%100)
+}
+
+// FormatRate 将基点格式化为百分比，如 600 -> 6%，650 -> 6.5%
+func FormatRate(bps int) string {
+	if bps%100 == 0 {
+		return fmt.Sprintf("%d%%", bps/100)
+	}
+	return fmt.Sprintf("%s%%", trimZeros(fmt.Sprintf("%.2f", float64(bps)/100)))
+}
+
+func trimZeros(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if len(s) > 0 && s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// TaxIncluded 含税金额中包含的税额，四舍五入到分
+func TaxIncluded(total, bps int) int {
+	if bps <= 0 {
+		return 0
+	}
+	return (total*bps*2 + (10000 + bps)) / ((10000 + bps) * 2)
+}
+
+var funcs = template.FuncMap{
+	"amount": FormatAmount,
+	"rate":   FormatRate,
+	"net":    func(inv *models.Invoice) int { return inv.Total - inv.TaxAmount },
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>发票 {{.InvoiceNo}}</title>
+<style>
+  body { font-family: -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; color: #222; margin: 0; background: #f4f4f5; }
+  .invoice { max-width: 760px; margin: 32px auto; background: #fff; padding: 40px; box-shadow: 0 1px 3px rgba(0,0,0,.1); }
+  h1 { margin: 0 0 4px; font-size: 24px; }
+  .meta { color: #555; font-size: 14px; line-height: 1.7; }
+  .parties { display: flex; justify-content: space-between; margin: 28px 0; font-size: 14px; line-height: 1.7; }
+  .parties h2 { font-size: 13px; color: #888; margin: 0 0 4px; font-weight: normal; }
+  table { width: 100%; border-collapse: collapse; font-size: 14px; }
+  th { text-align: left; color: #888; font-weight: normal; border-bottom: 1px solid #ddd; padding: 8px 0; }
+  td { padding: 8px 0; border-bottom: 1px solid #f0f0f0; }
+  .num { text-align: right; }
+  .totals { margin-top: 16px; margin-left: auto; width: 300px; font-size: 14px; }
+  .totals div { display: flex; justify-content: space-between; padding: 3px 0; }
+  .totals .total { font-weight: bold; font-size: 16px; border-top: 1px solid #ddd; padding-top: 8px; margin-top: 4px; }
+  .footer { margin-top: 32px; color: #888; font-size: 12px; }
+  @media print { body { background: #fff; } .invoice { box-shadow: none; margin: 0; } }
+</style>
+</head>
+<body>
+<div class="invoice">
+  <h1>发票 / 收据</h1>
+  <div class="meta">
+    发票号：{{.InvoiceNo}}<br>
+    开票日期：{{.IssuedAt.Format "2006-01-02"}}<br>
+    订单号：{{.OrderNo}}
+  </div>
+  <div class="parties">
+    <div>
+      <h2>销售方</h2>
+      {{.SellerName}}<br>
+      {{if .SellerTaxID}}纳税人识别号：{{.SellerTaxID}}<br>{{end}}
+      {{if .SellerAddress}}{{.SellerAddress}}{{end}}
+    </div>
+    <div>
+      <h2>购买方</h2>
+      {{.BuyerName}}<br>
+      {{.BuyerEmail}}
+    </div>
+  </div>
+  <table>
+    <thead>
+      <tr><th>项目</th><th class="num">数量</th><th class="num">单价</th><th class="num">金额</th></tr>
+    </thead>
+    <tbody>
+    {{range .Lines}}
+      <tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{amount .UnitPrice}}</td><td class="num">{{amount .Amount}}</td></tr>
+    {{end}}
+    </tbody>
+  </table>
+  <div class="totals">
+    <div><span>小计</span><span>{{amount .Subtotal}}</span></div>
+    {{if .Discount}}<div><span>优惠</span><span>-{{amount .Discount}}</span></div>{{end}}
+    <div class="total"><span>合计（{{.Currency}}）</span><span>{{amount .Total}}</span></div>
+    {{if .TaxRate}}
+    <div><span>不含税金额</span><span>{{amount (net .)}}</span></div>
+    <div><span>税额（{{rate .TaxRate}}）</span><span>{{amount .TaxAmount}}</span></div>
+    {{end}}
+  </div>
+  <div class="footer">
+    支付时间：{{.PaidAt.Format "2006-01-02 15:04:05"}}。金额单位为元{{if .TaxRate}}，价格含税{{end}}。本收据由系统自动生成。
+  </div>
+</div>
+</body>
+</html>
+`))
+
+// WriteHTML 将发票渲染为可打印的 HTML 页面
+func WriteHTML(w io.Writer, inv *models.Invoice) error {
+	return htmlTemplate.Execute(w, inv)
+}
@@ -0,0 +1,113 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/models"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		fen  int
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{99, "0.99"},
+		{100, "1.00"},
+		{2990, "29.90"},
+		{100000, "1000.00"},
+		{-150, "-1.50"},
+		{-5, "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := FormatAmount(tt.fen); got != tt.want {
+			t.Errorf("FormatAmount(%d) = %q, want %q", tt.fen, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		bps  int
+		want string
+	}{
+		{600, "6%"},
+		{1300, "13%"},
+		{650, "6.5%"},
+		{125, "1.25%"},
+		{5, "0.05%"},
+		{0, "0%"},
+	}
+	for _, tt := range tests {
+		if got := FormatRate(tt.bps); got != tt.want {
+			t.Errorf("FormatRate(%d) = %q, want %q", tt.bps, got, tt.want)
+		}
+	}
+}
+
+func TestTaxIncluded(t *testing.T) {
+	tests := []struct {
+		total, bps int
+		want       int
+	}{
+		{10600, 600, 600},
+		{2990, 600, 169}, // 169.245
+		{100, 1300, 12},  // 11.504
+		{1, 600, 0},
+		{3, 10000, 2}, // 1.5 四舍五入
+		{0, 600, 0},
+		{2990, 0, 0},
+		{2990, -600, 0},
+	}
+	for _, tt := range tests {
+		if got := TaxIncluded(tt.total, tt.bps); got != tt.want {
+			t.Errorf("TaxIncluded(%d, %d) = %d, want %d", tt.total, tt.bps, got, tt.want)
+		}
+	}
+}
+
+func TestPDFCrossReference(t *testing.T) {
+	lines := make([]models.InvoiceLine, 60) // 明细足够多，触发分页
+	for i := range lines {
+		lines[i] = models.InvoiceLine{Description: fmt.Sprintf("独享账号 #%d", i+1), Quantity: 1, UnitPrice: 1000, Amount: 1000}
+	}
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	data := PDF(&models.Invoice{
+		InvoiceNo: "INV2026000001", OrderNo: "ORD1", BuyerName: "alice", BuyerEmail: "alice@example.com",
+		SellerName: "Fullstack", Currency: "CNY", Lines: lines, Subtotal: 60000, Discount: 1000,
+		Total: 59000, TaxRate: 600, TaxAmount: TaxIncluded(59000, 600), PaidAt: now, IssuedAt: now,
+	})
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if count := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(data); count == nil || string(count[1]) == "1" {
+		t.Fatalf("expected several pages, got %q", count)
+	}
+
+	// startxref 指向 xref 表，表中每个偏移都指向对应的对象
+	start := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	if start == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(start[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if len(offsets) == 0 {
+		t.Fatal("empty xref table")
+	}
+	for i, match := range offsets {
+		offset, _ := strconv.Atoi(string(match[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", i+1, data[offset:offset+10])
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"fullstack-backend/internal/models"
+)
+
+// A4，单位为 pt
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	marginX      = 50.0
+	marginTop    = 60.0
+	marginBottom = 60.0
+)
+
+// 表格列的右边界（数量、单价、金额右对齐）
+const (
+	colQuantity = 360.0
+	colPrice    = 455.0
+	colAmount   = pageWidth - marginX
+)
+
+// pdfWriter 生成只包含文字和直线的多页 PDF。文字统一使用 STSong-Light（UniGB-UCS2-H 编码），
+// 这是 PDF 阅读器内置的中文字体，无需嵌入字体文件。ASCII 字符宽度按半角（0.5em）计算
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // 当前行的基线，从页面顶部向下排版
+}
+
+func newPDFWriter() *pdfWriter {
+	w := &pdfWriter{}
+	w.newPage()
+	return w
+}
+
+func (w *pdfWriter) newPage() {
+	w.page = &bytes.Buffer{}
+	w.pages = append(w.pages, w.page)
+	w.y = pageHeight - marginTop
+}
+
+// ensureSpace 当前页剩余高度不足时换页，返回是否换页
+func (w *pdfWriter) ensureSpace(height float64) bool {
+	if w.y-height >= marginBottom {
+		return false
+	}
+	w.newPage()
+	return true
+}
+
+func (w *pdfWriter) text(x, y, size float64, s string) {
+	fmt.Fprintf(w.page, "BT /F1 %.1f Tf %.2f %.2f Td <%s> Tj ET\n", size, x, y, encodeUCS2(s))
+}
+
+func (w *pdfWriter) textRight(right, y, size float64, s string) {
+	w.text(right-textWidth(s, size), y, size, s)
+}
+
+func (w *pdfWriter) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(w.page, "%.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// textWidth 按 ASCII 半角、其余全角估算文字宽度
+func textWidth(s string, size float64) float64 {
+	width := 0.0
+	for _, r := range s {
+		if r < 0x80 {
+			width += 0.5
+		} else {
+			width += 1
+		}
+	}
+	return width * size
+}
+
+// truncate 截断超出宽度的文字
+func truncate(s string, size, maxWidth float64) string {
+	if textWidth(s, size) <= maxWidth {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > maxWidth {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// encodeUCS2 将文字编码为 UTF-16BE 十六进制串，基本多文种平面以外的字符替换为问号
+func encodeUCS2(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r > 0xFFFF || utf16.IsSurrogate(r) {
+			r = '?'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+// bytes 组装 PDF 文件：目录、页面树、各页内容和字体对象，最后写入交叉引用表
+func (w *pdfWriter) bytes() []byte {
+	// 对象编号：1 目录，2 页面树，3-5 字体，之后每页占用页面和内容两个对象
+	const fontObj = 3
+	pageObj := func(i int) int { return 6 + 2*i }
+
+	var objects []string
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj(i))
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light-UniGB-UCS2-H /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light"+
+			" /CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >>"+
+			" /FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>",
+		"<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880]"+
+			" /ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>",
+	)
+	for i, page := range w.pages {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+				pageWidth, pageHeight, fontObj, pageObj(i)+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// PDF 将发票渲染为 A4 PDF，明细较多时自动分页并在每页重复表头
+func PDF(inv *models.Invoice) []byte {
+	w := newPDFWriter()
+	right := pageWidth - marginX
+
+	w.text(marginX, w.y, 20, "发票 / 收据")
+	w.textRight(right, w.y, 10, "发票号："+inv.InvoiceNo)
+	w.y -= 16
+	w.textRight(right, w.y, 10, "开票日期："+inv.IssuedAt.Format("2006-01-02"))
+	w.y -= 16
+	w.textRight(right, w.y, 10, "订单号："+inv.OrderNo)
+	w.y -= 36
+
+	// 销售方与购买方
+	seller := []string{inv.SellerName}
+	if inv.SellerTaxID != "" {
+		seller = append(seller, "纳税人识别号："+inv.SellerTaxID)
+	}
+	if inv.SellerAddress != "" {
+		seller = append(seller, inv.SellerAddress)
+	}
+	buyer := []string{inv.BuyerName, inv.BuyerEmail}
+	w.text(marginX, w.y, 9, "销售方")
+	w.text(pageWidth/2, w.y, 9, "购买方")
+	w.y -= 16
+	for i := 0; i < len(seller) || i < len(buyer); i++ {
+		if i < len(seller) {
+			w.text(marginX, w.y, 11, truncate(seller[i], 11, pageWidth/2-marginX-10))
+		}
+		if i < len(buyer) {
+			w.text(pageWidth/2, w.y, 11, truncate(buyer[i], 11, right-pageWidth/2))
+		}
+		w.y -= 16
+	}
+	w.y -= 20
+
+	tableHeader := func() {
+		w.text(marginX, w.y, 10, "项目")
+		w.textRight(colQuantity, w.y, 10, "数量")
+		w.textRight(colPrice, w.y, 10, "单价")
+		w.textRight(colAmount, w.y, 10, "金额")
+		w.line(marginX, w.y-6, right, w.y-6)
+		w.y -= 22
+	}
+	tableHeader()
+	for _, line := range inv.Lines {
+		if w.ensureSpace(20) {
+			tableHeader()
+		}
+		w.text(marginX, w.y, 10, truncate(line.Description, 10, colQuantity-marginX-50))
+		w.textRight(colQuantity, w.y, 10, fmt.Sprintf("%d", line.Quantity))
+		w.textRight(colPrice, w.y, 10, FormatAmount(line.UnitPrice))
+		w.textRight(colAmount, w.y, 10, FormatAmount(line.Amount))
+		w.y -= 20
+	}
+	w.line(marginX, w.y+12, right, w.y+12)
+	w.y -= 8
+
+	// 合计
+	type row struct{ label, value string }
+	rows := []row{{"小计", FormatAmount(inv.Subtotal)}}
+	if inv.Discount > 0 {
+		rows = append(rows, row{"优惠", "-" + FormatAmount(inv.Discount)})
+	}
+	rows = append(rows, row{"合计（" + inv.Currency + "）", FormatAmount(inv.Total)})
+	if inv.TaxRate > 0 {
+		rows = append(rows,
+			row{"不含税金额", FormatAmount(inv.Total - inv.TaxAmount)},
+			row{"税额（" + FormatRate(inv.TaxRate) + "）", FormatAmount(inv.TaxAmount)},
+		)
+	}
+	w.ensureSpace(float64(len(rows))*18 + 60)
+	for _, r := range rows {
+		w.text(colPrice-110, w.y, 11, r.label)
+		w.textRight(colAmount, w.y, 11, r.value)
+		w.y -= 18
+	}
+
+	note := "支付时间：" + inv.PaidAt.Format("2006-01-02 15:04:05") + "。金额单位为元"
+	if inv.TaxRate > 0 {
+		note += "，价格含税"
+	}
+	w.y -= 24
+	w.text(marginX, w.y, 9, note+"。本收据由系统自动生成。")
+
+	return w.bytes()
+}
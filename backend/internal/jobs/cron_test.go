@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	// 2026-01-01 是星期四
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"*/15 * * * *", "2026-01-01 10:07:30", "2026-01-01 10:15:00"},
+		{"@hourly", "2026-01-01 10:00:00", "2026-01-01 11:00:00"},
+		{"30 2 * * *", "2026-01-01 03:00:00", "2026-01-02 02:30:00"},
+		{"@monthly", "2026-01-15 00:00:00", "2026-02-01 00:00:00"},
+		{"5,10 * * * *", "2026-01-01 10:07:00", "2026-01-01 10:10:00"},
+		{"0 8-18/5 * * *", "2026-01-01 14:00:00", "2026-01-01 18:00:00"},
+		{"0 9 * * 1-5", "2026-01-03 12:00:00", "2026-01-05 09:00:00"},
+		{"0 0 * * 7", "2026-01-01 00:00:00", "2026-01-04 00:00:00"},
+		{"0 0 * * 0", "2026-01-01 00:00:00", "2026-01-04 00:00:00"},
+		// 日和周同时限定时满足其一即可
+		{"0 0 13 * 5", "2026-01-01 00:00:00", "2026-01-02 00:00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"@every 90m", "2026-01-01 10:07:30", "2026-01-01 11:37:30"},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q Next(%s) = %s, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04:05"), tt.want)
+		}
+	}
+}
+
+func TestScheduleNextNeverMatches(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := schedule.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want zero time", got)
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@every 500ms",
+		"@every soon",
+	}
+	for _, spec := range specs {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package jobs runs periodic background work inside the API process.
+//
+// Every replica registers the same jobs; the scheduled_jobs table decides
+// which replica runs a due job by atomically taking a time-bounded lock on
+// its row, so each run happens exactly once across the deployment.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobFunc 一次后台任务执行
+type JobFunc func(ctx context.Context) error
+
+// JobOptions 任务的重试和超时设置
+type JobOptions struct {
+	MaxRetries int           // 失败后按退避重试的次数，之后等待下一个调度周期
+	Timeout    time.Duration // 单次执行超时，同时决定锁的持有时间
+}
+
+var defaultJobOptions = JobOptions{
+	MaxRetries: 3,
+	Timeout:    5 * time.Minute,
+}
+
+const (
+	pollInterval   = 10 * time.Second
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 30 * time.Minute
+)
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      JobFunc
+	opts     JobOptions
+}
+
+// Scheduler 按 cron 表达式执行已注册的后台任务
+type Scheduler struct {
+	db       *gorm.DB
+	instance string
+	jobs     []*job
+	running  sync.Map // 本实例正在执行的任务名
+}
+
+// NewScheduler 创建调度器
+func NewScheduler(db *gorm.DB) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		db:       db,
+		instance: fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+// Register 注册任务，spec 为 cron 表达式；opts 省略时使用默认重试和超时
+func (s *Scheduler) Register(name, spec string, run JobFunc, opts ...JobOptions) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", name, err)
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("job %s: schedule %q never fires", name, spec)
+	}
+
+	options := defaultJobOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	s.jobs = append(s.jobs, &job{name: name, spec: spec, schedule: schedule, run: run, opts: options})
+	return nil
+}
+
+// Start 同步任务定义到数据库并开始轮询，ctx 取消后停止
+func (s *Scheduler) Start(ctx context.Context) error {
+	now := time.Now()
+	for _, j := range s.jobs {
+		row := models.ScheduledJob{
+			Name:      j.name,
+			Schedule:  j.spec,
+			NextRunAt: j.schedule.Next(now),
+		}
+		// 已存在的任务只在表达式变化时重新计算下次执行时间
+		if err := s.db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "name"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"schedule":    gorm.Expr("EXCLUDED.schedule"),
+				"next_run_at": gorm.Expr("CASE WHEN scheduled_jobs.schedule = EXCLUDED.schedule THEN scheduled_jobs.next_run_at ELSE EXCLUDED.next_run_at END"),
+				"updated_at":  now,
+			}),
+		}).Create(&row).Error; err != nil {
+			return fmt.Errorf("failed to register job %s: %v", j.name, err)
+		}
+	}
+
+	go s.loop(ctx)
+	return nil
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.dispatchDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchDue 尝试抢占所有到期任务的锁，抢到的在独立 goroutine 中执行
+func (s *Scheduler) dispatchDue(ctx context.Context) {
+	for _, j := range s.jobs {
+		if _, busy := s.running.Load(j.name); busy {
+			continue
+		}
+
+		now := time.Now()
+		lockedUntil := now.Add(j.opts.Timeout + time.Minute)
+		result := s.db.Model(&models.ScheduledJob{}).
+			Where("name = ? AND next_run_at <= ? AND (locked_until IS NULL OR locked_until < ?)", j.name, now, now).
+			Updates(map[string]interface{}{
+				"locked_by":    s.instance,
+				"locked_until": lockedUntil,
+			})
+		if result.Error != nil {
+			log.Printf("job %s: failed to acquire lock: %v", j.name, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		s.running.Store(j.name, true)
+		go func(j *job) {
+			defer s.running.Delete(j.name)
+			s.execute(ctx, j)
+		}(j)
+	}
+}
+
+// execute 执行任务并记录结果，失败时按指数退避安排重试
+func (s *Scheduler) execute(ctx context.Context, j *job) {
+	var state models.ScheduledJob
+	if err := s.db.Where("name = ?", j.name).First(&state).Error; err != nil {
+		log.Printf("job %s: failed to load state: %v", j.name, err)
+		return
+	}
+
+	attempt := state.Attempt + 1
+	run := models.JobRun{
+		JobName:   j.name,
+		Attempt:   attempt,
+		Status:    "running",
+		Instance:  s.instance,
+		StartedAt: time.Now(),
+	}
+	if err := s.db.Create(&run).Error; err != nil {
+		// 记录失败不影响执行，结束时 Save 会补写这条运行记录
+		log.Printf("job %s: failed to record run start: %v", j.name, err)
+	}
+
+	err := s.invoke(ctx, j)
+
+	finished := time.Now()
+	updates := map[string]interface{}{
+		"locked_by":    "",
+		"locked_until": nil,
+		"last_run_at":  run.StartedAt,
+	}
+	run.FinishedAt = &finished
+	if err == nil {
+		run.Status = "success"
+		updates["attempt"] = 0
+		updates["last_error"] = ""
+		updates["next_run_at"] = j.schedule.Next(finished)
+	} else {
+		run.Status = "failed"
+		run.Error = err.Error()
+		updates["last_error"] = err.Error()
+		if attempt <= j.opts.MaxRetries {
+			updates["attempt"] = attempt
+			updates["next_run_at"] = finished.Add(retryDelay(attempt))
+		} else {
+			updates["attempt"] = 0
+			updates["next_run_at"] = j.schedule.Next(finished)
+		}
+		log.Printf("job %s failed (attempt %d): %v", j.name, attempt, err)
+	}
+
+	if err := s.db.Save(&run).Error; err != nil {
+		log.Printf("job %s: failed to record run result: %v", j.name, err)
+	}
+	result := s.db.Model(&models.ScheduledJob{}).
+		Where("name = ? AND locked_by = ?", j.name, s.instance).
+		Updates(updates)
+	if result.Error != nil {
+		// 锁会在 locked_until 到期后失效，届时由其他实例重新执行
+		log.Printf("job %s: failed to update schedule state: %v", j.name, result.Error)
+	} else if result.RowsAffected == 0 {
+		log.Printf("job %s: lock was taken over before the run finished, schedule state not updated", j.name)
+	}
+}
+
+// invoke 带超时执行任务，并把 panic 转换为错误
+func (s *Scheduler) invoke(ctx context.Context, j *job) (err error) {
+	runCtx, cancel := context.WithTimeout(ctx, j.opts.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.run(runCtx)
+}
+
+// retryDelay 第 n 次失败后的退避时间
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay
+}
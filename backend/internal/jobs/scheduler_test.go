@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/database"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB 连接 TEST_DATABASE_URL 指定的 Postgres 并执行迁移，未设置时跳过测试
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// runDueJob 注册一个已到期的任务并在当前实例上执行一次
+func runDueJob(t *testing.T, db *gorm.DB, run JobFunc) (models.ScheduledJob, []models.JobRun) {
+	t.Helper()
+	name := fmt.Sprintf("test-job-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Where("job_name = ?", name).Delete(&models.JobRun{})
+		db.Where("name = ?", name).Delete(&models.ScheduledJob{})
+	})
+
+	s := NewScheduler(db)
+	if err := s.Register(name, "0 * * * *", run, JobOptions{MaxRetries: 1, Timeout: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.ScheduledJob{Name: name, Schedule: "0 * * * *", NextRunAt: time.Now().Add(-time.Second)}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s.dispatchDue(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, busy := s.running.Load(name); !busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var state models.ScheduledJob
+	if err := db.Where("name = ?", name).First(&state).Error; err != nil {
+		t.Fatal(err)
+	}
+	var runs []models.JobRun
+	if err := db.Where("job_name = ?", name).Order("id").Find(&runs).Error; err != nil {
+		t.Fatal(err)
+	}
+	return state, runs
+}
+
+func TestSchedulerRecordsSuccessfulRun(t *testing.T) {
+	db := openTestDB(t)
+	state, runs := runDueJob(t, db, func(ctx context.Context) error { return nil })
+
+	if len(runs) != 1 || runs[0].Status != "success" || runs[0].FinishedAt == nil {
+		t.Fatalf("runs = %+v", runs)
+	}
+	if state.LockedBy != "" || state.LockedUntil != nil || state.Attempt != 0 {
+		t.Errorf("job still locked or retrying: %+v", state)
+	}
+	if !state.NextRunAt.After(time.Now()) {
+		t.Errorf("next_run_at = %s, want the next scheduled hour", state.NextRunAt)
+	}
+}
+
+func TestSchedulerSchedulesRetryAfterFailure(t *testing.T) {
+	db := openTestDB(t)
+	started := time.Now()
+	state, runs := runDueJob(t, db, func(ctx context.Context) error { return errors.New("boom") })
+
+	if len(runs) != 1 || runs[0].Status != "failed" || runs[0].Error != "boom" {
+		t.Fatalf("runs = %+v", runs)
+	}
+	if state.Attempt != 1 || state.LastError != "boom" || state.LockedUntil != nil {
+		t.Errorf("state = %+v, want attempt 1 with the error recorded", state)
+	}
+	// 第一次失败后按 retryBaseDelay 退避，而不是等到下一个整点
+	if retryAt := started.Add(retryBaseDelay); state.NextRunAt.Before(retryAt) || state.NextRunAt.After(retryAt.Add(5*time.Second)) {
+		t.Errorf("next_run_at = %s, want about %s", state.NextRunAt, retryAt)
+	}
+}
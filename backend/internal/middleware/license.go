@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 
+	"fullstack-backend/internal/catalog"
 	"fullstack-backend/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -82,8 +83,14 @@ func LicenseKeyMiddleware(db *gorm.DB, requiredFeature string) gin.HandlerFunc {
 			return
 		}
 
-		// 检查功能权限
-		if !checkFeaturePermission(key.ProductType, requiredFeature) {
+		// 检查功能权限（以购买时的产品版本为准）
+		allowed, err := catalog.HasFeature(db, &key, requiredFeature)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "验证 Key 失败"})
+			c.Abort()
+			return
+		}
+		if !allowed {
 			c.JSON(http.StatusForbidden, gin.H{
 				"error": "当前 License Key 不支持此功能",
 				"message": "请升级到更高级别的 Key",
@@ -99,6 +106,8 @@ func LicenseKeyMiddleware(db *gorm.DB, requiredFeature string) gin.HandlerFunc {
 }
 
 // ConsumeQuota 消耗额度的中间件（在请求成功后调用）
+// 处理函数可以通过 c.Set("quota_cost", n) 覆盖本次实际扣减的额度（例如命中缓存的验证），
+// 并通过 "quota_reason" / "quota_metadata" 补充额度流水信息。
 func ConsumeQuota(db *gorm.DB, amount int) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next() // 先执行业务逻辑
@@ -112,51 +121,41 @@ func ConsumeQuota(db *gorm.DB, amount int) gin.HandlerFunc {
 
 			key := keyInterface.(models.LicenseKey)
 
-			// 更新已使用额度
-			if err := db.Model(&models.LicenseKey{}).
-				Where("id = ?", key.ID).
-				UpdateColumn("quota_used", gorm.Expr("quota_used + ?", amount)).
-				Error; err != nil {
-				// 记录错误但不影响响应
-				c.Set("quota_consume_error", err)
+			cost := amount
+			if override, ok := c.Get("quota_cost"); ok {
+				cost = override.(int)
 			}
-		}
-	}
-}
 
-// checkFeaturePermission 检查功能权限
-func checkFeaturePermission(productType, feature string) bool {
-	// 功能权限映射
-	permissions := map[string][]string{
-		"basic": {
-			"email_verify",
-		},
-		"pro": {
-			"email_verify",
-			"email_import",
-			"task_management",
-		},
-		"enterprise": {
-			"email_verify",
-			"email_import",
-			"task_management",
-			"api_access",
-			"priority_support",
-		},
-	}
+			reason := c.GetString("quota_reason")
+			if reason == "" {
+				reason = c.FullPath()
+			}
 
-	allowedFeatures, exists := permissions[productType]
-	if !exists {
-		return false
-	}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if cost > 0 {
+					// 更新已使用额度
+					if err := tx.Model(&models.LicenseKey{}).
+						Where("id = ?", key.ID).
+						UpdateColumn("quota_used", gorm.Expr("quota_used + ?", cost)).
+						Error; err != nil {
+						return err
+					}
+				}
 
-	for _, f := range allowedFeatures {
-		if f == feature {
-			return true
+				return tx.Create(&models.QuotaLedger{
+					LicenseKeyID: key.ID,
+					UserID:       key.UserID,
+					Amount:       cost,
+					Reason:       reason,
+					Metadata:     c.GetString("quota_metadata"),
+				}).Error
+			})
+			if err != nil {
+				// 记录错误但不影响响应
+				c.Set("quota_consume_error", err)
+			}
 		}
 	}
-
-	return false
 }
 
 // OptionalLicenseKey 可选的 License Key 中间件（不强制要求）
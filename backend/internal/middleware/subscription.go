@@ -20,8 +20,11 @@ func SubscriptionMiddleware(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// 宽限期内（续费订单未支付）的订阅仍可访问
+		now := time.Now()
 		var sub models.Subscription
-		if err := db.Where("user_id = ? AND status = ? AND expires_at > ?", userID, "active", time.Now()).
+		if err := db.Where("user_id = ? AND status IN ? AND starts_at <= ? AND (expires_at > ? OR grace_ends_at > ?)",
+			userID, []string{"active", "past_due"}, now, now, now).
 			Order("expires_at desc").
 			First(&sub).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
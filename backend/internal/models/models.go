@@ -11,6 +11,7 @@ type User struct {
 	Username     string         `gorm:"uniqueIndex;not null" json:"username"`
 	Email        string         `gorm:"uniqueIndex;not null" json:"email"`
 	PasswordHash string         `gorm:"not null" json:"-"`
+	Role         string         `gorm:"default:'user'" json:"role"` // user, admin
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
@@ -70,16 +71,20 @@ type EmailFamily struct {
 
 // Account platform models
 type Account struct {
-	ID        uint           `gorm:"primarykey" json:"id"`
-	Type      string         `gorm:"not null;index" json:"type"` // temporary, exclusive, family
-	Main      string         `gorm:"not null;index" json:"main"`
-	Password  string         `json:"password"`
-	Key2FA    string         `gorm:"column:key_2fa" json:"key_2FA"`
-	Status    string         `gorm:"default:'available'" json:"status"` // available, locked, sold, retired
-	Source    string         `gorm:"column:source" json:"source"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID          uint           `gorm:"primarykey" json:"id"`
+	Type        string         `gorm:"not null;index" json:"type"` // temporary, exclusive, family
+	Main        string         `gorm:"not null;index" json:"main"`
+	Password    string         `json:"password"`
+	Key2FA      string         `gorm:"column:key_2fa" json:"key_2FA"`
+	Status      string         `gorm:"default:'available'" json:"status"` // available, locked, reserved, sold, needs_rotation, retired
+	Source      string         `gorm:"column:source" json:"source"`
+	Price       int            `gorm:"default:0" json:"price"` // 独享账号售价（分）
+	Description string         `gorm:"type:text" json:"description"`
+	Tags        string         `json:"tags"`                   // 逗号分隔
+	Notes       string         `gorm:"type:text" json:"notes"` // 仅管理员可见的备注
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 type TemporaryUsage struct {
@@ -89,16 +94,27 @@ type TemporaryUsage struct {
 	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
 	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"`
 	ReturnedAt *time.Time `json:"returned_at"`
+	Extensions int        `gorm:"default:0" json:"extensions"` // 续期次数
 	CreatedAt  time.Time  `json:"created_at"`
 }
 
 type ExclusivePurchase struct {
-	ID          uint      `gorm:"primarykey" json:"id"`
-	AccountID   uint      `gorm:"not null;uniqueIndex" json:"account_id"`
-	UserID      uint      `gorm:"not null;index" json:"user_id"`
-	PaymentID   *uint     `json:"payment_id"`
-	PurchasedAt time.Time `gorm:"not null" json:"purchased_at"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          uint       `gorm:"primarykey" json:"id"`
+	AccountID   uint       `gorm:"not null;index:idx_exclusive_purchases_account" json:"account_id"` // 未退款的记录每个账号只有一条（部分唯一索引）
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	PaymentID   *uint      `json:"payment_id"`
+	PurchasedAt time.Time  `gorm:"not null" json:"purchased_at"`
+	RefundedAt  *time.Time `json:"refunded_at"` // 退款后保留购买记录，账号不再归属该用户
+	RefundID    *uint      `json:"refund_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CartItem 购物车中的独享账号，结算时才预留
+type CartItem struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_cart_user_account" json:"user_id"`
+	AccountID uint      `gorm:"not null;uniqueIndex:idx_cart_user_account" json:"account_id"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type FamilyGroup struct {
@@ -119,13 +135,21 @@ type FamilyBinding struct {
 }
 
 type Subscription struct {
-	ID        uint      `gorm:"primarykey" json:"id"`
-	UserID    uint      `gorm:"not null;index" json:"user_id"`
-	Plan      string    `gorm:"not null" json:"plan"` // monthly, yearly, etc
-	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
-	Status    string    `gorm:"default:'active'" json:"status"` // active, expired, canceled
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID               uint       `gorm:"primarykey" json:"id"`
+	UserID           uint       `gorm:"not null;index" json:"user_id"`
+	Plan             string     `gorm:"not null" json:"plan"`   // 订阅套餐的产品类型：monthly, yearly 等
+	StartsAt         time.Time  `gorm:"index" json:"starts_at"` // 续费的订阅从上一期结束时开始
+	ExpiresAt        time.Time  `gorm:"not null;index" json:"expires_at"`
+	Status           string     `gorm:"default:'active';index" json:"status"` // active, past_due（宽限期）, expired, canceled
+	PaymentID        *uint      `gorm:"index" json:"payment_id,omitempty"`    // 通过订单购买时记录，退款时据此撤销
+	Trial            bool       `gorm:"default:false" json:"trial"`
+	AutoRenew        bool       `gorm:"default:true" json:"auto_renew"` // 用户取消后为 false，到期后不再续费
+	CanceledAt       *time.Time `json:"canceled_at,omitempty"`
+	GraceEndsAt      *time.Time `json:"grace_ends_at,omitempty"`                   // 生成续费订单时设置，订单未支付前到期后仍可访问至此时
+	RenewalPaymentID *uint      `gorm:"index" json:"renewal_payment_id,omitempty"` // 自动生成的续费订单
+	ReminderSentAt   *time.Time `json:"reminder_sent_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 type AuditLog struct {
@@ -140,34 +164,331 @@ type AuditLog struct {
 
 // Payment 支付订单
 type Payment struct {
+	ID               uint           `gorm:"primarykey" json:"id"`
+	UserID           uint           `gorm:"not null;index" json:"user_id"`
+	OrderNo          string         `gorm:"uniqueIndex;not null" json:"order_no"`
+	Amount           int            `gorm:"not null" json:"amount"`           // 金额（分）
+	ProductType      string         `gorm:"not null" json:"product_type"`     // basic, pro, enterprise, exclusive_account
+	QuotaAmount      int            `gorm:"not null" json:"quota_amount"`     // 购买的次数额度
+	ProductVersionID *uint          `json:"product_version_id,omitempty"`     // 下单时的产品版本
+	DiscountAmount   int            `gorm:"default:0" json:"discount_amount"` // 优惠金额（分），Amount 为优惠后的实付金额
+	CouponID         *uint          `gorm:"index" json:"coupon_id,omitempty"`
+	CouponCode       string         `json:"coupon_code,omitempty"`
+	Status           string         `gorm:"default:'pending';index" json:"status"` // pending, paid, expired, canceled, refunded
+	PaymentMethod    string         `json:"payment_method"`                        // alipay, wechat, local
+	TransactionID    string         `gorm:"index" json:"transaction_id"`           // 第三方支付流水号
+	PaidAt           *time.Time     `json:"paid_at"`
+	CanceledAt       *time.Time     `json:"canceled_at,omitempty"`
+	RefundedAmount   int            `gorm:"default:0" json:"refunded_amount"` // 累计退款金额（分），全额退款后订单变为 refunded
+	ExpiredAt        time.Time      `json:"expired_at"`                       // 订单过期时间（15分钟）
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// PaymentNotification 支付平台回调的原始记录。每次回调（包括验签失败和重复通知）都会保存，便于对账和排查
+type PaymentNotification struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	Provider      string    `gorm:"not null;index:idx_payment_notifications_txn" json:"provider"`
+	TransactionID string    `gorm:"index:idx_payment_notifications_txn" json:"transaction_id"` // 验签失败时为空
+	OrderNo       string    `gorm:"index" json:"order_no"`
+	PaymentID     *uint     `gorm:"index" json:"payment_id"`
+	Amount        int       `json:"amount"`                 // 通知中的实付金额（分）
+	Result        string    `gorm:"not null" json:"result"` // processed, duplicate, ignored, rejected, failed
+	Error         string    `json:"error,omitempty"`
+	Raw           string    `gorm:"type:text" json:"raw"` // 原始请求体
+	RemoteIP      string    `json:"remote_ip"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Invoice 已支付订单的发票（收据）。开票方信息、明细和税额在开票时固定，之后修改配置或商品目录不影响已开具的发票。
+// 金额均为含税价（分），TaxAmount 为其中包含的税额
+type Invoice struct {
+	ID            uint          `gorm:"primarykey" json:"id"`
+	InvoiceNo     string        `gorm:"uniqueIndex;not null" json:"invoice_no"` // INV + 年份 + 6 位序号，每年连续编号
+	PaymentID     uint          `gorm:"uniqueIndex;not null" json:"payment_id"`
+	UserID        uint          `gorm:"not null;index" json:"user_id"`
+	OrderNo       string        `gorm:"not null" json:"order_no"`
+	BuyerName     string        `json:"buyer_name"`
+	BuyerEmail    string        `json:"buyer_email"`
+	SellerName    string        `json:"seller_name"`
+	SellerTaxID   string        `json:"seller_tax_id"`
+	SellerAddress string        `json:"seller_address"`
+	Currency      string        `gorm:"not null;default:'CNY'" json:"currency"`
+	Lines         []InvoiceLine `gorm:"serializer:json;type:text" json:"lines"`
+	Subtotal      int           `gorm:"not null" json:"subtotal"`    // 明细合计（优惠前）
+	Discount      int           `gorm:"default:0" json:"discount"`   // 优惠金额
+	Total         int           `gorm:"not null" json:"total"`       // 实付金额
+	TaxRate       int           `gorm:"default:0" json:"tax_rate"`   // 税率（基点，600 = 6%）
+	TaxAmount     int           `gorm:"default:0" json:"tax_amount"` // 实付金额中包含的税额
+	PaidAt        time.Time     `json:"paid_at"`
+	IssuedAt      time.Time     `json:"issued_at"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// InvoiceLine 发票明细行
+type InvoiceLine struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int    `json:"unit_price"` // 分
+	Amount      int    `json:"amount"`     // 分
+}
+
+// InvoiceSequence 每年的发票序号，开票时在事务内加一，保证编号连续不重复
+type InvoiceSequence struct {
+	Year       int `gorm:"primarykey;autoIncrement:false" json:"year"`
+	LastNumber int `gorm:"not null;default:0" json:"last_number"`
+}
+
+// PaymentItem 订单明细，记录订单购买的独享账号及下单时的价格
+type PaymentItem struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	PaymentID uint      `gorm:"not null;index" json:"payment_id"`
+	AccountID uint      `gorm:"not null;index" json:"account_id"`
+	Price     int       `gorm:"not null" json:"price"`            // 金额（分）
+	RefundID  *uint     `gorm:"index" json:"refund_id,omitempty"` // 已退款的账号指向退款记录
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Refund 退款记录，一个订单可以多次部分退款，累计金额不超过订单金额
+type Refund struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	RefundNo         string    `gorm:"uniqueIndex;not null" json:"refund_no"`
+	PaymentID        uint      `gorm:"not null;index" json:"payment_id"`
+	Amount           int       `gorm:"not null" json:"amount"` // 金额（分）
+	Reason           string    `json:"reason"`
+	OperatorID       uint      `gorm:"not null" json:"operator_id"` // 操作的管理员
+	Method           string    `json:"method"`                      // 退款渠道，与订单的 payment_method 相同
+	ProviderRefundID string    `json:"provider_refund_id"`          // 支付平台退款单号，线下退款时为空
+	Status           string    `gorm:"not null" json:"status"`      // pending（等待支付平台结果）, completed（原路退回）, failed（平台退款失败，可重试）, manual（需线下退款）
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// OrphanPayment 订单无法接收的付款：订单过期、取消后才到账，或已支付的订单又收到另一笔交易。
+// 款项需要退还给用户，由管理员原路退款或线下处理。同一平台的交易号只记录一次
+type OrphanPayment struct {
+	ID               uint       `gorm:"primarykey" json:"id"`
+	PaymentID        uint       `gorm:"not null;index" json:"payment_id"`
+	OrderNo          string     `gorm:"not null" json:"order_no"`
+	Provider         string     `gorm:"not null;uniqueIndex:idx_orphan_payments_txn" json:"provider"`
+	TransactionID    string     `gorm:"not null;uniqueIndex:idx_orphan_payments_txn" json:"transaction_id"`
+	Amount           int        `gorm:"not null" json:"amount"`       // 金额（分）
+	OrderStatus      string     `json:"order_status"`                 // 到账时订单的状态：expired, canceled, paid, refunded 等
+	Status           string     `gorm:"not null;index" json:"status"` // pending（待退款）, failed（平台退款失败，可重试）, refunded（已原路退回）, manual（已线下处理）
+	RefundNo         string     `gorm:"index" json:"refund_no"`       // 商户退款单号，首次发起退款时生成，重试时不变
+	ProviderRefundID string     `json:"provider_refund_id"`
+	OperatorID       *uint      `json:"operator_id"`
+	Note             string     `json:"note"`
+	ResolvedAt       *time.Time `json:"resolved_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// Product 商品目录中的产品，按 Type 唯一。价格、额度和功能权限保存在 ProductVersion 中，
+// 修改这些字段会生成新版本，已售出的订单和 License Key 仍指向购买时的版本。
+// Kind 为 license 的产品支付后发放 License Key，subscription 的产品支付后开通或续期订阅
+type Product struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	Type             string    `gorm:"uniqueIndex;not null" json:"type"`       // basic, pro, enterprise, monthly, yearly
+	Kind             string    `gorm:"not null;default:'license'" json:"kind"` // license, subscription
+	Name             string    `gorm:"not null" json:"name"`
+	Description      string    `json:"description"`
+	Active           bool      `gorm:"default:true" json:"active"` // 下架后不能再下单，已售出的 Key 不受影响
+	SortOrder        int       `gorm:"default:0" json:"sort_order"`
+	CurrentVersionID *uint     `json:"current_version_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// ProductVersion 产品的一个价格/权益版本，创建后不再修改
+type ProductVersion struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	ProductID    uint      `gorm:"not null;uniqueIndex:idx_product_versions_version" json:"product_id"`
+	Version      int       `gorm:"not null;uniqueIndex:idx_product_versions_version" json:"version"`
+	Price        int       `gorm:"not null" json:"price"`                         // 价格（分）
+	QuotaAmount  int       `gorm:"not null" json:"quota_amount"`                  // 次数额度
+	DurationDays int       `gorm:"default:0" json:"duration_days"`                // 订阅时长（天），仅订阅套餐
+	Features     []string  `gorm:"serializer:json;type:text" json:"features"`     // 展示用的功能说明
+	Entitlements []string  `gorm:"serializer:json;type:text" json:"entitlements"` // 功能权限，如 email_verify
+	CreatedBy    uint      `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Coupon 优惠码。percent 按百分比折扣，fixed 立减固定金额。
+// 下单时占用一次用量，订单过期或取消后归还，UsedCount 只在优惠码行锁内修改
+type Coupon struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	Code          string     `gorm:"uniqueIndex;not null" json:"code"` // 统一保存为大写
+	Description   string     `json:"description"`
+	DiscountType  string     `gorm:"not null" json:"discount_type"`                  // percent, fixed
+	DiscountValue int        `gorm:"not null" json:"discount_value"`                 // percent 为 1-100 的折扣比例，fixed 为金额（分）
+	ProductTypes  []string   `gorm:"serializer:json;type:text" json:"product_types"` // 适用的产品类型，为空表示全部产品
+	MaxUses       int        `gorm:"default:0" json:"max_uses"`                      // 总使用次数，0 表示不限
+	PerUserLimit  int        `gorm:"default:0" json:"per_user_limit"`                // 每个用户可使用次数，0 表示不限
+	UsedCount     int        `gorm:"not null;default:0" json:"used_count"`           // 已占用次数（待支付和已支付的订单）
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	Active        bool       `gorm:"default:true" json:"active"`
+	CreatedBy     uint       `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// LicenseKey 授权密钥
+type LicenseKey struct {
+	ID               uint           `gorm:"primarykey" json:"id"`
+	UserID           uint           `gorm:"not null;index" json:"user_id"`
+	PaymentID        uint           `gorm:"not null;index" json:"payment_id"`
+	KeyCode          string         `gorm:"uniqueIndex;not null" json:"key_code"`
+	ProductType      string         `gorm:"not null" json:"product_type"`    // basic, pro, enterprise
+	ProductVersionID *uint          `gorm:"index" json:"product_version_id"` // 购买时的产品版本，决定密钥的功能权限
+	QuotaTotal       int            `gorm:"not null" json:"quota_total"`     // 总次数
+	QuotaUsed        int            `gorm:"default:0" json:"quota_used"`     // 已使用次数
+	Status           string         `gorm:"default:'active'" json:"status"`  // active, exhausted, revoked
+	ActivatedAt      *time.Time     `json:"activated_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// VerificationResult 邮箱验证结果缓存（跨用户共享，按规范化地址唯一）
+type VerificationResult struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Address   string    `gorm:"uniqueIndex:idx_verification_results_address_method;not null" json:"address"` // 规范化后的邮箱地址
+	Status    string    `gorm:"not null" json:"status"`                                                      // live, verify, dead, unknown
+	Method    string    `gorm:"uniqueIndex:idx_verification_results_address_method;not null" json:"method"`  // smtp, api，两种方式的结果分开缓存
+	Error     string    `json:"error"`
+	CheckedAt time.Time `gorm:"not null;index" json:"checked_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QuotaLedger License Key 额度流水
+type QuotaLedger struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	LicenseKeyID uint      `gorm:"not null;index" json:"license_key_id"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	Amount       int       `gorm:"not null" json:"amount"`       // 本次扣减的额度
+	Reason       string    `gorm:"not null;index" json:"reason"` // email_verify, ...
+	Metadata     string    `gorm:"type:text" json:"metadata"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ReverifySchedule 邮箱定期重新验证计划
+type ReverifySchedule struct {
 	ID            uint           `gorm:"primarykey" json:"id"`
 	UserID        uint           `gorm:"not null;index" json:"user_id"`
-	OrderNo       string         `gorm:"uniqueIndex;not null" json:"order_no"`
-	Amount        int            `gorm:"not null" json:"amount"`          // 金额（分）
-	ProductType   string         `gorm:"not null" json:"product_type"`    // basic, pro, enterprise
-	QuotaAmount   int            `gorm:"not null" json:"quota_amount"`    // 购买的次数额度
-	Status        string         `gorm:"default:'pending'" json:"status"` // pending, paid, expired, refunded
-	PaymentMethod string         `json:"payment_method"`                  // alipay, wechat
-	TransactionID string         `gorm:"index" json:"transaction_id"`     // 第三方支付流水号
-	PaidAt        *time.Time     `json:"paid_at"`
-	ExpiredAt     time.Time      `json:"expired_at"` // 订单过期时间（15分钟）
+	Name          string         `gorm:"not null" json:"name"`
+	ImportID      *uint          `gorm:"index" json:"import_id"`         // 限定导入批次，空表示全部
+	StatusFilter  string         `json:"status_filter"`                  // live, verify, dead, unknown；空表示全部
+	IntervalHours int            `gorm:"not null" json:"interval_hours"` // 执行间隔（小时）
+	Method        string         `gorm:"default:'smtp'" json:"method"`   // smtp, api
+	APIKey        string         `json:"-"`                              // API 方法需要的第三方 key，secretbox 加密存储
+	LicenseKeyID  uint           `gorm:"not null;index" json:"license_key_id"`
+	Enabled       bool           `gorm:"default:true" json:"enabled"`
+	NextRunAt     time.Time      `gorm:"not null;index" json:"next_run_at"`
+	LastRunAt     *time.Time     `json:"last_run_at"`
 	CreatedAt     time.Time      `json:"created_at"`
 	UpdatedAt     time.Time      `json:"updated_at"`
 	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
-// LicenseKey 授权密钥
-type LicenseKey struct {
-	ID          uint           `gorm:"primarykey" json:"id"`
-	UserID      uint           `gorm:"not null;index" json:"user_id"`
-	PaymentID   uint           `gorm:"not null;index" json:"payment_id"`
-	KeyCode     string         `gorm:"uniqueIndex;not null" json:"key_code"`
-	ProductType string         `gorm:"not null" json:"product_type"`   // basic, pro, enterprise
-	QuotaTotal  int            `gorm:"not null" json:"quota_total"`    // 总次数
-	QuotaUsed   int            `gorm:"default:0" json:"quota_used"`    // 已使用次数
-	Status      string         `gorm:"default:'active'" json:"status"` // active, exhausted, revoked
-	ActivatedAt *time.Time     `json:"activated_at"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+// ReverifyRun 一次重新验证的执行结果
+type ReverifyRun struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	ScheduleID uint       `gorm:"not null;index" json:"schedule_id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Status     string     `gorm:"not null" json:"status"` // success, failed, skipped
+	Checked    int        `json:"checked"`
+	Changed    int        `json:"changed"`
+	QuotaUsed  int        `json:"quota_used"`
+	Summary    string     `gorm:"type:text" json:"summary"` // 状态变化统计，如 {"live->dead": 2}
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// EmailJob 用户提交的邮箱导入、批量验证任务，由队列工作进程异步执行
+type EmailJob struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	Type         string     `gorm:"not null" json:"type"`                    // import, verify
+	Status       string     `gorm:"not null;default:'queued'" json:"status"` // queued, running, done, failed
+	Input        string     `gorm:"type:text" json:"-"`                      // 任务参数 JSON，结束后清空；验证任务的 API key 加密保存
+	LicenseKeyID *uint      `json:"license_key_id"`
+	QueueJobID   uint       `gorm:"index" json:"-"`
+	Total        int        `json:"total"`
+	Result       string     `gorm:"type:text" json:"-"` // 结果 JSON，格式与原同步接口的响应一致
+	Error        string     `gorm:"type:text" json:"error"`
+	StartedAt    *time.Time `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ScheduledJob 后台定时任务的调度状态，多副本通过 locked_until 抢占执行权
+type ScheduledJob struct {
+	Name        string     `gorm:"primarykey" json:"name"`
+	Schedule    string     `gorm:"not null" json:"schedule"` // cron 表达式
+	NextRunAt   time.Time  `gorm:"not null;index" json:"next_run_at"`
+	Attempt     int        `gorm:"default:0" json:"attempt"` // 当前连续失败次数
+	LockedBy    string     `json:"locked_by"`
+	LockedUntil *time.Time `json:"locked_until"`
+	LastRunAt   *time.Time `json:"last_run_at"`
+	LastError   string     `gorm:"type:text" json:"last_error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// JobRun 后台任务的一次执行记录
+type JobRun struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	JobName    string     `gorm:"not null;index" json:"job_name"`
+	Attempt    int        `gorm:"not null" json:"attempt"`
+	Status     string     `gorm:"not null;index" json:"status"` // running, success, failed
+	Instance   string     `json:"instance"`
+	Error      string     `gorm:"type:text" json:"error"`
+	StartedAt  time.Time  `gorm:"not null;index" json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// QueueJob 持久化工作队列中的任务
+type QueueJob struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	Queue       string     `gorm:"not null;default:'default';index:idx_queue_jobs_claim,priority:1" json:"queue"`
+	Type        string     `gorm:"not null;index" json:"type"`
+	Payload     string     `gorm:"type:text" json:"payload"`
+	Priority    int        `gorm:"default:0" json:"priority"`                                                      // 数值越大越优先
+	Status      string     `gorm:"not null;default:'pending';index:idx_queue_jobs_claim,priority:2" json:"status"` // pending, running, done, dead
+	Attempts    int        `gorm:"default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"default:5" json:"max_attempts"`
+	RunAt       time.Time  `gorm:"not null;index:idx_queue_jobs_claim,priority:3" json:"run_at"`
+	LockedBy    string     `json:"locked_by"`
+	LockedUntil *time.Time `json:"locked_until"` // 可见性超时，超时未确认的任务会被重新领取
+	LastError   string     `gorm:"type:text" json:"last_error"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// AccountWaitlist 临时账号排队记录，释放时按先来后到自动分配
+type AccountWaitlist struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	AccountID   *uint      `gorm:"index" json:"account_id"`               // 为空表示任意临时账号
+	Status      string     `gorm:"default:'waiting';index" json:"status"` // waiting, fulfilled, canceled
+	UsageID     *uint      `json:"usage_id"`
+	FulfilledAt *time.Time `json:"fulfilled_at"`
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Notification 站内通知
+type Notification struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Type      string     `gorm:"not null" json:"type"`
+	Title     string     `gorm:"not null" json:"title"`
+	Body      string     `gorm:"type:text" json:"body"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
 }
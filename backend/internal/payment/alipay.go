@@ -0,0 +1,109 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AlipayProvider 支付宝异步通知：表单参数，RSA2（SHA256withRSA）签名
+type AlipayProvider struct {
+	appID     string
+	publicKey *rsa.PublicKey
+}
+
+// NewAlipayProvider publicKeyPEM 为支付宝公钥（PEM 格式，支持带或不带头尾的 base64）
+func NewAlipayProvider(appID, publicKeyPEM string) (*AlipayProvider, error) {
+	key, err := parseRSAPublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &AlipayProvider{appID: appID, publicKey: key}, nil
+}
+
+func (p *AlipayProvider) Name() string { return "alipay" }
+
+func (p *AlipayProvider) ParseNotification(header http.Header, body []byte) (*Notification, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	params := make(map[string]string, len(values))
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(params["sign"])
+	if err != nil || len(signature) == 0 {
+		return nil, ErrInvalidSignature
+	}
+	if params["sign_type"] != "" && params["sign_type"] != "RSA2" {
+		return nil, fmt.Errorf("%w: unsupported sign_type %s", ErrInvalidSignature, params["sign_type"])
+	}
+
+	digest := sha256.Sum256([]byte(canonicalQuery(params, "sign", "sign_type")))
+	if err := rsa.VerifyPKCS1v15(p.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	// 签名有效但发给了其他应用的通知同样拒绝
+	if p.appID != "" && params["app_id"] != p.appID {
+		return nil, fmt.Errorf("%w: unexpected app_id", ErrMalformed)
+	}
+	if params["out_trade_no"] == "" || params["trade_no"] == "" {
+		return nil, ErrMalformed
+	}
+	amount, err := parseYuan(params["total_amount"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	status := params["trade_status"]
+	return &Notification{
+		OrderNo:       params["out_trade_no"],
+		TransactionID: params["trade_no"],
+		Amount:        amount,
+		Paid:          status == "TRADE_SUCCESS" || status == "TRADE_FINISHED",
+	}, nil
+}
+
+// Acknowledge 支付宝要求返回纯文本 success，否则会按策略重发通知
+func (p *AlipayProvider) Acknowledge(success bool) (string, []byte) {
+	if success {
+		return "text/plain; charset=utf-8", []byte("success")
+	}
+	return "text/plain; charset=utf-8", []byte("fail")
+}
+
+func parseRSAPublicKey(value string) (*rsa.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "-----BEGIN") {
+		value = "-----BEGIN PUBLIC KEY-----\n" + value + "\n-----END PUBLIC KEY-----"
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key PEM")
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		if key, pkcs1Err := x509.ParsePKCS1PublicKey(block.Bytes); pkcs1Err == nil {
+			return key, nil
+		}
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return key, nil
+}
@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTradeNotFound 支付平台没有该订单的交易记录（用户尚未扫码或下单未同步）
+var ErrTradeNotFound = errors.New("trade not found")
+
+// Order 发起支付所需的订单信息
+type Order struct {
+	OrderNo   string
+	Amount    int // 分
+	Subject   string
+	ExpiresAt time.Time
+}
+
+// Checkout 支付平台返回的支付方式：跳转链接或二维码内容，至少有一项
+type Checkout struct {
+	Method    string    `json:"method"`
+	PayURL    string    `json:"pay_url,omitempty"`
+	QRCode    string    `json:"qr_code,omitempty"` // 二维码内容，由前端渲染
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Gateway 向支付平台发起支付和查询交易。与 Provider 同名的 Gateway 负责同一平台，
+// 支付结果仍以 Provider 校验过的异步通知为准，查询只用于补偿丢失的通知。
+type Gateway interface {
+	Name() string
+	// CreateCheckout 为订单生成支付链接或二维码
+	CreateCheckout(ctx context.Context, order Order) (*Checkout, error)
+	// QueryTrade 主动查询订单的交易状态，平台没有交易记录时返回 ErrTradeNotFound
+	QueryTrade(ctx context.Context, orderNo string) (*Notification, error)
+}
+
+// Refunder 支持原路退款的支付网关
+type Refunder interface {
+	// Refund 对订单发起退款，refundNo 为商户退款单号（重复调用应幂等），返回平台退款单号
+	Refund(ctx context.Context, orderNo, refundNo string, amount int) (string, error)
+}
@@ -0,0 +1,68 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// LocalSignatureHeader 本地支付回调的签名请求头
+const LocalSignatureHeader = "X-Local-Signature"
+
+// LocalProvider 开发和测试环境使用的替身支付平台：JSON 请求体，
+// 请求头携带以共享密钥计算的 HMAC-SHA256 签名。生产环境不会启用。
+type LocalProvider struct {
+	secret []byte
+}
+
+// LocalNotification 本地支付回调的请求体
+type LocalNotification struct {
+	OrderNo       string `json:"order_no"`
+	TransactionID string `json:"transaction_id"`
+	Amount        int    `json:"amount"` // 分
+	Status        string `json:"status"` // success, failed
+}
+
+func NewLocalProvider(secret string) *LocalProvider {
+	return &LocalProvider{secret: []byte(secret)}
+}
+
+func (p *LocalProvider) Name() string { return "local" }
+
+func (p *LocalProvider) ParseNotification(header http.Header, body []byte) (*Notification, error) {
+	expected := p.Sign(body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(LocalSignatureHeader))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var n LocalNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, ErrMalformed
+	}
+	if n.OrderNo == "" || n.TransactionID == "" || n.Amount < 0 {
+		return nil, ErrMalformed
+	}
+
+	return &Notification{
+		OrderNo:       n.OrderNo,
+		TransactionID: n.TransactionID,
+		Amount:        n.Amount,
+		Paid:          n.Status == "success",
+	}, nil
+}
+
+func (p *LocalProvider) Acknowledge(success bool) (string, []byte) {
+	if success {
+		return "application/json; charset=utf-8", []byte(`{"code":"SUCCESS"}`)
+	}
+	return "application/json; charset=utf-8", []byte(`{"code":"FAIL"}`)
+}
+
+// Sign 计算请求体的签名，供测试脚本和模拟网关构造回调
+func (p *LocalProvider) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,192 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MockGateway 本地模拟支付网关，与 LocalProvider 配套使用。
+// 支付页面由 Simulate 驱动：按指定结果、延迟和重复次数向回调地址发送签名通知，
+// 用于开发联调和集成测试。交易状态只保存在内存中，重启后丢失。
+type MockGateway struct {
+	provider  *LocalProvider
+	baseURL   string
+	notifyURL string
+	client    *http.Client
+
+	mu     sync.Mutex
+	trades map[string]*mockTrade
+}
+
+type mockTrade struct {
+	order         Order
+	status        string // pending, success, failed
+	transactionID string
+	refunds       map[string]int // 商户退款单号 -> 金额
+}
+
+// Simulation 模拟一次支付结果
+type Simulation struct {
+	OrderNo    string
+	Outcome    string        // success, failure
+	Delay      time.Duration // 回调前等待的时长
+	Duplicates int           // 额外并发发送的重复回调次数
+	Amount     *int          // 覆盖回调中的金额，用于测试金额校验
+}
+
+// NewMockGateway baseURL 为本服务对外地址，支付链接和回调地址都基于它生成
+func NewMockGateway(provider *LocalProvider, baseURL string) *MockGateway {
+	return &MockGateway{
+		provider:  provider,
+		baseURL:   baseURL,
+		notifyURL: baseURL + "/api/v1/payments/notify/" + provider.Name(),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		trades:    make(map[string]*mockTrade),
+	}
+}
+
+func (g *MockGateway) Name() string { return g.provider.Name() }
+
+func (g *MockGateway) CreateCheckout(ctx context.Context, order Order) (*Checkout, error) {
+	g.mu.Lock()
+	if _, ok := g.trades[order.OrderNo]; !ok {
+		g.trades[order.OrderNo] = &mockTrade{order: order, status: "pending"}
+	}
+	g.mu.Unlock()
+
+	payURL := g.baseURL + "/api/v1/payments/mock/" + order.OrderNo
+	return &Checkout{
+		Method:    g.Name(),
+		PayURL:    payURL,
+		QRCode:    payURL,
+		ExpiresAt: order.ExpiresAt,
+	}, nil
+}
+
+func (g *MockGateway) QueryTrade(ctx context.Context, orderNo string) (*Notification, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	trade, ok := g.trades[orderNo]
+	if !ok || trade.status == "pending" {
+		return nil, ErrTradeNotFound
+	}
+	return &Notification{
+		OrderNo:       orderNo,
+		TransactionID: trade.transactionID,
+		Amount:        trade.order.Amount,
+		Paid:          trade.status == "success",
+	}, nil
+}
+
+// Simulate 记录交易结果并在后台发送回调，立即返回
+func (g *MockGateway) Simulate(sim Simulation) error {
+	if sim.Outcome != "success" && sim.Outcome != "failure" {
+		return fmt.Errorf("unknown outcome %q", sim.Outcome)
+	}
+	if sim.Duplicates < 0 || sim.Duplicates > 10 {
+		return fmt.Errorf("duplicates must be between 0 and 10")
+	}
+
+	g.mu.Lock()
+	trade, ok := g.trades[sim.OrderNo]
+	if !ok {
+		g.mu.Unlock()
+		return ErrTradeNotFound
+	}
+	if trade.transactionID == "" {
+		trade.transactionID = newMockTransactionID()
+	}
+	trade.status = "failed"
+	if sim.Outcome == "success" {
+		trade.status = "success"
+	}
+	notification := LocalNotification{
+		OrderNo:       sim.OrderNo,
+		TransactionID: trade.transactionID,
+		Amount:        trade.order.Amount,
+		Status:        trade.status,
+	}
+	g.mu.Unlock()
+
+	if sim.Amount != nil {
+		notification.Amount = *sim.Amount
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		time.Sleep(sim.Delay)
+
+		var wg sync.WaitGroup
+		for i := 0; i <= sim.Duplicates; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				g.send(body)
+			}()
+		}
+		wg.Wait()
+	}()
+	return nil
+}
+
+func (g *MockGateway) send(body []byte) {
+	req, err := http.NewRequest(http.MethodPost, g.notifyURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("mock gateway: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(LocalSignatureHeader, g.provider.Sign(body))
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		log.Printf("mock gateway: callback failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("mock gateway: callback %s -> %d", g.notifyURL, resp.StatusCode)
+}
+
+// Refund 模拟原路退款，累计退款金额不能超过交易金额
+func (g *MockGateway) Refund(ctx context.Context, orderNo, refundNo string, amount int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	trade, ok := g.trades[orderNo]
+	if !ok || trade.status != "success" {
+		// 服务重启后内存中的交易丢失，模拟网关直接视为退款成功
+		return "MOCKREFUND" + refundNo, nil
+	}
+	if trade.refunds == nil {
+		trade.refunds = make(map[string]int)
+	}
+	if _, done := trade.refunds[refundNo]; !done {
+		refunded := 0
+		for _, a := range trade.refunds {
+			refunded += a
+		}
+		if refunded+amount > trade.order.Amount {
+			return "", fmt.Errorf("refund exceeds trade amount")
+		}
+		trade.refunds[refundNo] = amount
+	}
+	return "MOCKREFUND" + refundNo, nil
+}
+
+func newMockTransactionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "MOCK" + hex.EncodeToString(b)
+}
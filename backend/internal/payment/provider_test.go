@@ -0,0 +1,292 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func publicKeyPEM(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// alipayBody 按支付宝的规则签名参数并编码为表单
+func alipayBody(t *testing.T, key *rsa.PrivateKey, params map[string]string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(canonicalQuery(params, "sign", "sign_type")))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	if _, ok := params["sign"]; !ok {
+		values.Set("sign", base64.StdEncoding.EncodeToString(signature))
+	}
+	return values.Encode()
+}
+
+func TestAlipayParseNotification(t *testing.T) {
+	key := generateRSAKey(t)
+	other := generateRSAKey(t)
+	provider, err := NewAlipayProvider("app-1", publicKeyPEM(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := func(overrides map[string]string) map[string]string {
+		p := map[string]string{
+			"app_id":       "app-1",
+			"out_trade_no": "ORD1",
+			"trade_no":     "2026010122001",
+			"total_amount": "29.90",
+			"trade_status": "TRADE_SUCCESS",
+			"sign_type":    "RSA2",
+		}
+		for k, v := range overrides {
+			p[k] = v
+		}
+		return p
+	}
+
+	tampered := alipayBody(t, key, params(nil))
+	tampered = strings.Replace(tampered, "total_amount=29.90", "total_amount=0.01", 1)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantErr  error
+		wantPaid bool
+	}{
+		{name: "valid", body: alipayBody(t, key, params(nil)), wantPaid: true},
+		{name: "finished", body: alipayBody(t, key, params(map[string]string{"trade_status": "TRADE_FINISHED"})), wantPaid: true},
+		{name: "unpaid", body: alipayBody(t, key, params(map[string]string{"trade_status": "WAIT_BUYER_PAY"}))},
+		{name: "tampered amount", body: tampered, wantErr: ErrInvalidSignature},
+		{name: "signed by another key", body: alipayBody(t, other, params(nil)), wantErr: ErrInvalidSignature},
+		{name: "missing signature", body: alipayBody(t, key, params(map[string]string{"sign": ""})), wantErr: ErrInvalidSignature},
+		{name: "unsupported sign type", body: alipayBody(t, key, params(map[string]string{"sign_type": "RSA"})), wantErr: ErrInvalidSignature},
+		{name: "other app", body: alipayBody(t, key, params(map[string]string{"app_id": "app-2"})), wantErr: ErrMalformed},
+		{name: "missing trade no", body: alipayBody(t, key, params(map[string]string{"trade_no": ""})), wantErr: ErrMalformed},
+		{name: "bad amount", body: alipayBody(t, key, params(map[string]string{"total_amount": "29.901"})), wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := provider.ParseNotification(http.Header{}, []byte(tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n.OrderNo != "ORD1" || n.TransactionID != "2026010122001" || n.Amount != 2990 || n.Paid != tt.wantPaid {
+				t.Fatalf("notification = %+v", n)
+			}
+		})
+	}
+}
+
+func TestParseRSAPublicKeyWithoutHeaders(t *testing.T) {
+	key := generateRSAKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseRSAPublicKey(base64.StdEncoding.EncodeToString(der))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.N.Cmp(key.N) != 0 {
+		t.Fatal("parsed key does not match")
+	}
+
+	if _, err := parseRSAPublicKey("not a key"); err == nil {
+		t.Fatal("expected error for invalid key")
+	}
+}
+
+// wechatBody 按微信支付的规则签名参数并编码为 XML
+func wechatBody(p *WechatProvider, params map[string]string) string {
+	if _, ok := params["sign"]; !ok {
+		params["sign"] = p.sign(params)
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("<xml>")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "<%s><![CDATA[%s]]></%s>", k, params[k], k)
+	}
+	b.WriteString("</xml>")
+	return b.String()
+}
+
+func TestWechatParseNotification(t *testing.T) {
+	provider := NewWechatProvider("mch-1", "wechat-api-key")
+	otherKey := NewWechatProvider("mch-1", "another-api-key")
+
+	params := func(overrides map[string]string) map[string]string {
+		p := map[string]string{
+			"mch_id":         "mch-1",
+			"out_trade_no":   "ORD1",
+			"transaction_id": "4200000001",
+			"total_fee":      "2990",
+			"return_code":    "SUCCESS",
+			"result_code":    "SUCCESS",
+			"sign_type":      "HMAC-SHA256",
+		}
+		for k, v := range overrides {
+			p[k] = v
+		}
+		return p
+	}
+
+	lowercase := params(nil)
+	lowercase["sign"] = strings.ToLower(provider.sign(lowercase))
+
+	tampered := wechatBody(provider, params(nil))
+	tampered = strings.Replace(tampered, "<![CDATA[2990]]>", "<![CDATA[1]]>", 1)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantErr  error
+		wantPaid bool
+	}{
+		{name: "valid", body: wechatBody(provider, params(nil)), wantPaid: true},
+		{name: "lowercase signature", body: wechatBody(provider, lowercase), wantPaid: true},
+		{name: "failed trade", body: wechatBody(provider, params(map[string]string{"result_code": "FAIL"}))},
+		{name: "tampered amount", body: tampered, wantErr: ErrInvalidSignature},
+		{name: "signed with another key", body: wechatBody(otherKey, params(nil)), wantErr: ErrInvalidSignature},
+		{name: "md5 sign type", body: wechatBody(provider, params(map[string]string{"sign_type": "MD5"})), wantErr: ErrInvalidSignature},
+		{name: "other merchant", body: wechatBody(provider, params(map[string]string{"mch_id": "mch-2"})), wantErr: ErrMalformed},
+		{name: "missing transaction", body: wechatBody(provider, params(map[string]string{"transaction_id": ""})), wantErr: ErrMalformed},
+		{name: "bad amount", body: wechatBody(provider, params(map[string]string{"total_fee": "29.90"})), wantErr: ErrMalformed},
+		{name: "not xml", body: "total_fee=2990", wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := provider.ParseNotification(http.Header{}, []byte(tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n.OrderNo != "ORD1" || n.TransactionID != "4200000001" || n.Amount != 2990 || n.Paid != tt.wantPaid {
+				t.Fatalf("notification = %+v", n)
+			}
+		})
+	}
+}
+
+func TestLocalParseNotification(t *testing.T) {
+	provider := NewLocalProvider("local-secret")
+	otherSecret := NewLocalProvider("other-secret")
+
+	valid := []byte(`{"order_no":"ORD1","transaction_id":"LOCAL1","amount":2990,"status":"success"}`)
+	failed := []byte(`{"order_no":"ORD1","transaction_id":"LOCAL1","amount":2990,"status":"failed"}`)
+	missing := []byte(`{"order_no":"ORD1","amount":2990,"status":"success"}`)
+	broken := []byte(`{"order_no":`)
+
+	header := func(signature string) http.Header {
+		h := http.Header{}
+		if signature != "" {
+			h.Set(LocalSignatureHeader, signature)
+		}
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		body     []byte
+		wantErr  error
+		wantPaid bool
+	}{
+		{name: "valid", header: header(provider.Sign(valid)), body: valid, wantPaid: true},
+		{name: "failed payment", header: header(provider.Sign(failed)), body: failed},
+		{name: "missing signature", header: header(""), body: valid, wantErr: ErrInvalidSignature},
+		{name: "other secret", header: header(otherSecret.Sign(valid)), body: valid, wantErr: ErrInvalidSignature},
+		{name: "signature of another body", header: header(provider.Sign(failed)), body: valid, wantErr: ErrInvalidSignature},
+		{name: "missing transaction", header: header(provider.Sign(missing)), body: missing, wantErr: ErrMalformed},
+		{name: "broken json", header: header(provider.Sign(broken)), body: broken, wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := provider.ParseNotification(tt.header, tt.body)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n.OrderNo != "ORD1" || n.TransactionID != "LOCAL1" || n.Amount != 2990 || n.Paid != tt.wantPaid {
+				t.Fatalf("notification = %+v", n)
+			}
+		})
+	}
+}
+
+func TestParseYuan(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"29.90", 2990, true},
+		{"29.9", 2990, true},
+		{"29", 2900, true},
+		{"0.01", 1, true},
+		{" 1.00 ", 100, true},
+		{"", 0, false},
+		{".5", 0, false},
+		{"1.001", 0, false},
+		{"-1.00", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseYuan(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseYuan(%q) = %d, %v; want %d, ok=%v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WechatProvider 微信支付异步通知：XML 参数，HMAC-SHA256 签名（密钥为商户 API 密钥）
+type WechatProvider struct {
+	mchID  string
+	apiKey string
+}
+
+func NewWechatProvider(mchID, apiKey string) *WechatProvider {
+	return &WechatProvider{mchID: mchID, apiKey: apiKey}
+}
+
+func (p *WechatProvider) Name() string { return "wechat" }
+
+func (p *WechatProvider) ParseNotification(header http.Header, body []byte) (*Notification, error) {
+	params, err := parseWechatXML(body)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if params["sign_type"] != "" && params["sign_type"] != "HMAC-SHA256" {
+		return nil, ErrInvalidSignature
+	}
+	expected := p.sign(params)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToUpper(params["sign"]))) {
+		return nil, ErrInvalidSignature
+	}
+
+	if p.mchID != "" && params["mch_id"] != p.mchID {
+		return nil, ErrMalformed
+	}
+	if params["out_trade_no"] == "" || params["transaction_id"] == "" {
+		return nil, ErrMalformed
+	}
+	amount, err := strconv.Atoi(params["total_fee"])
+	if err != nil || amount < 0 {
+		return nil, ErrMalformed
+	}
+
+	return &Notification{
+		OrderNo:       params["out_trade_no"],
+		TransactionID: params["transaction_id"],
+		Amount:        amount,
+		Paid:          params["return_code"] == "SUCCESS" && params["result_code"] == "SUCCESS",
+	}, nil
+}
+
+// Acknowledge 微信支付要求以 XML 返回 return_code
+func (p *WechatProvider) Acknowledge(success bool) (string, []byte) {
+	if success {
+		return "application/xml; charset=utf-8", []byte("<xml><return_code><![CDATA[SUCCESS]]></return_code><return_msg><![CDATA[OK]]></return_msg></xml>")
+	}
+	return "application/xml; charset=utf-8", []byte("<xml><return_code><![CDATA[FAIL]]></return_code><return_msg><![CDATA[ERROR]]></return_msg></xml>")
+}
+
+// sign 参数排序拼接后追加 &key=API密钥，计算 HMAC-SHA256 并转大写
+func (p *WechatProvider) sign(params map[string]string) string {
+	payload := canonicalQuery(params, "sign") + "&key=" + p.apiKey
+	mac := hmac.New(sha256.New, []byte(p.apiKey))
+	mac.Write([]byte(payload))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// parseWechatXML 解析 <xml><key>value</key>...</xml> 形式的扁平 XML
+func parseWechatXML(data []byte) (map[string]string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	params := make(map[string]string)
+
+	depth := 0
+	var current string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				current = t.Name.Local
+				params[current] = ""
+			}
+		case xml.CharData:
+			if depth == 2 && current != "" {
+				params[current] += string(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				current = ""
+			}
+			depth--
+		}
+	}
+	if len(params) == 0 {
+		return nil, ErrMalformed
+	}
+	return params, nil
+}
@@ -0,0 +1,292 @@
+// Package queue implements a durable work queue stored in Postgres.
+//
+// Workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED, so any number
+// of workers in any number of processes can poll the same table without
+// handing the same job out twice. A claimed job stays invisible until its
+// visibility timeout passes; if the worker dies before acknowledging it, the
+// job becomes claimable again. Jobs that keep failing are moved to the dead
+// letter status once they run out of attempts.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultQueue = "default"
+
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusDead    = "dead"
+)
+
+// Handler 处理一种类型的任务，payload 为入队时的 JSON
+type Handler func(ctx context.Context, payload []byte) error
+
+// EnqueueOptions 入队选项，零值使用默认队列、默认优先级、立即执行
+type EnqueueOptions struct {
+	Queue       string
+	Priority    int
+	MaxAttempts int
+	RunAt       time.Time
+}
+
+// Enqueue 将任务写入队列，db 可以是事务以便与业务数据一起提交
+func Enqueue(db *gorm.DB, jobType string, payload interface{}, opts ...EnqueueOptions) (*models.QueueJob, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	var opt EnqueueOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Queue == "" {
+		opt.Queue = DefaultQueue
+	}
+	if opt.MaxAttempts <= 0 {
+		opt.MaxAttempts = 5
+	}
+	if opt.RunAt.IsZero() {
+		opt.RunAt = time.Now()
+	}
+
+	job := models.QueueJob{
+		Queue:       opt.Queue,
+		Type:        jobType,
+		Payload:     string(data),
+		Priority:    opt.Priority,
+		Status:      StatusPending,
+		MaxAttempts: opt.MaxAttempts,
+		RunAt:       opt.RunAt,
+	}
+	if err := db.Create(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Retry 将死信任务重新放回队列
+func Retry(db *gorm.DB, id uint) (bool, error) {
+	result := db.Model(&models.QueueJob{}).
+		Where("id = ? AND status = ?", id, StatusDead).
+		Updates(map[string]interface{}{
+			"status":       StatusPending,
+			"attempts":     0,
+			"run_at":       time.Now(),
+			"locked_by":    "",
+			"locked_until": nil,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// WorkerOptions 工作进程配置
+type WorkerOptions struct {
+	Queues            []string      // 监听的队列，默认只监听 default
+	Concurrency       int           // 并发处理数
+	VisibilityTimeout time.Duration // 领取后多久未确认会被重新领取，同时作为处理超时
+	PollInterval      time.Duration // 队列为空时的轮询间隔
+}
+
+// Worker 从队列领取并处理任务
+type Worker struct {
+	db       *gorm.DB
+	opts     WorkerOptions
+	instance string
+	handlers map[string]Handler
+}
+
+// NewWorker 创建工作进程
+func NewWorker(db *gorm.DB, opts WorkerOptions) *Worker {
+	if len(opts.Queues) == 0 {
+		opts.Queues = []string{DefaultQueue}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 10 * time.Minute
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+
+	host, _ := os.Hostname()
+	return &Worker{
+		db:       db,
+		opts:     opts,
+		instance: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle 注册任务类型的处理函数
+func (w *Worker) Handle(jobType string, handler Handler) {
+	w.handlers[jobType] = handler
+}
+
+// Run 启动并发处理循环，阻塞直到 ctx 取消且所有进行中的任务结束
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			w.loop(ctx, fmt.Sprintf("%s/%d", w.instance, slot))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context, lockedBy string) {
+	for ctx.Err() == nil {
+		job, err := w.claim(ctx, lockedBy)
+		if err != nil {
+			log.Printf("queue: failed to claim job: %v", err)
+		}
+		if job != nil {
+			w.process(ctx, job, lockedBy)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.opts.PollInterval):
+		}
+	}
+}
+
+// claim 领取一个可执行的任务：到期的 pending 任务，或可见性超时的 running 任务
+func (w *Worker) claim(ctx context.Context, lockedBy string) (*models.QueueJob, error) {
+	if len(w.handlers) == 0 {
+		return nil, nil
+	}
+	types := make([]string, 0, len(w.handlers))
+	for jobType := range w.handlers {
+		types = append(types, jobType)
+	}
+
+	var claimed *models.QueueJob
+	err := w.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var job models.QueueJob
+		for {
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("queue IN ? AND type IN ?", w.opts.Queues, types).
+				Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?)", StatusPending, now, StatusRunning, now).
+				Order("priority desc, run_at asc, id asc").
+				First(&job).Error
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// 超时未确认的任务已经用尽次数（例如处理时工作进程反复崩溃），直接进入死信而不是再执行一次
+			if job.Status != StatusRunning || job.Attempts < job.MaxAttempts {
+				break
+			}
+			if err := tx.Model(&models.QueueJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+				"status":       StatusDead,
+				"last_error":   fmt.Sprintf("visibility timeout exceeded on attempt %d/%d", job.Attempts, job.MaxAttempts),
+				"locked_by":    "",
+				"locked_until": nil,
+			}).Error; err != nil {
+				return err
+			}
+			log.Printf("queue: job %d (%s) moved to dead letter: visibility timeout exceeded on final attempt", job.ID, job.Type)
+			job = models.QueueJob{}
+		}
+
+		lockedUntil := now.Add(w.opts.VisibilityTimeout)
+		job.Status = StatusRunning
+		job.Attempts++
+		job.LockedBy = lockedBy
+		job.LockedUntil = &lockedUntil
+		if err := tx.Model(&models.QueueJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":       job.Status,
+			"attempts":     job.Attempts,
+			"locked_by":    job.LockedBy,
+			"locked_until": job.LockedUntil,
+		}).Error; err != nil {
+			return err
+		}
+		claimed = &job
+		return nil
+	})
+	return claimed, err
+}
+
+// process 执行任务并确认结果；失败时按退避重新入队，用尽次数后进入死信
+func (w *Worker) process(ctx context.Context, job *models.QueueJob, lockedBy string) {
+	err := w.invoke(ctx, job)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"locked_by":    "",
+		"locked_until": nil,
+	}
+	switch {
+	case err == nil:
+		updates["status"] = StatusDone
+		updates["completed_at"] = now
+		updates["last_error"] = ""
+	case job.Attempts >= job.MaxAttempts:
+		updates["status"] = StatusDead
+		updates["last_error"] = err.Error()
+		log.Printf("queue: job %d (%s) moved to dead letter: %v", job.ID, job.Type, err)
+	default:
+		updates["status"] = StatusPending
+		updates["run_at"] = now.Add(backoff(job.Attempts))
+		updates["last_error"] = err.Error()
+		log.Printf("queue: job %d (%s) failed, attempt %d/%d: %v", job.ID, job.Type, job.Attempts, job.MaxAttempts, err)
+	}
+
+	// 只在仍持有该任务时确认，避免覆盖超时后被其他进程重新领取的结果
+	if err := w.db.Model(&models.QueueJob{}).
+		Where("id = ? AND locked_by = ? AND attempts = ?", job.ID, lockedBy, job.Attempts).
+		Updates(updates).Error; err != nil {
+		log.Printf("queue: failed to acknowledge job %d: %v", job.ID, err)
+	}
+}
+
+// invoke 带可见性超时执行处理函数，并把 panic 转换为错误
+func (w *Worker) invoke(ctx context.Context, job *models.QueueJob) (err error) {
+	handler, ok := w.handlers[job.Type]
+	if !ok {
+		return fmt.Errorf("no handler registered for job type %s", job.Type)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, w.opts.VisibilityTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(runCtx, []byte(job.Payload))
+}
+
+// backoff 第 n 次失败后的重试等待时间
+func backoff(attempt int) time.Duration {
+	delay := 10 * time.Second << (attempt - 1)
+	if delay > time.Hour || delay <= 0 {
+		delay = time.Hour
+	}
+	return delay
+}
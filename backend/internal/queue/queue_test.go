@@ -0,0 +1,240 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fullstack-backend/internal/database"
+	"fullstack-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+// openTestDB 连接 TEST_DATABASE_URL 指定的 Postgres 并执行迁移，未设置时跳过测试
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	db = db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// newTestWorker 使用独立的队列名，避免领取到其他测试或开发数据中的任务
+func newTestWorker(t *testing.T, db *gorm.DB, visibility time.Duration, handler Handler) (*Worker, string) {
+	t.Helper()
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { db.Where("queue = ?", name).Delete(&models.QueueJob{}) })
+
+	w := NewWorker(db, WorkerOptions{Queues: []string{name}, VisibilityTimeout: visibility})
+	w.Handle("test.job", handler)
+	return w, name
+}
+
+func enqueueTest(t *testing.T, db *gorm.DB, queueName string, maxAttempts int) *models.QueueJob {
+	t.Helper()
+	job, err := Enqueue(db, "test.job", map[string]string{"hello": "world"}, EnqueueOptions{Queue: queueName, MaxAttempts: maxAttempts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func loadJob(t *testing.T, db *gorm.DB, id uint) models.QueueJob {
+	t.Helper()
+	var job models.QueueJob
+	if err := db.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func succeed(ctx context.Context, payload []byte) error { return nil }
+
+func TestConcurrentClaimsAreDistinct(t *testing.T) {
+	db := openTestDB(t)
+	w, name := newTestWorker(t, db, time.Minute, succeed)
+
+	const jobs = 5
+	for i := 0; i < jobs; i++ {
+		enqueueTest(t, db, name, 3)
+	}
+
+	// SKIP LOCKED 保证并发领取时每个任务只被交给一个工作者
+	claimed := make(chan uint, jobs*2)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs*2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			job, err := w.claim(context.Background(), fmt.Sprintf("worker-%d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if job != nil {
+				claimed <- job.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(claimed)
+
+	seen := make(map[uint]bool)
+	for id := range claimed {
+		if seen[id] {
+			t.Errorf("job %d claimed twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != jobs {
+		t.Errorf("claimed %d jobs, want %d", len(seen), jobs)
+	}
+}
+
+func TestProcessAcknowledgesSuccess(t *testing.T) {
+	db := openTestDB(t)
+	w, name := newTestWorker(t, db, time.Minute, succeed)
+	queued := enqueueTest(t, db, name, 3)
+
+	job, err := w.claim(context.Background(), "worker-a")
+	if err != nil || job == nil || job.ID != queued.ID {
+		t.Fatalf("claim = %+v, %v", job, err)
+	}
+	if state := loadJob(t, db, job.ID); state.Status != StatusRunning || state.Attempts != 1 || state.LockedBy != "worker-a" {
+		t.Fatalf("claimed job = %+v", state)
+	}
+
+	w.process(context.Background(), job, "worker-a")
+	state := loadJob(t, db, job.ID)
+	if state.Status != StatusDone || state.CompletedAt == nil || state.LockedUntil != nil {
+		t.Fatalf("processed job = %+v", state)
+	}
+	if again, _ := w.claim(context.Background(), "worker-a"); again != nil {
+		t.Fatalf("finished job claimed again: %+v", again)
+	}
+}
+
+func TestVisibilityTimeoutReclaim(t *testing.T) {
+	db := openTestDB(t)
+	w, name := newTestWorker(t, db, 50*time.Millisecond, succeed)
+	queued := enqueueTest(t, db, name, 3)
+
+	stale, err := w.claim(context.Background(), "worker-a")
+	if err != nil || stale == nil {
+		t.Fatalf("claim = %+v, %v", stale, err)
+	}
+	// 可见性超时前其他工作者领取不到
+	if job, _ := w.claim(context.Background(), "worker-b"); job != nil {
+		t.Fatalf("job reclaimed before the visibility timeout: %+v", job)
+	}
+
+	// worker-a 未确认即超时，任务重新可见
+	time.Sleep(100 * time.Millisecond)
+	reclaimed, err := w.claim(context.Background(), "worker-b")
+	if err != nil || reclaimed == nil || reclaimed.ID != queued.ID || reclaimed.Attempts != 2 {
+		t.Fatalf("reclaim = %+v, %v", reclaimed, err)
+	}
+
+	// worker-a 迟到的确认不能覆盖 worker-b 的领取
+	w.process(context.Background(), stale, "worker-a")
+	if state := loadJob(t, db, queued.ID); state.Status != StatusRunning || state.LockedBy != "worker-b" {
+		t.Fatalf("stale acknowledgement overwrote the new claim: %+v", state)
+	}
+
+	w.process(context.Background(), reclaimed, "worker-b")
+	if state := loadJob(t, db, queued.ID); state.Status != StatusDone {
+		t.Fatalf("job = %+v, want done", state)
+	}
+}
+
+func TestDeadLetterAfterMaxAttempts(t *testing.T) {
+	db := openTestDB(t)
+	w, name := newTestWorker(t, db, time.Minute, func(ctx context.Context, payload []byte) error {
+		return errors.New("boom")
+	})
+	queued := enqueueTest(t, db, name, 2)
+
+	job, _ := w.claim(context.Background(), "worker-a")
+	w.process(context.Background(), job, "worker-a")
+	state := loadJob(t, db, queued.ID)
+	if state.Status != StatusPending || state.LastError != "boom" || !state.RunAt.After(time.Now()) {
+		t.Fatalf("after first failure = %+v, want pending with backoff", state)
+	}
+
+	// 跳过退避等待
+	db.Model(&models.QueueJob{}).Where("id = ?", queued.ID).Update("run_at", time.Now().Add(-time.Second))
+	job, _ = w.claim(context.Background(), "worker-a")
+	if job == nil || job.Attempts != 2 {
+		t.Fatalf("second claim = %+v", job)
+	}
+	w.process(context.Background(), job, "worker-a")
+	if state := loadJob(t, db, queued.ID); state.Status != StatusDead || state.LastError != "boom" {
+		t.Fatalf("after final failure = %+v, want dead", state)
+	}
+	if again, _ := w.claim(context.Background(), "worker-a"); again != nil {
+		t.Fatalf("dead job claimed again: %+v", again)
+	}
+
+	// 人工重试放回队列并重置次数
+	if ok, err := Retry(db, queued.ID); err != nil || !ok {
+		t.Fatalf("Retry = %v, %v", ok, err)
+	}
+	if state := loadJob(t, db, queued.ID); state.Status != StatusPending || state.Attempts != 0 {
+		t.Fatalf("after retry = %+v", state)
+	}
+}
+
+func TestTimedOutFinalAttemptIsDeadLettered(t *testing.T) {
+	db := openTestDB(t)
+	w, name := newTestWorker(t, db, 50*time.Millisecond, succeed)
+	queued := enqueueTest(t, db, name, 1)
+
+	if job, _ := w.claim(context.Background(), "worker-a"); job == nil {
+		t.Fatal("nothing claimed")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 唯一一次执行超时未确认，不再交给其他工作者
+	if job, err := w.claim(context.Background(), "worker-b"); err != nil || job != nil {
+		t.Fatalf("claim = %+v, %v, want nothing", job, err)
+	}
+	state := loadJob(t, db, queued.ID)
+	if state.Status != StatusDead || !strings.Contains(state.LastError, "visibility timeout") || state.LockedUntil != nil {
+		t.Fatalf("job = %+v, want dead after visibility timeout", state)
+	}
+}
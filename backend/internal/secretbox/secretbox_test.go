@@ -0,0 +1,71 @@
+package secretbox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	box := New("test-data-encryption-key-0123456789")
+
+	sealed, err := box.Seal("sk-live-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "sk-live-123") {
+		t.Fatalf("sealed value %q leaks the plaintext or lacks the prefix", sealed)
+	}
+	opened, err := box.Open(sealed)
+	if err != nil || opened != "sk-live-123" {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+
+	// 每次加密使用新的随机 nonce
+	again, _ := box.Seal("sk-live-123")
+	if again == sealed {
+		t.Fatal("sealing twice produced the same ciphertext")
+	}
+}
+
+func TestSealEmpty(t *testing.T) {
+	box := New("test-data-encryption-key-0123456789")
+	sealed, err := box.Seal("")
+	if err != nil || sealed != "" {
+		t.Fatalf("Seal(\"\") = %q, %v", sealed, err)
+	}
+}
+
+func TestOpenLegacyPlaintext(t *testing.T) {
+	box := New("test-data-encryption-key-0123456789")
+	for _, value := range []string{"", "plain-api-key"} {
+		if opened, err := box.Open(value); err != nil || opened != value {
+			t.Errorf("Open(%q) = %q, %v", value, opened, err)
+		}
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	box := New("test-data-encryption-key-0123456789")
+	sealed, err := box.Seal("sk-live-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := []byte(sealed)
+	flipped[len(flipped)-2] ^= 'A' ^ 'B'
+
+	tests := map[string]struct {
+		box   *Box
+		value string
+	}{
+		"wrong key":            {New("another-data-encryption-key-987654"), sealed},
+		"modified":             {box, string(flipped)},
+		"not base64":           {box, prefix + "!!!"},
+		"shorter than a nonce": {box, prefix + "AAAA"},
+	}
+	for name, tt := range tests {
+		if _, err := tt.box.Open(tt.value); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: err = %v, want ErrMalformed", name, err)
+		}
+	}
+}
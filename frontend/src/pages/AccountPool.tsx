@@ -12,14 +12,55 @@ interface AccountListItem {
   main: string
   status: string
   source: string
+  price?: number
   family?: FamilyInfo
+  claimed_by_me: boolean
+  claim_expires_at?: string
+  bound_by_me: boolean
+  owned_by_me: boolean
+}
+
+interface AssetAccount {
+  id: number
+  type: string
+  main: string
+  status: string
+}
+
+interface MyAccounts {
+  exclusive: { purchase_id: number; account: AssetAccount; purchased_at: string }[]
+  temporary: {
+    active: { usage_id: number; account: AssetAccount; expires_at: string }[]
+    history: { usage_id: number; account: AssetAccount; started_at: string; returned_at?: string; expires_at: string }[]
+  }
+  family: {
+    active: { binding_id: number; account: AssetAccount; member_email: string; family?: FamilyInfo }[]
+    history: { binding_id: number; account: AssetAccount; member_email: string; unbound_at?: string }[]
+  }
+}
+
+interface AccountListResponse {
+  items: AccountListItem[]
+  next_cursor?: string
+  has_more: boolean
 }
 
 interface Subscription {
   id: number
   plan: string
+  starts_at: string
   expires_at: string
   status: string
+  trial: boolean
+  auto_renew: boolean
+  grace_ends_at?: string
+}
+
+interface SubscriptionPlan {
+  type: string
+  name: string
+  price: number
+  duration_days: number
 }
 
 interface Credentials {
@@ -32,24 +73,48 @@ interface Credentials {
 function AccountPool() {
   const [accounts, setAccounts] = useState<AccountListItem[]>([])
   const [subscription, setSubscription] = useState<Subscription | null>(null)
+  const [paidUntil, setPaidUntil] = useState<string | null>(null)
+  const [trialAvailable, setTrialAvailable] = useState(false)
+  const [plans, setPlans] = useState<SubscriptionPlan[]>([])
+  const [paymentMethods, setPaymentMethods] = useState<string[]>([])
   const [credentials, setCredentials] = useState<Credentials | null>(null)
   const [loading, setLoading] = useState(true)
+  const [nextCursor, setNextCursor] = useState<string | null>(null)
+  const [loadingMore, setLoadingMore] = useState(false)
+  const [myAccounts, setMyAccounts] = useState<MyAccounts | null>(null)
   const [message, setMessage] = useState<string | null>(null)
 
   const loadSubscription = async () => {
     try {
-      const response = await api.get<{ subscription: Subscription | null }>('/subscriptions/me')
+      const response = await api.get<{
+        subscription: Subscription | null
+        paid_until?: string | null
+        trial_available: boolean
+      }>('/subscriptions/me')
       setSubscription(response.data.subscription)
+      setPaidUntil(response.data.paid_until ?? null)
+      setTrialAvailable(response.data.trial_available)
     } catch (err) {
       setMessage('Failed to load subscription.')
     }
   }
 
+  const loadPlans = async () => {
+    try {
+      const response = await api.get<{ plans: SubscriptionPlan[]; payment_methods: string[] }>('/subscriptions/plans')
+      setPlans(response.data.plans)
+      setPaymentMethods(response.data.payment_methods)
+    } catch (err) {
+      setMessage('Failed to load subscription plans.')
+    }
+  }
+
   const loadAccounts = async () => {
     setLoading(true)
     try {
-      const response = await api.get<AccountListItem[]>('/accounts')
-      setAccounts(response.data)
+      const response = await api.get<AccountListResponse>('/accounts')
+      setAccounts(response.data.items)
+      setNextCursor(response.data.has_more ? response.data.next_cursor ?? null : null)
     } catch (err: any) {
       setMessage(err.response?.data?.error || 'Failed to load accounts.')
     } finally {
@@ -57,22 +122,75 @@ function AccountPool() {
     }
   }
 
+  const loadMyAccounts = async () => {
+    try {
+      const response = await api.get<MyAccounts>('/accounts/mine')
+      setMyAccounts(response.data)
+    } catch (err: any) {
+      setMessage(err.response?.data?.error || 'Failed to load your accounts.')
+    }
+  }
+
+  const loadMoreAccounts = async () => {
+    if (!nextCursor) return
+    setLoadingMore(true)
+    try {
+      const response = await api.get<AccountListResponse>('/accounts', { params: { cursor: nextCursor } })
+      setAccounts(prev => [...prev, ...response.data.items])
+      setNextCursor(response.data.has_more ? response.data.next_cursor ?? null : null)
+    } catch (err: any) {
+      setMessage(err.response?.data?.error || 'Failed to load accounts.')
+    } finally {
+      setLoadingMore(false)
+    }
+  }
+
   useEffect(() => {
     loadSubscription()
+    loadPlans()
     loadAccounts()
+    loadMyAccounts()
   }, [])
 
-  const handleActivateSubscription = async () => {
+  // The subscription is activated by the payment callback, not by this request.
+  const handleSubscribe = async (plan: SubscriptionPlan) => {
     try {
-      const response = await api.post<{ subscription: Subscription }>('/subscriptions/activate', {
-        plan: 'monthly',
-        duration_days: 30,
-      })
-      setSubscription(response.data.subscription)
-      setMessage('Subscription activated.')
+      const response = await api.post<{ order: { order_no: string }; checkout?: { pay_url?: string } }>(
+        '/subscriptions/orders',
+        { plan: plan.type, payment_method: paymentMethods[0] }
+      )
+      if (response.data.checkout?.pay_url) {
+        window.location.href = response.data.checkout.pay_url
+        return
+      }
+      setMessage(`Order ${response.data.order.order_no} created. Complete the payment to activate your subscription.`)
+    } catch (err: any) {
+      setMessage(err.response?.data?.error || 'Failed to create subscription order.')
+    }
+  }
+
+  const handleStartTrial = async () => {
+    try {
+      await api.post('/subscriptions/trial')
+      setMessage('Your free trial has started.')
+      loadSubscription()
       loadAccounts()
     } catch (err: any) {
-      setMessage(err.response?.data?.error || 'Failed to activate subscription.')
+      setMessage(err.response?.data?.error || 'Failed to start the trial.')
+    }
+  }
+
+  const handleToggleRenewal = async () => {
+    if (!subscription) return
+    const action = subscription.auto_renew ? 'cancel' : 'resume'
+    try {
+      await api.post(`/subscriptions/${action}`)
+      setMessage(action === 'cancel'
+        ? 'Auto-renewal canceled. You keep access until the end of the paid period.'
+        : 'Auto-renewal resumed.')
+      loadSubscription()
+    } catch (err: any) {
+      setMessage(err.response?.data?.error || 'Failed to update auto-renewal.')
     }
   }
 
@@ -81,6 +199,7 @@ function AccountPool() {
       const response = await api.post('/accounts/temporary/claim', { account_id: accountId })
       setMessage(response.data.message || 'Account claimed.')
       loadAccounts()
+      loadMyAccounts()
     } catch (err: any) {
       setMessage(err.response?.data?.error || 'Failed to claim account.')
     }
@@ -91,18 +210,27 @@ function AccountPool() {
       const response = await api.post('/accounts/temporary/release', { account_id: accountId })
       setMessage(response.data.message || 'Account released.')
       loadAccounts()
+      loadMyAccounts()
     } catch (err: any) {
       setMessage(err.response?.data?.error || 'Failed to release account.')
     }
   }
 
+  // The account is reserved until the order is paid; ownership moves on the payment callback.
   const purchaseExclusive = async (accountId: number) => {
     try {
-      const response = await api.post('/accounts/exclusive/purchase', { account_id: accountId })
-      setMessage(response.data.message || 'Purchase successful.')
+      const response = await api.post<{ order: { order_no: string }; checkout?: { pay_url?: string } }>(
+        '/payments/orders',
+        { product_type: 'exclusive_account', account_id: accountId, payment_method: paymentMethods[0] }
+      )
+      if (response.data.checkout?.pay_url) {
+        window.location.href = response.data.checkout.pay_url
+        return
+      }
+      setMessage(`Order ${response.data.order.order_no} created. Complete the payment to receive the account.`)
       loadAccounts()
     } catch (err: any) {
-      setMessage(err.response?.data?.error || 'Failed to purchase account.')
+      setMessage(err.response?.data?.error || 'Failed to create order.')
     }
   }
 
@@ -120,6 +248,7 @@ function AccountPool() {
       })
       setMessage(response.data.message || 'Family binding created.')
       loadAccounts()
+      loadMyAccounts()
     } catch (err: any) {
       setMessage(err.response?.data?.error || 'Failed to bind family account.')
     }
@@ -130,6 +259,7 @@ function AccountPool() {
       const response = await api.post('/accounts/family/unbind', { account_id: accountId })
       setMessage(response.data.message || 'Family binding removed.')
       loadAccounts()
+      loadMyAccounts()
     } catch (err: any) {
       setMessage(err.response?.data?.error || 'Failed to unbind family account.')
     }
@@ -148,19 +278,41 @@ function AccountPool() {
     <div className="max-w-6xl mx-auto space-y-6">
       <div className="flex items-center justify-between">
         <h1 className="text-3xl font-bold text-white">Account Pool</h1>
-        {!subscription && (
-          <button
-            onClick={handleActivateSubscription}
-            className="px-4 py-2 rounded bg-indigo-600 text-white hover:bg-indigo-500"
-          >
-            Activate 30-day Subscription
-          </button>
-        )}
+        <div className="flex gap-2">
+          {trialAvailable && (
+            <button
+              onClick={handleStartTrial}
+              className="px-4 py-2 rounded bg-emerald-600 text-white hover:bg-emerald-500"
+            >
+              Start Free Trial
+            </button>
+          )}
+          {plans.map(plan => (
+            <button
+              key={plan.type}
+              onClick={() => handleSubscribe(plan)}
+              className="px-4 py-2 rounded bg-indigo-600 text-white hover:bg-indigo-500"
+            >
+              {paidUntil ? 'Extend' : 'Subscribe'}: {plan.name} ¥{(plan.price / 100).toFixed(2)} / {plan.duration_days} days
+            </button>
+          ))}
+        </div>
       </div>
 
       {subscription && (
         <div className="text-slate-300">
-          Subscription: {subscription.plan} (expires {new Date(subscription.expires_at).toLocaleString()})
+          Subscription: {subscription.plan}{subscription.trial && ' (trial)'} ({subscription.status}, expires {new Date(subscription.expires_at).toLocaleString()})
+          {paidUntil && paidUntil !== subscription.expires_at && (
+            <> · paid until {new Date(paidUntil).toLocaleString()}</>
+          )}
+          {subscription.status === 'past_due' && subscription.grace_ends_at && (
+            <> · renewal unpaid, access kept until {new Date(subscription.grace_ends_at).toLocaleString()}</>
+          )}
+          {(subscription.status === 'active' || subscription.status === 'past_due') && (
+            <button onClick={handleToggleRenewal} className="ml-3 text-indigo-400 hover:text-indigo-300 underline">
+              {subscription.auto_renew ? 'Cancel auto-renewal' : 'Resume auto-renewal'}
+            </button>
+          )}
         </div>
       )}
 
@@ -170,6 +322,68 @@ function AccountPool() {
         </div>
       )}
 
+      {myAccounts && (
+        <div className="p-4 rounded bg-slate-800 border border-slate-700 text-slate-200 space-y-3">
+          <div className="font-semibold">My Accounts</div>
+          <div>
+            <div className="text-sm text-slate-400">Exclusive</div>
+            {myAccounts.exclusive.length === 0 ? (
+              <div className="text-sm text-slate-500">None</div>
+            ) : (
+              myAccounts.exclusive.map(item => (
+                <div key={item.purchase_id} className="text-sm flex items-center gap-2">
+                  <span>{item.account.main}</span>
+                  <span className="text-slate-500">purchased {new Date(item.purchased_at).toLocaleDateString()}</span>
+                  <button
+                    onClick={() => loadCredentials(item.account.id)}
+                    className="text-indigo-400 hover:text-indigo-300"
+                  >
+                    Credentials
+                  </button>
+                </div>
+              ))
+            )}
+          </div>
+          <div>
+            <div className="text-sm text-slate-400">Temporary</div>
+            {myAccounts.temporary.active.length === 0 ? (
+              <div className="text-sm text-slate-500">No active claims</div>
+            ) : (
+              myAccounts.temporary.active.map(item => (
+                <div key={item.usage_id} className="text-sm">
+                  {item.account.main} <span className="text-slate-500">until {new Date(item.expires_at).toLocaleString()}</span>
+                </div>
+              ))
+            )}
+            {myAccounts.temporary.history.length > 0 && (
+              <div className="text-xs text-slate-500 mt-1">
+                {myAccounts.temporary.history.length} past claim(s)
+              </div>
+            )}
+          </div>
+          <div>
+            <div className="text-sm text-slate-400">Family</div>
+            {myAccounts.family.active.length === 0 ? (
+              <div className="text-sm text-slate-500">No bindings</div>
+            ) : (
+              myAccounts.family.active.map(item => (
+                <div key={item.binding_id} className="text-sm">
+                  {item.account.main} <span className="text-slate-500">as {item.member_email}</span>
+                  {item.family && (
+                    <span className="text-slate-500"> ({item.family.used}/{item.family.capacity})</span>
+                  )}
+                </div>
+              ))
+            )}
+            {myAccounts.family.history.length > 0 && (
+              <div className="text-xs text-slate-500 mt-1">
+                {myAccounts.family.history.length} past binding(s)
+              </div>
+            )}
+          </div>
+        </div>
+      )}
+
       {credentials && (
         <div className="p-4 rounded bg-slate-800 border border-slate-700 text-slate-200">
           <div className="font-semibold mb-2">Exclusive Credentials</div>
@@ -190,6 +404,13 @@ function AccountPool() {
                 <span className="text-xs px-2 py-1 rounded bg-slate-700 text-slate-300">{account.type}</span>
               </div>
               <div className="text-sm text-slate-400 mt-1">Status: {account.status}</div>
+              {account.claimed_by_me && account.claim_expires_at && (
+                <div className="text-sm text-emerald-400 mt-1">
+                  Claimed by you until {new Date(account.claim_expires_at).toLocaleString()}
+                </div>
+              )}
+              {account.bound_by_me && <div className="text-sm text-cyan-400 mt-1">You are bound to this family</div>}
+              {account.owned_by_me && <div className="text-sm text-indigo-400 mt-1">You own this account</div>}
               {account.family && (
                 <div className="text-sm text-slate-400 mt-1">
                   Family: {account.family.used}/{account.family.capacity}
@@ -249,6 +470,18 @@ function AccountPool() {
           ))}
         </div>
       )}
+
+      {!loading && nextCursor && (
+        <div className="flex justify-center">
+          <button
+            onClick={loadMoreAccounts}
+            disabled={loadingMore}
+            className="px-4 py-2 rounded bg-slate-700 text-white hover:bg-slate-600 disabled:opacity-50"
+          >
+            {loadingMore ? 'Loading...' : 'Load more'}
+          </button>
+        </div>
+      )}
     </div>
   )
 }
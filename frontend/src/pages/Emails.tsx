@@ -40,6 +40,56 @@ interface EmailImportOption {
   count: number
 }
 
+interface EmailJob<T> {
+  id: number
+  type: 'import' | 'verify'
+  status: 'queued' | 'running' | 'done' | 'failed'
+  total: number
+  error?: string
+  result?: T
+}
+
+interface ImportResult {
+  message: string
+  imported: number
+  import_id: number
+  import_name: string
+}
+
+interface VerifyResult {
+  results: Array<{ email: string; status: string; error?: string }>
+  total: number
+  cached: number
+  method: string
+}
+
+// 导入和验证在后台队列执行，轮询任务直到完成，失败时抛出任务的错误信息
+async function waitForEmailJob<T>(jobId: number): Promise<T> {
+  for (;;) {
+    const response = await api.get<EmailJob<T>>(`/emails/jobs/${jobId}`)
+    const job = response.data
+    if (job.status === 'done' && job.result) {
+      return job.result
+    }
+    if (job.status === 'failed') {
+      throw new Error(job.error || 'Job failed')
+    }
+    await new Promise(resolve => setTimeout(resolve, 2000))
+  }
+}
+
+// 请求错误优先显示接口返回的 error，任务失败时显示任务的错误信息
+function jobErrorMessage(err: unknown, fallback: string): string {
+  const axiosError = err as AxiosError<{ error: string }>
+  if (axiosError.response?.data?.error) {
+    return axiosError.response.data.error
+  }
+  if (!axiosError.isAxiosError && err instanceof Error) {
+    return err.message
+  }
+  return fallback
+}
+
 function Emails() {
   const [emails, setEmails] = useState<Email[]>([])
   const [loading, setLoading] = useState(true)
@@ -213,16 +263,17 @@ function Emails() {
     formData.append('file', file)
 
     try {
-      const response = await api.post<{ message: string; imported: number; import_id: number; import_name: string }>('/emails/import', formData, {
+      const response = await api.post<{ job_id: number }>('/emails/import', formData, {
         headers: { 'Content-Type': 'multipart/form-data' }
       })
-      setImportMessage({ type: 'success', text: `${response.data.message} (${response.data.imported} emails)` })
+      setImportMessage({ type: 'success', text: 'Import queued, processing...' })
+      const result = await waitForEmailJob<ImportResult>(response.data.job_id)
+      setImportMessage({ type: 'success', text: `${result.message} (${result.imported} emails)` })
       await fetchImports()
-      setSelectedImportId(response.data.import_id)
-      fetchEmails(response.data.import_id)
+      setSelectedImportId(result.import_id)
+      fetchEmails(result.import_id)
     } catch (err) {
-      const axiosError = err as AxiosError<{ error: string }>
-      const errorMsg = axiosError.response?.data?.error || 'Import failed'
+      const errorMsg = jobErrorMessage(err, 'Import failed')
       setImportMessage({ type: 'error', text: errorMsg })
       if (errorMsg.includes('License Key') || errorMsg.includes('Key') || errorMsg.includes('额度')) {
         setShowLicenseInput(true)
@@ -289,17 +340,19 @@ function Emails() {
         }
       }
 
-      const response = await api.post<{ results: Array<{ email: string; status: string; error?: string }>; total: number; method: string }>('/emails/verify', payload, config)
+      const response = await api.post<{ job_id: number }>('/emails/verify', payload, config)
+      setImportMessage({ type: 'success', text: `Verifying ${emailsToVerify.length} emails...` })
+      const result = await waitForEmailJob<VerifyResult>(response.data.job_id)
 
-      const successCount = response.data.results.filter(r => r.status !== 'error').length
+      const successCount = result.results.filter(r => r.status !== 'error').length
       const methodName = verifyMethod === 'smtp' ? 'SMTP' : 'API'
-      setImportMessage({ type: 'success', text: `Verified ${successCount}/${response.data.total} emails successfully using ${methodName}` })
+      setImportMessage({ type: 'success', text: `Verified ${successCount}/${result.total} emails successfully using ${methodName}` })
 
       // 更新本地邮箱状态
       setEmails(prevEmails => prevEmails.map(email => {
-        const result = response.data.results.find(r => r.email === email.main)
-        if (result) {
-          return { ...email, status: result.status }
+        const match = result.results.find(r => r.email === email.main)
+        if (match) {
+          return { ...email, status: match.status }
         }
         return email
       }))
@@ -310,8 +363,7 @@ function Emails() {
       // 刷新 License Key 状态
       await checkLicenseKey()
     } catch (err) {
-      const axiosError = err as AxiosError<{ error: string }>
-      const errorMsg = axiosError.response?.data?.error || 'Verification failed'
+      const errorMsg = jobErrorMessage(err, 'Verification failed')
       setImportMessage({ type: 'error', text: errorMsg })
 
       // 如果是 License Key 相关错误，显示输入框
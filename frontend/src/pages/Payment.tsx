@@ -14,6 +14,8 @@ interface Order {
   id: number
   order_no: string
   amount: number
+  discount_amount: number
+  coupon_code?: string
   product_type: string
   quota_amount: number
   status: string
@@ -21,6 +23,13 @@ interface Order {
   created_at: string
 }
 
+interface Checkout {
+  method: string
+  pay_url?: string
+  qr_code?: string
+  expires_at: string
+}
+
 interface LicenseKey {
   id: number
   key_code: string
@@ -44,18 +53,31 @@ const Payment: React.FC = () => {
   const [activeTab, setActiveTab] = useState<'buy' | 'keys'>('buy')
   const [activeKey, setActiveKey] = useState(() => localStorage.getItem('license_key') || '')
 
-  // 模拟支付状态
+  // 支付状态
   const [paymentStep, setPaymentStep] = useState<'select' | 'pay' | 'success'>('select')
+  const [paymentMethods, setPaymentMethods] = useState<string[]>([])
+  const [checkout, setCheckout] = useState<Checkout | null>(null)
+  const [couponCode, setCouponCode] = useState('')
 
   useEffect(() => {
     fetchProducts()
     fetchMyKeys()
   }, [])
 
+  // 发起支付后轮询订单状态，支付平台回调到达后自动跳转
+  useEffect(() => {
+    if (paymentStep !== 'pay' || !currentOrder || !checkout) return
+    const timer = setInterval(() => {
+      checkOrderStatus(false)
+    }, 3000)
+    return () => clearInterval(timer)
+  }, [paymentStep, currentOrder, checkout])
+
   const fetchProducts = async () => {
     try {
       const response = await api.get('/payments/products')
       setProducts(response.data.products)
+      setPaymentMethods(response.data.payment_methods || [])
     } catch (err: any) {
       setError(err.response?.data?.error || '获取产品列表失败')
     }
@@ -83,8 +105,10 @@ const Payment: React.FC = () => {
     try {
       const response = await api.post('/payments/orders', {
         product_type: selectedProduct.type,
+        coupon_code: couponCode.trim() || undefined,
       })
       setCurrentOrder(response.data.order)
+      setCheckout(null)
       setPaymentStep('pay')
     } catch (err: any) {
       setError(err.response?.data?.error || '创建订单失败')
@@ -93,42 +117,115 @@ const Payment: React.FC = () => {
     }
   }
 
-  const handleSimulatePayment = async (method: 'alipay' | 'wechat') => {
+  const handleCheckout = async (method: string) => {
     if (!currentOrder) return
 
     setLoading(true)
     setError('')
 
     try {
-      // 模拟支付回调
-      const response = await api.post<{ license_key: { key_code: string } }>('/payments/notify', {
-        order_no: currentOrder.order_no,
-        transaction_id: `TXN${Date.now()}`,
-        payment_method: method,
-      })
+      const response = await api.post<{ checkout: Checkout }>(
+        `/payments/orders/${currentOrder.order_no}/checkout`,
+        { payment_method: method }
+      )
+      setCheckout(response.data.checkout)
+    } catch (err: any) {
+      setError(err.response?.data?.error || '发起支付失败')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  // 支付结果由支付平台回调确认，这里只查询订单状态；manual 时同时向支付平台查询
+  const checkOrderStatus = async (manual: boolean) => {
+    if (!currentOrder) return
+
+    if (manual) {
+      setLoading(true)
+      setError('')
+    }
+
+    try {
+      const response = await api.get<{ status: string }>(
+        `/payments/orders/${currentOrder.order_no}/status`,
+        { params: manual ? { refresh: true } : {} }
+      )
+      const status = response.data.status
+
+      if (status === 'pending') {
+        if (manual) setError('尚未收到支付结果，请完成支付后稍候再试')
+        return
+      }
+      if (status !== 'paid') {
+        setError('订单已失效，请重新下单')
+        return
+      }
 
       setSuccess('支付成功！License Key 已生成')
       setPaymentStep('success')
-      if (response.data?.license_key?.key_code) {
-        localStorage.setItem('license_key', response.data.license_key.key_code)
-        setActiveKey(response.data.license_key.key_code)
-      }
+      setCheckout(null)
 
       // 刷新密钥列表
       await fetchMyKeys()
+    } catch (err: any) {
+      if (manual) setError(err.response?.data?.error || '查询订单失败')
+    } finally {
+      if (manual) setLoading(false)
+    }
+  }
+
+  const showMyKeys = () => {
+    setActiveTab('keys')
+    setPaymentStep('select')
+    setCurrentOrder(null)
+    setSelectedProduct(null)
+    setSuccess('')
+  }
+
+  // 发票需要携带登录凭证，通过接口下载后保存为文件
+  const downloadInvoice = async () => {
+    if (!currentOrder) return
+
+    setError('')
+    try {
+      const response = await api.get<Blob>(`/payments/orders/${currentOrder.order_no}/invoice`, {
+        params: { format: 'pdf' },
+        responseType: 'blob',
+      })
+      const url = URL.createObjectURL(response.data)
+      const link = document.createElement('a')
+      link.href = url
+      link.download = `invoice-${currentOrder.order_no}.pdf`
+      link.click()
+      URL.revokeObjectURL(url)
+    } catch {
+      setError('下载发票失败，请稍后重试')
+    }
+  }
+
+  const handleCancelOrder = async () => {
+    if (!currentOrder) return
 
-      // 3秒后切换到密钥列表
-      setTimeout(() => {
-        setActiveTab('keys')
-        setPaymentStep('select')
-        setCurrentOrder(null)
-        setSelectedProduct(null)
-      }, 3000)
+    setLoading(true)
+    setError('')
+
+    try {
+      await api.post(`/payments/orders/${currentOrder.order_no}/cancel`)
     } catch (err: any) {
-      setError(err.response?.data?.error || '支付失败')
+      // 订单已支付时不能取消，交给状态查询处理
+      if (err.response?.status === 409 && err.response?.data?.status === 'paid') {
+        setLoading(false)
+        await checkOrderStatus(true)
+        return
+      }
     } finally {
       setLoading(false)
     }
+
+    setPaymentStep('select')
+    setCurrentOrder(null)
+    setSelectedProduct(null)
+    setCheckout(null)
   }
 
   const applyKey = (keyCode: string) => {
@@ -137,6 +234,12 @@ const Payment: React.FC = () => {
     setSuccess('已设置为当前使用的 License Key')
   }
 
+  const paymentMethodLabels: { [key: string]: string } = {
+    alipay: '支付宝',
+    wechat: '微信支付',
+    local: '模拟支付',
+  }
+
   const formatPrice = (price: number) => {
     return (price / 100).toFixed(2)
   }
@@ -266,6 +369,15 @@ const Payment: React.FC = () => {
                           </li>
                         ))}
                       </ul>
+                      {selectedProduct?.type === product.type && (
+                        <input
+                          type="text"
+                          value={couponCode}
+                          onChange={(e) => setCouponCode(e.target.value)}
+                          placeholder="优惠码（可选）"
+                          className="w-full mb-2 px-3 py-2 border border-gray-300 rounded"
+                        />
+                      )}
                       {selectedProduct?.type === product.type && (
                         <button
                           onClick={handleCreateOrder}
@@ -296,6 +408,12 @@ const Payment: React.FC = () => {
                     <span className="text-gray-600">产品:</span>
                     <span>{getProductTypeBadge(currentOrder.product_type)}</span>
                   </div>
+                  {currentOrder.discount_amount > 0 && (
+                    <div className="flex justify-between mb-2">
+                      <span className="text-gray-600">优惠 ({currentOrder.coupon_code}):</span>
+                      <span className="text-green-600">-¥{formatPrice(currentOrder.discount_amount)}</span>
+                    </div>
+                  )}
                   <div className="flex justify-between">
                     <span className="text-gray-600">金额:</span>
                     <span className="text-2xl font-bold text-blue-600">
@@ -304,82 +422,66 @@ const Payment: React.FC = () => {
                   </div>
                 </div>
 
-                {/* 收款码展示区域 */}
+                {/* 支付方式 */}
                 <div className="mb-6">
-                  <div className="grid grid-cols-2 gap-4">
-                    {/* 支付宝收款码 */}
-                    <div className="text-center">
-                      <div className="bg-gray-100 h-64 flex items-center justify-center rounded mb-2">
-                        <div className="text-center">
-                          <svg
-                            className="w-32 h-32 mx-auto text-gray-400"
-                            fill="none"
-                            stroke="currentColor"
-                            viewBox="0 0 24 24"
-                          >
-                            <path
-                              strokeLinecap="round"
-                              strokeLinejoin="round"
-                              strokeWidth={1}
-                              d="M12 4v1m6 11h2m-6 0h-2v4m0-11v3m0 0h.01M12 12h4.01M16 20h4M4 12h4m12 0h.01M5 8h2a1 1 0 001-1V5a1 1 0 00-1-1H5a1 1 0 00-1 1v2a1 1 0 001 1zm12 0h2a1 1 0 001-1V5a1 1 0 00-1-1h-2a1 1 0 00-1 1v2a1 1 0 001 1zM5 20h2a1 1 0 001-1v-2a1 1 0 00-1-1H5a1 1 0 00-1 1v2a1 1 0 001 1z"
-                            />
-                          </svg>
-                          <p className="text-sm text-gray-500 mt-2">支付宝收款码</p>
-                        </div>
-                      </div>
-                      <button
-                        onClick={() => handleSimulatePayment('alipay')}
-                        disabled={loading}
-                        className="w-full bg-blue-600 text-white py-2 px-4 rounded hover:bg-blue-700 disabled:bg-gray-400"
-                      >
-                        {loading ? '处理中...' : '模拟支付宝支付'}
-                      </button>
+                  {paymentMethods.length === 0 ? (
+                    <p className="text-center text-gray-500">暂无可用的支付方式</p>
+                  ) : (
+                    <div className="grid grid-cols-2 gap-4">
+                      {paymentMethods.map((method) => (
+                        <button
+                          key={method}
+                          onClick={() => handleCheckout(method)}
+                          disabled={loading}
+                          className={`py-2 px-4 rounded border ${
+                            checkout?.method === method
+                              ? 'border-blue-500 text-blue-600'
+                              : 'border-gray-300 text-gray-700 hover:border-gray-400'
+                          } disabled:text-gray-400`}
+                        >
+                          {paymentMethodLabels[method] || method}
+                        </button>
+                      ))}
                     </div>
-
-                    {/* 微信收款码 */}
-                    <div className="text-center">
-                      <div className="bg-gray-100 h-64 flex items-center justify-center rounded mb-2">
-                        <div className="text-center">
-                          <svg
-                            className="w-32 h-32 mx-auto text-gray-400"
-                            fill="none"
-                            stroke="currentColor"
-                            viewBox="0 0 24 24"
-                          >
-                            <path
-                              strokeLinecap="round"
-                              strokeLinejoin="round"
-                              strokeWidth={1}
-                              d="M12 4v1m6 11h2m-6 0h-2v4m0-11v3m0 0h.01M12 12h4.01M16 20h4M4 12h4m12 0h.01M5 8h2a1 1 0 001-1V5a1 1 0 00-1-1H5a1 1 0 00-1 1v2a1 1 0 001 1zm12 0h2a1 1 0 001-1V5a1 1 0 00-1-1h-2a1 1 0 00-1 1v2a1 1 0 001 1zM5 20h2a1 1 0 001-1v-2a1 1 0 00-1-1H5a1 1 0 00-1 1v2a1 1 0 001 1z"
-                            />
-                          </svg>
-                          <p className="text-sm text-gray-500 mt-2">微信收款码</p>
+                  )}
+
+                  {checkout && (
+                    <div className="mt-6 text-center">
+                      {checkout.qr_code && (
+                        <div className="bg-gray-100 p-4 rounded mb-4">
+                          <p className="text-sm text-gray-500 mb-2">二维码内容</p>
+                          <p className="font-mono text-xs break-all">{checkout.qr_code}</p>
                         </div>
-                      </div>
+                      )}
+                      {checkout.pay_url && (
+                        <a
+                          href={checkout.pay_url}
+                          target="_blank"
+                          rel="noopener noreferrer"
+                          className="inline-block mb-4 bg-blue-600 text-white py-2 px-6 rounded hover:bg-blue-700"
+                        >
+                          前往支付
+                        </a>
+                      )}
                       <button
-                        onClick={() => handleSimulatePayment('wechat')}
+                        onClick={() => checkOrderStatus(true)}
                         disabled={loading}
                         className="w-full bg-green-600 text-white py-2 px-4 rounded hover:bg-green-700 disabled:bg-gray-400"
                       >
-                        {loading ? '处理中...' : '模拟微信支付'}
+                        {loading ? '查询中...' : '我已完成支付'}
                       </button>
                     </div>
-                  </div>
+                  )}
                 </div>
 
                 <div className="text-center text-sm text-gray-500">
                   <p>请在 15 分钟内完成支付</p>
-                  <p className="mt-2">
-                    实际使用时，这里会显示真实的收款码图片
-                  </p>
+                  <p className="mt-2">支付完成后页面会自动跳转</p>
                 </div>
 
                 <button
-                  onClick={() => {
-                    setPaymentStep('select')
-                    setCurrentOrder(null)
-                    setSelectedProduct(null)
-                  }}
+                  onClick={handleCancelOrder}
+                  disabled={loading}
                   className="mt-4 w-full text-gray-600 hover:text-gray-800"
                 >
                   取消订单
@@ -405,8 +507,21 @@ const Payment: React.FC = () => {
                   </svg>
                 </div>
                 <h2 className="text-2xl font-bold text-gray-900 mb-2">支付成功！</h2>
-                <p className="text-gray-600 mb-4">License Key 已生成，请在"我的 Key"中查看</p>
-                <p className="text-sm text-gray-500">3 秒后自动跳转...</p>
+                <p className="text-gray-600 mb-6">License Key 已生成，请在"我的 Key"中查看</p>
+                <div className="flex justify-center gap-3">
+                  <button
+                    onClick={showMyKeys}
+                    className="px-4 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700"
+                  >
+                    查看我的 Key
+                  </button>
+                  <button
+                    onClick={downloadInvoice}
+                    className="px-4 py-2 border border-gray-300 text-gray-700 rounded-md hover:bg-gray-50"
+                  >
+                    下载发票
+                  </button>
+                </div>
               </div>
             )}
           </>